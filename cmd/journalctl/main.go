@@ -0,0 +1,237 @@
+// Command journalctl inspects and repairs the engine's journal.
+//
+//	journalctl segments [-dir DIR]
+//	journalctl print [-dir DIR] [-json] [-from SEQ] [-to SEQ] [-type TYPE,...] [-order ID] [-trader NAME]
+//	journalctl verify [-dir DIR]
+//	journalctl truncate [-dir DIR]
+//
+// Only truncate writes, and it must not run while an engine has the journal
+// open.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"matching-engine/internal/config"
+	"matching-engine/internal/engine"
+	"matching-engine/internal/journal"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const usage = `usage: journalctl <command> [flags]
+
+commands:
+  segments  list the segment files and the records in each
+  print     print records, optionally filtered
+  verify    check checksums and sequence numbers
+  truncate  cut a torn record off the end of the last segment
+
+Run journalctl <command> -h for the flags of a command.
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	dir := flags.String("dir", config.JournalDir, "journal directory")
+	dataDir := flags.String("data", "", "engine data directory, overriding -dir with its journal")
+	var run func() error
+	switch command {
+	case "segments":
+		run = func() error { return segments(journalDir(*dir, *dataDir)) }
+	case "print":
+		var filter recordFilter
+		asJSON := flags.Bool("json", false, "print one JSON object per record")
+		flags.Uint64Var(&filter.from, "from", 0, "first sequence number to print")
+		flags.Uint64Var(&filter.to, "to", 0, "last sequence number to print; 0 prints to the end")
+		types := flags.String("type", "", "record types to print, comma separated")
+		flags.StringVar(&filter.orderID, "order", "", "only print records about this order")
+		flags.StringVar(&filter.trader, "trader", "", "only print records about this trader or their orders; orders are matched to traders from -from on")
+		run = func() error {
+			if err := filter.parseTypes(*types); err != nil {
+				return err
+			}
+			return printRecords(journalDir(*dir, *dataDir), filter, *asJSON)
+		}
+	case "verify":
+		run = func() error { return verify(journalDir(*dir, *dataDir)) }
+	case "truncate":
+		run = func() error { return truncate(journalDir(*dir, *dataDir)) }
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	flags.Parse(args)
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func journalDir(dir string, dataDir string) string {
+	if dataDir != "" {
+		return filepath.Join(dataDir, "journal")
+	}
+	return dir
+}
+
+func segments(dir string) error {
+	reports, err := journal.Inspect(dir)
+	if err != nil {
+		return err
+	}
+	if len(reports) == 0 {
+		fmt.Printf("No segments in %s\n", dir)
+		return nil
+	}
+	fmt.Printf("%-28s %10s %10s %8s %12s  %s\n", "SEGMENT", "FIRST", "LAST", "RECORDS", "BYTES", "STATUS")
+	for _, report := range reports {
+		status := "ok"
+		if report.Err != nil {
+			status = fmt.Sprintf("damaged after %d bytes: %v", report.End, report.Err)
+		} else if report.Gap != 0 {
+			status = fmt.Sprintf("sequence jumps to %d", report.Gap)
+		}
+		fmt.Printf("%-28s %10d %10d %8d %12d  %s\n",
+			filepath.Base(report.Path), report.First, report.Last, report.Records, report.Size, status)
+	}
+	return nil
+}
+
+// recordFilter selects the records print shows
+type recordFilter struct {
+	from    uint64
+	to      uint64
+	types   map[journal.RecordType]bool
+	orderID string
+	trader  string
+	// traders maps the orders seen so far to their traders, so cancels,
+	// amends and pool results match a trader too
+	traders map[string]string
+}
+
+func (f *recordFilter) parseTypes(types string) error {
+	if types == "" {
+		return nil
+	}
+	f.types = make(map[journal.RecordType]bool)
+	for _, name := range strings.Split(types, ",") {
+		recordType, ok := journal.ParseRecordType(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("unknown record type %q", name)
+		}
+		f.types[recordType] = true
+	}
+	return nil
+}
+
+func (f *recordFilter) match(record journal.Record) (bool, error) {
+	if f.orderID == "" && f.trader == "" {
+		return f.types == nil || f.types[record.Type], nil
+	}
+
+	// Orders are mapped to their traders from every record, including the
+	// ones -type leaves out, so a cancel still finds the trader of its order
+	subject, err := engine.SubjectOf(record)
+	if err != nil {
+		return false, err
+	}
+	if subject.OrderID != "" {
+		if subject.Trader != "" {
+			f.traders[subject.OrderID] = subject.Trader
+		} else {
+			subject.Trader = f.traders[subject.OrderID]
+		}
+	}
+	if f.types != nil && !f.types[record.Type] {
+		return false, nil
+	}
+	return (f.orderID == "" || subject.OrderID == f.orderID) &&
+		(f.trader == "" || subject.Trader == f.trader), nil
+}
+
+// errDone stops reading once past -to
+var errDone = errors.New("done")
+
+func printRecords(dir string, filter recordFilter, asJSON bool) error {
+	filter.traders = make(map[string]string)
+	encoder := json.NewEncoder(os.Stdout)
+	err := journal.Read(dir, filter.from, func(record journal.Record) error {
+		if filter.to > 0 && record.Seq > filter.to {
+			return errDone
+		}
+		ok, err := filter.match(record)
+		if err != nil || !ok {
+			return err
+		}
+		if asJSON {
+			return encoder.Encode(jsonRecord(record))
+		}
+		fmt.Printf("%10d  %s  %-12s %s\n", record.Seq, record.Time.UTC().Format(time.RFC3339Nano), record.Type, record.Payload)
+		return nil
+	})
+	if errors.Is(err, errDone) {
+		return nil
+	}
+	return err
+}
+
+func jsonRecord(record journal.Record) interface{} {
+	var payload interface{} = string(record.Payload)
+	if json.Valid(record.Payload) {
+		payload = json.RawMessage(record.Payload)
+	}
+	return struct {
+		Seq     uint64      `json:"seq"`
+		Type    string      `json:"type"`
+		Time    time.Time   `json:"time"`
+		Payload interface{} `json:"payload"`
+	}{record.Seq, record.Type.String(), record.Time.UTC(), payload}
+}
+
+func verify(dir string) error {
+	problems, err := journal.Verify(dir)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("Journal ok")
+		return nil
+	}
+	torn := false
+	for _, problem := range problems {
+		fmt.Println(problem)
+		torn = torn || errors.Is(problem, journal.ErrTornTail)
+	}
+	if torn {
+		fmt.Println("Run journalctl truncate to cut off the torn tail")
+	}
+	os.Exit(1)
+	return nil
+}
+
+func truncate(dir string) error {
+	removed, err := journal.TruncateTail(dir)
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		fmt.Println("No torn tail to truncate")
+		return nil
+	}
+	fmt.Printf("Truncated %d bytes from the end of the journal\n", removed)
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"matching-engine/internal/journal"
+	"reflect"
+	"testing"
+)
+
+func TestFilterMatchesTradersOfFilteredOutOrders(t *testing.T) {
+	records := []journal.Record{
+		{Seq: 1, Type: journal.RecordNewOrder, Payload: []byte(`{"ID":"o1","Trader":"alice"}`)},
+		{Seq: 2, Type: journal.RecordNewOrder, Payload: []byte(`{"ID":"o2","Trader":"bob"}`)},
+		{Seq: 3, Type: journal.RecordAmend, Payload: []byte(`{"order_id":"o1","price":101}`)},
+		{Seq: 4, Type: journal.RecordCancel, Payload: []byte(`{"order_id":"o2"}`)},
+		{Seq: 5, Type: journal.RecordCancel, Payload: []byte(`{"order_id":"o1"}`)},
+		{Seq: 6, Type: journal.RecordDeposit, Payload: []byte(`{"trader":"alice","asset":"USD","amount":5}`)},
+	}
+	tests := []struct {
+		types   string
+		orderID string
+		trader  string
+		want    []uint64
+	}{
+		{types: "cancel,amend", trader: "alice", want: []uint64{3, 5}},
+		{types: "cancel", trader: "bob", want: []uint64{4}},
+		{types: "cancel,amend", orderID: "o1", want: []uint64{3, 5}},
+		{trader: "alice", want: []uint64{1, 3, 5, 6}},
+		{types: "new_order", want: []uint64{1, 2}},
+	}
+	for _, test := range tests {
+		filter := recordFilter{orderID: test.orderID, trader: test.trader, traders: make(map[string]string)}
+		if err := filter.parseTypes(test.types); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]uint64, 0)
+		for _, record := range records {
+			ok, err := filter.match(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				got = append(got, record.Seq)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("-type %q -order %q -trader %q: expected records %v, got %v",
+				test.types, test.orderID, test.trader, test.want, got)
+		}
+	}
+}
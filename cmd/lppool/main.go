@@ -0,0 +1,81 @@
+// Command lppool serves a mock liquidity pool on the HTTP contract of
+// liquiditypool.Client so the matching engine can run end to end locally.
+//
+//	go run ./cmd/lppool -prices BTC=30000,ETH=2000 -liquidity BTC=10,ETH=200
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"matching-engine/internal/engine/liquiditypool/mockpool"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":8081", "address to listen on")
+	prices := flag.String("prices", "BTC=30000,ETH=2000", "asset prices as ASSET=PRICE,...")
+	liquidity := flag.String("liquidity", "BTC=10,ETH=200", "tradable amount per asset as ASSET=AMOUNT,...")
+	quoteTTL := flag.Duration("quote-ttl", mockpool.DefaultQuoteTTL, "how long quotes can be executed")
+	latency := flag.Duration("latency", 0, "delay added to every response")
+	fillRatio := flag.Float64("fill-ratio", 1, "share of each quote an execution fills")
+	errorRate := flag.Float64("error-rate", 0, "probability a request fails with 503")
+	loseRate := flag.Float64("lose-rate", 0, "probability an execution trades but its response is lost")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed for injected failures")
+	flag.Parse()
+
+	pool := mockpool.NewPool()
+	priceMap, err := parseAmounts(*prices)
+	if err != nil {
+		log.Fatalf("-prices: %v", err)
+	}
+	for asset, price := range priceMap {
+		pool.SetPrice(asset, price)
+	}
+	liquidityMap, err := parseAmounts(*liquidity)
+	if err != nil {
+		log.Fatalf("-liquidity: %v", err)
+	}
+	for asset, amount := range liquidityMap {
+		pool.SetLiquidity(asset, amount)
+	}
+	pool.SetQuoteTTL(*quoteTTL)
+	pool.SetFaults(mockpool.Faults{
+		Latency:   *latency,
+		FillRatio: *fillRatio,
+		ErrorRate: *errorRate,
+		LoseRate:  *loseRate,
+	}, *seed)
+
+	log.Printf("Mock liquidity pool listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, pool))
+}
+
+// parseAmounts reads ASSET=VALUE pairs separated by commas. Values must be
+// finite and not negative, and each asset may appear once.
+func parseAmounts(s string) (map[string]float64, error) {
+	amounts := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		asset, value, ok := strings.Cut(pair, "=")
+		asset = strings.TrimSpace(asset)
+		if !ok || asset == "" {
+			return nil, fmt.Errorf("expected ASSET=VALUE, got %q", pair)
+		}
+		amount, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || amount < 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+			return nil, fmt.Errorf("invalid value for %s: %q", asset, value)
+		}
+		if _, ok := amounts[asset]; ok {
+			return nil, fmt.Errorf("%s given more than once", asset)
+		}
+		amounts[asset] = amount
+	}
+	return amounts, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAmounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{name: "pairs", input: "BTC=30000,ETH=2000", want: map[string]float64{"BTC": 30000, "ETH": 2000}},
+		{name: "spaces and empty entries", input: " BTC = 1.5 ,, ETH=0 ,", want: map[string]float64{"BTC": 1.5, "ETH": 0}},
+		{name: "empty", input: "", want: map[string]float64{}},
+		{name: "missing equals", input: "BTC30000", wantErr: true},
+		{name: "missing asset", input: "=5", wantErr: true},
+		{name: "missing value", input: "BTC=", wantErr: true},
+		{name: "not a number", input: "BTC=lots", wantErr: true},
+		{name: "negative", input: "BTC=-1", wantErr: true},
+		{name: "not finite", input: "BTC=NaN", wantErr: true},
+		{name: "infinite", input: "BTC=+Inf", wantErr: true},
+		{name: "duplicate", input: "BTC=1,ETH=2,BTC=3", wantErr: true},
+		{name: "duplicate after trimming", input: "BTC=1, BTC =1", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseAmounts(test.input)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q, got %v", test.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error for %q: %v", test.input, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -1,13 +1,31 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+	"github.com/gorilla/mux"
+	"matching-engine/internal/account"
 	"matching-engine/internal/engine"
 	"matching-engine/internal/engine/liquiditypool"
+	"matching-engine/internal/engine/liquiditypool/amm"
+	"matching-engine/internal/events"
 	"matching-engine/internal/config"
+	"matching-engine/internal/fees"
+	"matching-engine/internal/handlers"
+	"matching-engine/internal/hedge"
+	"matching-engine/internal/history"
+	"matching-engine/internal/journal"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/margin"
+	"matching-engine/internal/replication"
+	"matching-engine/pkg/utils"
 )
 
 var matchingEngine *engine.MatchingEngine
@@ -23,6 +41,7 @@ type OrderRequest struct {
 	MarginType      string  `json:"margin_type"`
 	StopLossPrice   float64 `json:"stop_loss_price"`
 	TakeProfitPrice float64 `json:"take_profit_price"`
+	Expiration      int64   `json:"expiration"`
 }
 
 func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
@@ -47,6 +66,7 @@ func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 		Leverage:        orderReq.Leverage,
 		StopLossPrice:   orderReq.StopLossPrice,
 		TakeProfitPrice: orderReq.TakeProfitPrice,
+		Expiration:      orderReq.Expiration,
 	}
 
 	// Determine order type
@@ -77,17 +97,234 @@ func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	// Flags override the configured addresses and paths, so a primary and a
+	// standby can run side by side on one machine
+	dataDir := flag.String("data", "", "directory for the journal and snapshots, overriding their configured paths")
+	flag.StringVar(&config.HTTPAddr, "addr", config.HTTPAddr, "HTTP listen address")
+	flag.StringVar(&config.ReplicationRole, "role", config.ReplicationRole, "primary or standby")
+	flag.StringVar(&config.ReplicationAddr, "replication-addr", config.ReplicationAddr, "address a primary serves standbys on")
+	flag.StringVar(&config.PrimaryAddr, "primary", config.PrimaryAddr, "replication address of the primary a standby follows")
+	flag.StringVar(&config.EpochFile, "epoch-file", config.EpochFile, "epoch file shared by the primary and its standbys")
+	flag.DurationVar(&config.PromoteAfter, "promote-after", config.PromoteAfter, "silence from the primary before a standby promotes itself; 0 waits for POST /api/admin/promote")
+	flag.StringVar(&config.TradingMode, "mode", config.TradingMode, "spot or margin settlement")
+	verifyBooks := flag.Bool("verify-books", false, "replay the latest snapshot and the journal, compare the book checksums with the events file and exit")
+	flag.Parse()
+	if *dataDir != "" {
+		config.JournalDir = filepath.Join(*dataDir, "journal")
+		config.SnapshotDir = filepath.Join(*dataDir, "snapshots")
+		config.EventsFile = filepath.Join(*dataDir, "events.jsonl")
+		config.HistoryFile = filepath.Join(*dataDir, "history.jsonl")
+	}
+
 	// Initialize liquidity pool client
-	lpClient := liquiditypool.NewClient(config.LiquidityPoolURL)
+	breakerConfig := liquiditypool.BreakerConfig{
+		FailureThreshold: config.PoolBreakerThreshold,
+		OpenTimeout:      config.PoolBreakerOpenTimeout,
+		MaxRetries:       config.PoolReadRetries,
+		RetryBackoff:     config.PoolRetryBackoff,
+		RetryTrades:      config.PoolRetryTrades,
+	}
+	lpClient := liquiditypool.NewBreaker(liquiditypool.NewClient(config.LiquidityPoolURL), breakerConfig)
 	
 	// Initialize matching engine with liquidity pool
 	matchingEngine = engine.NewMatchingEngine(lpClient)
+	matchingEngine.SetPoolTimeout(config.LiquidityPoolTimeout)
+	matchingEngine.SetRouteBudget(config.LiquidityRouteBudget)
+	for _, venue := range config.LiquidityVenues {
+		matchingEngine.AddVenue(liquiditypool.Venue{
+			Name:    venue.Name,
+			Client:  liquiditypool.NewBreaker(liquiditypool.NewClient(venue.URL), breakerConfig),
+			FeeRate: venue.FeeRate,
+		})
+	}
+	if len(config.AMMReserves) > 0 {
+		pool := amm.New(config.AMMFeeRate)
+		for asset, reserves := range config.AMMReserves {
+			if err := pool.AddPool(asset, reserves[0], reserves[1]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		// The AMM fee is already in its prices
+		matchingEngine.AddVenue(liquiditypool.Venue{Name: "amm", Client: pool})
+	}
+
+	// Enforce balances and holds for every trader, journaling every movement
+	accounts := account.NewManager()
+	accounts.SetLedger(ledger.New())
+	matchingEngine.SetAccountManager(accounts, config.QuoteAsset)
+
+	// Trade on margin when configured: cross positions share haircut
+	// collateral, isolated positions are ring-fenced
+	switch config.TradingMode {
+	case "spot":
+	case "margin":
+		marginManager := margin.NewManager(accounts, matchingEngine, margin.Config{
+			QuoteAsset:      config.QuoteAsset,
+			Haircuts:        config.CollateralHaircuts,
+			MaintenanceRate: config.MaintenanceMarginRate,
+		})
+		if err := matchingEngine.SetMarginManager(marginManager); err != nil {
+			log.Fatal(err)
+		}
+		collateral := make([]string, 0, len(config.CollateralHaircuts))
+		for asset := range config.CollateralHaircuts {
+			collateral = append(collateral, asset)
+		}
+		go matchingEngine.RefreshPrices(context.Background(), collateral, config.PriceRefreshInterval)
+	default:
+		log.Fatalf("unknown trading mode %q", config.TradingMode)
+	}
+
+	// Charge the default fee schedule until instruments get their own
+	matchingEngine.SetFeeCalculator(fees.NewCalculator(fees.Schedule{
+		Tiers:    []fees.Tier{{MakerRate: config.MakerFeeRate, TakerRate: config.TakerFeeRate}},
+		PoolRate: config.PoolFeeRate,
+	}))
+
+	// Check the books this configuration rebuilds against the ones the
+	// event stream recorded, without taking commands
+	if *verifyBooks {
+		divergence, err := matchingEngine.VerifyBooks(config.SnapshotDir, config.JournalDir, config.EventsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if divergence != nil {
+			fmt.Printf("Books diverge at %s\n", divergence)
+			os.Exit(1)
+		}
+		fmt.Println("Books match the recorded events")
+		return
+	}
+
+	// Journal every command before it is acknowledged
+	syncPolicy, err := journal.ParseSyncPolicy(config.JournalSync)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wal, err := journal.Open(config.JournalDir, journal.Options{
+		Sync:         syncPolicy,
+		SyncInterval: config.JournalSyncInterval,
+		SegmentSize:  config.JournalSegmentSize,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer wal.Close()
+
+	// Publish engine events. The publisher is set before recovery, so events
+	// lost from the file in a crash are published again by the replay.
+	eventFile, err := events.OpenFile(config.EventsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer eventFile.Close()
+	historyStore, err := history.Open(config.HistoryFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer historyStore.Close()
+	matchingEngine.SetPublisher(events.NewFanout(eventFile, historyStore))
+
+	// Hedge house exposure from pool fills at a separate venue. The hedger is
+	// set before recovery so replay rebuilds the house position, but only
+	// trades once this node is primary.
+	var hedger *hedge.Hedger
+	if config.HedgeVenueURL != "" {
+		hedger = hedge.NewHedger(
+			liquiditypool.NewBreaker(liquiditypool.NewClient(config.HedgeVenueURL), breakerConfig),
+			matchingEngine,
+			hedge.Config{
+				Thresholds:       config.HedgeThresholds,
+				DefaultThreshold: config.HedgeDefaultThreshold,
+				Timeout:          config.LiquidityPoolTimeout,
+			},
+		)
+		matchingEngine.SetHedger(hedger)
+	}
+	startHedger := func() {
+		if hedger != nil {
+			go hedger.Run(context.Background(), config.HedgeInterval)
+		}
+	}
+	serveStandbys := func(fence *replication.EpochFence) *replication.Primary {
+		primary := replication.NewPrimary(wal, fence)
+		go func() {
+			if err := primary.ListenAndServe(config.ReplicationAddr); err != nil {
+				utils.LogError(fmt.Errorf("serving standbys: %w", err))
+			}
+		}()
+		return primary
+	}
+
+	// Only the primary takes commands. A standby copies the primary's
+	// journal and applies it until it is promoted. Either first rebuilds the
+	// book and balances from the latest snapshot and the journal after it.
+	var node replication.Node
+	var last uint64
+	switch config.ReplicationRole {
+	case "primary":
+		if last, err = matchingEngine.Recover(config.SnapshotDir, config.JournalDir); err != nil {
+			log.Fatal(err)
+		}
+		fence, err := replication.AcquireFence(config.EpochFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		matchingEngine.SetFence(fence)
+		matchingEngine.SetJournal(wal)
+		node = serveStandbys(fence)
+		startHedger()
+		log.Printf("Primary at epoch %d", fence.Epoch())
+	case "standby":
+		if config.PrimaryAddr == "" {
+			log.Fatal("a standby needs -primary")
+		}
+		fence := replication.NewStandbyFence(config.EpochFile)
+		matchingEngine.SetFence(fence)
+		standby := replication.NewStandby(matchingEngine, wal, fence, config.PrimaryAddr)
+		if last, err = standby.Recover(config.SnapshotDir); err != nil {
+			log.Fatal(err)
+		}
+		standby.OnPromote = func() {
+			serveStandbys(fence)
+			startHedger()
+		}
+		go func() {
+			if err := standby.Run(context.Background(), config.PromoteAfter); err != nil {
+				utils.LogError(fmt.Errorf("following primary: %w", err))
+			}
+		}()
+		node = standby
+		log.Printf("Standby following %s", config.PrimaryAddr)
+	default:
+		log.Fatalf("unknown role %q", config.ReplicationRole)
+	}
+	if last > 0 {
+		log.Printf("Recovered state up to journal record %d", last)
+	}
+	go matchingEngine.RunSnapshots(context.Background(), config.SnapshotDir, config.SnapshotInterval, config.SnapshotsKept)
+
+	// Expire resting orders in the background
+	go func() {
+		for now := range time.Tick(time.Second) {
+			for _, order := range matchingEngine.ExpireOrders(now.Unix()) {
+				log.Printf("Order %s expired", order.ID)
+			}
+		}
+	}()
 
 	// Define routes
 	http.HandleFunc("/api/order", handleCreateOrder)
 
+	// Health and admin routes are served by the handlers package
+	router := mux.NewRouter()
+	handler := handlers.NewHandler(matchingEngine)
+	handler.SetReplication(node)
+	handler.SetHistory(historyStore)
+	handler.SetupRoutes(router)
+	http.Handle("/api/", router)
+
 	// Start server
-	port := ":8080"
-	fmt.Printf("Server starting on port %s\n", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	fmt.Printf("Server starting on %s\n", config.HTTPAddr)
+	log.Fatal(http.ListenAndServe(config.HTTPAddr, nil))
 }
\ No newline at end of file
@@ -0,0 +1,440 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"matching-engine/internal/ledger"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	ErrHoldNotFound      = errors.New("hold not found")
+)
+
+// Balance is the state of one asset in a trader's account
+type Balance struct {
+	Available float64 `json:"available"`
+	Held      float64 `json:"held"`
+}
+
+// Total returns available plus held funds
+func (b Balance) Total() float64 {
+	return b.Available + b.Held
+}
+
+// Hold reserves funds for a resting order
+type Hold struct {
+	OrderID string  `json:"order_id"`
+	Trader  string  `json:"trader"`
+	Asset   string  `json:"asset"`
+	Amount  float64 `json:"amount"`
+}
+
+// Transfer moves funds between two accounts. If HoldOrderID is set the funds
+// are taken from that order's hold instead of the available balance. Type
+// decides which journal entry the transfer is booked under.
+type Transfer struct {
+	From        string
+	To          string
+	Asset       string
+	Amount      float64
+	HoldOrderID string
+	Type        ledger.EntryType
+}
+
+// Manager keeps per-asset balances and order holds for all traders
+type Manager struct {
+	mu       sync.Mutex
+	balances map[string]map[string]*Balance
+	holds    map[string]*Hold
+	system   map[string]bool
+	ledger   *ledger.Ledger
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		balances: make(map[string]map[string]*Balance),
+		holds:    make(map[string]*Hold),
+		system:   make(map[string]bool),
+	}
+}
+
+// AddSystemAccount registers an account, such as the liquidity pool
+// counterparty, that is allowed to run a negative balance
+func (m *Manager) AddSystemAccount(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.system[name] = true
+}
+
+// SetLedger makes every balance movement post a journal entry
+func (m *Manager) SetLedger(l *ledger.Ledger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ledger = l
+}
+
+// Ledger returns the journal balance movements are posted to, if any
+func (m *Manager) Ledger() *ledger.Ledger {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ledger
+}
+
+// Deposit credits the available balance of a trader
+func (m *Manager) Deposit(trader string, asset string, amount float64) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.post(ledger.EntryDeposit, ledger.Reference{}, []Transfer{
+		{From: ledger.ExternalAccount, To: trader, Asset: asset, Amount: amount},
+	}); err != nil {
+		return err
+	}
+	m.balance(trader, asset).Available += amount
+	return nil
+}
+
+// Withdraw debits the available balance of a trader
+func (m *Manager) Withdraw(trader string, asset string, amount float64) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	balance := m.balance(trader, asset)
+	if balance.Available < amount {
+		return ErrInsufficientFunds
+	}
+	if err := m.post(ledger.EntryWithdrawal, ledger.Reference{}, []Transfer{
+		{From: trader, To: ledger.ExternalAccount, Asset: asset, Amount: amount},
+	}); err != nil {
+		return err
+	}
+	balance.Available -= amount
+	return nil
+}
+
+// CheckAvailable returns ErrInsufficientFunds if the trader cannot pay amount
+func (m *Manager) CheckAvailable(trader string, asset string, amount float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.system[trader] {
+		return nil
+	}
+	if m.balance(trader, asset).Available < amount {
+		return fmt.Errorf("%w: %s needs %.8f %s", ErrInsufficientFunds, trader, amount, asset)
+	}
+	return nil
+}
+
+// PlaceHold moves funds from available to held for a resting order
+func (m *Manager) PlaceHold(orderID string, trader string, asset string, amount float64) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	balance := m.balance(trader, asset)
+	if balance.Available < amount && !m.system[trader] {
+		return fmt.Errorf("%w: %s needs %.8f %s", ErrInsufficientFunds, trader, amount, asset)
+	}
+	balance.Available -= amount
+	balance.Held += amount
+
+	if hold, ok := m.holds[orderID]; ok {
+		hold.Amount += amount
+		return nil
+	}
+	m.holds[orderID] = &Hold{OrderID: orderID, Trader: trader, Asset: asset, Amount: amount}
+	return nil
+}
+
+// ReleaseHold returns whatever is left of an order's hold to the available
+// balance and returns the released amount
+func (m *Manager) ReleaseHold(orderID string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hold, ok := m.holds[orderID]
+	if !ok {
+		return 0
+	}
+	delete(m.holds, orderID)
+
+	balance := m.balance(hold.Trader, hold.Asset)
+	balance.Held -= hold.Amount
+	balance.Available += hold.Amount
+	return hold.Amount
+}
+
+// ReduceHold returns part of an order's hold to the available balance and
+// returns the amount actually released
+func (m *Manager) ReduceHold(orderID string, amount float64) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hold, ok := m.holds[orderID]
+	if !ok || amount <= 0 {
+		return 0
+	}
+	if amount > hold.Amount {
+		amount = hold.Amount
+	}
+	hold.Amount -= amount
+	if hold.Amount <= dust {
+		amount += hold.Amount
+		delete(m.holds, orderID)
+	}
+
+	balance := m.balance(hold.Trader, hold.Asset)
+	balance.Held -= amount
+	balance.Available += amount
+	return amount
+}
+
+// HoldFor returns the hold of an order
+func (m *Manager) HoldFor(orderID string) (Hold, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hold, ok := m.holds[orderID]
+	if !ok {
+		return Hold{}, false
+	}
+	return *hold, true
+}
+
+// Settle applies a set of transfers atomically: either all of them succeed
+// or none is applied. Transfers are journaled as one entry per Type, all
+// sharing ref.
+func (m *Manager) Settle(ref ledger.Reference, transfers ...Transfer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Validate everything first so a failure leaves balances untouched
+	needed := make(map[string]float64)
+	for _, t := range transfers {
+		if t.Amount <= 0 {
+			return ErrInvalidAmount
+		}
+		if t.HoldOrderID != "" {
+			hold, ok := m.holds[t.HoldOrderID]
+			if !ok || hold.Trader != t.From || hold.Asset != t.Asset {
+				return fmt.Errorf("%w: order %s", ErrHoldNotFound, t.HoldOrderID)
+			}
+			needed["hold:"+t.HoldOrderID] += t.Amount
+			if needed["hold:"+t.HoldOrderID] > hold.Amount+dust {
+				return fmt.Errorf("%w: hold of order %s", ErrInsufficientFunds, t.HoldOrderID)
+			}
+			continue
+		}
+		if m.system[t.From] {
+			continue
+		}
+		key := t.From + "/" + t.Asset
+		needed[key] += t.Amount
+		if needed[key] > m.balance(t.From, t.Asset).Available+dust {
+			return fmt.Errorf("%w: %s needs %.8f %s", ErrInsufficientFunds, t.From, needed[key], t.Asset)
+		}
+	}
+
+	// Group transfers into one journal entry per type, keeping first-seen order
+	types := make([]ledger.EntryType, 0)
+	byType := make(map[ledger.EntryType][]Transfer)
+	for _, t := range transfers {
+		entryType := t.Type
+		if entryType == "" {
+			entryType = ledger.EntryTrade
+		}
+		if _, ok := byType[entryType]; !ok {
+			types = append(types, entryType)
+		}
+		byType[entryType] = append(byType[entryType], t)
+	}
+	for _, entryType := range types {
+		if err := m.post(entryType, ref, byType[entryType]); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range transfers {
+		from := m.balance(t.From, t.Asset)
+		if t.HoldOrderID != "" {
+			hold := m.holds[t.HoldOrderID]
+			amount := t.Amount
+			if amount > hold.Amount {
+				amount = hold.Amount
+			}
+			hold.Amount -= amount
+			from.Held -= amount
+			from.Available -= t.Amount - amount
+		} else {
+			from.Available -= t.Amount
+		}
+		m.balance(t.To, t.Asset).Available += t.Amount
+	}
+	return nil
+}
+
+// Balance returns a trader's balance of one asset
+func (m *Manager) Balance(trader string, asset string) Balance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if assets, ok := m.balances[trader]; ok {
+		if balance, ok := assets[asset]; ok {
+			return *balance
+		}
+	}
+	return Balance{}
+}
+
+// Balances returns all balances of a trader keyed by asset
+func (m *Manager) Balances(trader string) map[string]Balance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	balances := make(map[string]Balance)
+	for asset, balance := range m.balances[trader] {
+		balances[asset] = *balance
+	}
+	return balances
+}
+
+// Traders returns every account holding a balance, sorted
+func (m *Manager) Traders() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	traders := make([]string, 0, len(m.balances))
+	for trader := range m.balances {
+		traders = append(traders, trader)
+	}
+	sort.Strings(traders)
+	return traders
+}
+
+// Holds returns every open hold sorted by order ID
+func (m *Manager) Holds() []Hold {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	holds := make([]Hold, 0, len(m.holds))
+	for _, hold := range m.holds {
+		holds = append(holds, *hold)
+	}
+	sort.Slice(holds, func(i, k int) bool { return holds[i].OrderID < holds[k].OrderID })
+	return holds
+}
+
+// Restore replaces every balance and hold, e.g. with those of a snapshot.
+// With a ledger, an opening balance entry brings each account's ledger total
+// in line with its restored balance.
+func (m *Manager) Restore(balances map[string]map[string]Balance, holds []Hold) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.balances = make(map[string]map[string]*Balance, len(balances))
+	for trader, assets := range balances {
+		for asset, balance := range assets {
+			*m.balance(trader, asset) = balance
+		}
+	}
+	m.holds = make(map[string]*Hold, len(holds))
+	for _, hold := range holds {
+		hold := hold
+		m.holds[hold.OrderID] = &hold
+	}
+	return m.postOpening()
+}
+
+// postOpening posts the difference between every account's balance and its
+// ledger total against ledger.ExternalAccount; it must be called with m.mu
+// held
+func (m *Manager) postOpening() error {
+	if m.ledger == nil {
+		return nil
+	}
+	books := m.totals()
+	postings := make([]ledger.Posting, 0)
+	for _, d := range m.ledger.Reconcile(books).Differences {
+		postings = append(postings,
+			ledger.Posting{Account: d.Account, Asset: d.Asset, Amount: d.Books - d.Ledger},
+			ledger.Posting{Account: ledger.ExternalAccount, Asset: d.Asset, Amount: d.Ledger - d.Books},
+		)
+	}
+	if len(postings) == 0 {
+		return nil
+	}
+	_, err := m.ledger.Post(ledger.EntryOpening, ledger.Reference{}, postings)
+	return err
+}
+
+// Reconcile returns the ledger's trial balance checked against every
+// account's balance, available and held
+func (m *Manager) Reconcile() (ledger.TrialBalance, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ledger == nil {
+		return ledger.TrialBalance{}, false
+	}
+	return m.ledger.Reconcile(m.totals()), true
+}
+
+// totals returns every account's available and held balance summed; it must
+// be called with m.mu held
+func (m *Manager) totals() map[string]map[string]float64 {
+	totals := make(map[string]map[string]float64, len(m.balances))
+	for trader, assets := range m.balances {
+		totals[trader] = make(map[string]float64, len(assets))
+		for asset, balance := range assets {
+			totals[trader][asset] = balance.Available + balance.Held
+		}
+	}
+	return totals
+}
+
+// post journals transfers as a single entry; it must be called with m.mu held
+func (m *Manager) post(entryType ledger.EntryType, ref ledger.Reference, transfers []Transfer) error {
+	if m.ledger == nil {
+		return nil
+	}
+	postings := make([]ledger.Posting, 0, 2*len(transfers))
+	for _, t := range transfers {
+		postings = append(postings,
+			ledger.Posting{Account: t.From, Asset: t.Asset, Amount: -t.Amount},
+			ledger.Posting{Account: t.To, Asset: t.Asset, Amount: t.Amount},
+		)
+	}
+	_, err := m.ledger.Post(entryType, ref, postings)
+	return err
+}
+
+// balance must be called with m.mu held
+func (m *Manager) balance(trader string, asset string) *Balance {
+	assets, ok := m.balances[trader]
+	if !ok {
+		assets = make(map[string]*Balance)
+		m.balances[trader] = assets
+	}
+	balance, ok := assets[asset]
+	if !ok {
+		balance = &Balance{}
+		assets[asset] = balance
+	}
+	return balance
+}
+
+// dust tolerates floating point rounding when a hold is consumed by fills
+const dust = 1e-9
@@ -1,7 +1,87 @@
 package config
 
+import (
+    "time"
+)
+
+// Venue is an additional liquidity provider the pool fallback can route to
+type Venue struct {
+    Name    string
+    URL     string
+    FeeRate float64 // venue charge on notional, compared when splitting orders
+}
+
 var (
-    LiquidityPoolURL = "http://localhost:8081" // default value
+    LiquidityPoolURL     = "http://localhost:8081" // default value
+    LiquidityPoolTimeout = 500 * time.Millisecond  // per call made while matching
+    LiquidityRouteBudget = 2 * time.Second         // all venue calls routing one order, while the book waits
+    QuoteAsset           = "USD"                   // asset order prices are quoted in
+
+    // Circuit breaker and retries around the liquidity pool
+    PoolBreakerThreshold   = 5                      // consecutive failures that open the breaker
+    PoolBreakerOpenTimeout = 10 * time.Second       // wait before probing an open breaker
+    PoolReadRetries        = 2                      // extra attempts for liquidity and price reads
+    PoolRetryBackoff       = 50 * time.Millisecond  // first retry delay, doubled each time
+    PoolRetryTrades        = false                  // only safe if the pool honours Idempotency-Key
+
+    // Venues besides LiquidityPoolURL the router splits pool fills across
+    LiquidityVenues = []Venue{}
+
+    // In-process constant-product pool, added as a venue when any reserves are set
+    AMMFeeRate  = 0.003
+    AMMReserves = map[string][2]float64{} // asset -> base and quote reserves
+
+    // Hedging of house exposure from pool fills; disabled without a venue URL
+    HedgeVenueURL         = ""
+    HedgeThresholds       = map[string]float64{} // asset -> net exposure that triggers a hedge
+    HedgeDefaultThreshold = 0.0                  // 0 never hedges unlisted assets
+    HedgeInterval         = time.Second
+
+    // Write-ahead journal of every command the engine accepts
+    JournalDir          = "data/journal"
+    JournalSync         = "always"               // always, interval or none
+    JournalSyncInterval = 100 * time.Millisecond // used by the interval policy
+    JournalSegmentSize  = int64(64 << 20)        // bytes before a new segment is started
+
+    // Snapshots of engine state, so recovery only replays the journal tail
+    SnapshotDir      = "data/snapshots"
+    SnapshotInterval = time.Minute
+    SnapshotsKept    = 3
+
+    // Stream of order, trade and book events, one JSON object per line
+    EventsFile = "data/events.jsonl"
+
+    // Order and trade history served by /api/orders and /api/trades
+    HistoryFile = "data/history.jsonl"
+
+    // Primary/standby replication. Both nodes must share the epoch file,
+    // which fences a primary once a standby has been promoted.
+    HTTPAddr        = ":8080"
+    ReplicationRole = "primary" // primary or standby
+    ReplicationAddr = ":9090"   // where a primary serves standbys
+    PrimaryAddr     = ""        // where a standby follows its primary
+    EpochFile       = "data/epoch"
+    PromoteAfter    = time.Duration(0) // silence before a standby promotes itself; 0 waits for an operator
+
+    // Default fee schedule for instruments without their own
+    MakerFeeRate = 0.0002
+    TakerFeeRate = 0.0005
+    PoolFeeRate  = 0.001 // charged to takers filled by the liquidity pool
+
+    // Settlement: "spot" delivers both assets of every fill, "margin" moves
+    // positions instead and only settles realized P&L and fees
+    TradingMode = "spot"
+    // How often margin valuations refresh their pool prices
+    PriceRefreshInterval = time.Second
+
+    // Cross margin collateral: accepted assets and the share of value discounted
+    CollateralHaircuts = map[string]float64{
+        "USD":  0,
+        "USDC": 0.01,
+        "BTC":  0.10,
+        "ETH":  0.15,
+    }
+    MaintenanceMarginRate = 0.005
 )
 
 func init() {
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"matching-engine/internal/events"
+	"math"
+)
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// BookChecksum returns the rolling checksum of an asset's book. After every
+// change to a price level, the CRC-32C carries on over the side and price of
+// the level and its resting orders in queue order, so engines that applied
+// the same changes agree on it and the first change they disagree on sets
+// them apart for good.
+func (e *MatchingEngine) BookChecksum(asset string) uint32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.checksums[asset]
+}
+
+// BookChecksums returns the checksum of every book that has changed
+func (e *MatchingEngine) BookChecksums() map[string]uint32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	checksums := make(map[string]uint32, len(e.checksums))
+	for asset, checksum := range e.checksums {
+		checksums[asset] = checksum
+	}
+	return checksums
+}
+
+// updateChecksum rolls an asset's checksum over a price level as it is now
+// and returns the level's resting amount; it must be called with e.mu held
+func (e *MatchingEngine) updateChecksum(asset string, isBuy bool, price float64) (float64, uint32) {
+	orders := e.orderBook.SellOrders
+	side := byte(1)
+	if isBuy {
+		orders = e.orderBook.BuyOrders
+		side = 0
+	}
+	buf := make([]byte, 0, 64)
+	buf = append(buf, side)
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(price))
+	checksum := crc32.Update(e.checksums[asset], checksumTable, buf)
+
+	size := 0.0
+	for _, order := range orders {
+		remaining := order.Amount - order.FilledAmount
+		if order.Asset != asset || order.Price != price || remaining <= 0 {
+			continue
+		}
+		size += remaining
+		buf = buf[:0]
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(remaining))
+		buf = append(buf, order.ID...)
+		checksum = crc32.Update(checksum, checksumTable, buf)
+	}
+	// An emptied level still moves the checksum
+	checksum = crc32.Update(checksum, checksumTable, []byte{0xff})
+	e.checksums[asset] = checksum
+	return size, checksum
+}
+
+// Divergence is the first book change where a replay disagrees with the
+// recorded event stream
+type Divergence struct {
+	JournalSeq uint64
+	EventSeq   uint64
+	Asset      string
+	Recorded   uint32
+	Replayed   uint32
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("journal record %d, event %d: %s book checksum %08x, recorded %08x",
+		d.JournalSeq, d.EventSeq, d.Asset, d.Replayed, d.Recorded)
+}
+
+// VerifyBooks recovers e from the newest snapshot in snapshotDir and the
+// journal in journalDir, as Recover does, and compares every book change the
+// replay makes with the events recorded at eventsPath. It returns the first
+// divergence, or nil when the replay agrees with every recorded event it
+// reaches. e should be configured like the engine that wrote the journal, and
+// have neither a journal nor a publisher.
+func (e *MatchingEngine) VerifyBooks(snapshotDir string, journalDir string, eventsPath string) (*Divergence, error) {
+	verifier := &bookVerifier{recorded: make(map[uint64]events.Event)}
+	err := events.ReadFile(eventsPath, 0, func(event events.Event) error {
+		if event.Type == events.BookDelta {
+			verifier.recorded[event.Seq] = event
+		}
+		verifier.last = event.Seq
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.SetPublisher(verifier)
+	defer e.SetPublisher(nil)
+	if _, err := e.Recover(snapshotDir, journalDir); err != nil {
+		return verifier.divergence, err
+	}
+	return verifier.divergence, nil
+}
+
+// bookVerifier is the publisher VerifyBooks replays into
+type bookVerifier struct {
+	recorded   map[uint64]events.Event
+	last       uint64
+	divergence *Divergence
+}
+
+func (v *bookVerifier) Publish(batch []events.Event) error {
+	for _, event := range batch {
+		if v.divergence != nil || event.Seq > v.last {
+			return nil
+		}
+		recorded, ok := v.recorded[event.Seq]
+		replayedDelta := event.Type == events.BookDelta
+		if !ok && !replayedDelta {
+			continue
+		}
+		d := Divergence{JournalSeq: event.JournalSeq, EventSeq: event.Seq, Asset: event.Asset}
+		if ok && recorded.Delta != nil {
+			d.Asset = recorded.Asset
+			d.Recorded = recorded.Delta.Checksum
+		}
+		if replayedDelta {
+			d.Replayed = event.Delta.Checksum
+		}
+		if !ok || !replayedDelta || recorded.Asset != event.Asset || d.Recorded != d.Replayed {
+			v.divergence = &d
+		}
+	}
+	return nil
+}
+
+// LastSeq is 0, so the replay hands over every event
+func (v *bookVerifier) LastSeq() uint64 {
+	return 0
+}
+
+func (v *bookVerifier) Close() error {
+	return nil
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"matching-engine/internal/account"
+	"matching-engine/internal/events"
+	"path/filepath"
+	"testing"
+)
+
+func TestBookChecksumFollowsTheBook(t *testing.T) {
+	engine, _, published := runEventSession(t)
+
+	var last uint32
+	seen := make(map[uint32]bool)
+	for _, event := range published {
+		if event.Type != events.BookDelta {
+			continue
+		}
+		if seen[event.Delta.Checksum] {
+			t.Errorf("Event %d: checksum %08x repeats an earlier one", event.Seq, event.Delta.Checksum)
+		}
+		seen[event.Delta.Checksum] = true
+		last = event.Delta.Checksum
+	}
+	if engine.BookChecksum("BTC") != last {
+		t.Errorf("Expected the engine's checksum to be the last delta's %08x, got %08x", last, engine.BookChecksum("BTC"))
+	}
+
+	restored := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	restored.Restore(engine.Snapshot())
+	if restored.BookChecksum("BTC") != last {
+		t.Errorf("Expected the checksum to survive a snapshot, got %08x", restored.BookChecksum("BTC"))
+	}
+}
+
+func TestVerifyBooksFindsFirstDivergence(t *testing.T) {
+	_, dir, published := runEventSession(t)
+	snapshots := t.TempDir()
+	record := func(evs []events.Event) string {
+		path := filepath.Join(t.TempDir(), "events.jsonl")
+		file, err := events.OpenFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := file.Publish(evs); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	verify := func(path string) *Divergence {
+		engine := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+		engine.SetAccountManager(account.NewManager(), "USD")
+		divergence, err := engine.VerifyBooks(snapshots, dir, path)
+		if err != nil {
+			t.Fatalf("VerifyBooks failed: %v", err)
+		}
+		return divergence
+	}
+
+	if divergence := verify(record(published)); divergence != nil {
+		t.Fatalf("Expected the replay to match, got %s", divergence)
+	}
+
+	// A recorded book that differs from the second delta on
+	tampered := make([]events.Event, len(published))
+	copy(tampered, published)
+	deltas := 0
+	var target events.Event
+	for i, event := range tampered {
+		if event.Type != events.BookDelta {
+			continue
+		}
+		if deltas++; deltas >= 2 {
+			delta := *event.Delta
+			delta.Checksum++
+			tampered[i].Delta = &delta
+			if deltas == 2 {
+				target = event
+			}
+		}
+	}
+	divergence := verify(record(tampered))
+	if divergence == nil {
+		t.Fatal("Expected a divergence")
+	}
+	if divergence.EventSeq != target.Seq || divergence.JournalSeq != target.JournalSeq || divergence.Asset != "BTC" {
+		t.Errorf("Expected the divergence at event %d (journal record %d), got %s", target.Seq, target.JournalSeq, divergence)
+	}
+	if divergence.Replayed != target.Delta.Checksum || divergence.Recorded != target.Delta.Checksum+1 {
+		t.Errorf("Unexpected checksums in %s", divergence)
+	}
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"fmt"
+	"matching-engine/internal/events"
+	"matching-engine/pkg/utils"
+)
+
+// SetPublisher makes the engine publish its events to p. Events are numbered
+// whether or not a publisher is set, so the numbers follow the journal.
+func (e *MatchingEngine) SetPublisher(p events.Publisher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.publisher = p
+}
+
+// EventSeq returns the sequence number of the last event
+func (e *MatchingEngine) EventSeq() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.eventSeq
+}
+
+// emit numbers an event, updates the order statuses from it and queues it
+// until the command ends; it must be called with e.mu held
+func (e *MatchingEngine) emit(event events.Event) {
+	e.eventSeq++
+	event.Seq = e.eventSeq
+	event.Time = e.now()
+	event.JournalSeq = e.commandSeq
+	e.trackStatus(event)
+	e.pendingEvents = append(e.pendingEvents, event)
+}
+
+// publishEvents hands the events of the command that just ran to the
+// publisher, behind any it refused before. Refused events stay queued and
+// are offered again at the end of the next command, so subscribers see a
+// delay rather than a gap. It must be called with e.mu held.
+func (e *MatchingEngine) publishEvents() {
+	retrying := len(e.unpublished) > 0
+	pending := append(e.unpublished, e.pendingEvents...)
+	e.pendingEvents = nil
+	e.unpublished = nil
+	if e.publisher == nil || len(pending) == 0 {
+		return
+	}
+	if e.replaying || retrying {
+		// A publisher that outlived the engine, or took part of a batch
+		// before failing, already has events up to its last one
+		last := e.publisher.LastSeq()
+		for len(pending) > 0 && pending[0].Seq <= last {
+			pending = pending[1:]
+		}
+		if len(pending) == 0 {
+			return
+		}
+	}
+	if err := e.publisher.Publish(pending); err != nil {
+		e.unpublished = pending
+		utils.LogError(fmt.Errorf("publishing events %d to %d, will retry: %w", pending[0].Seq, pending[len(pending)-1].Seq, err))
+	}
+}
+
+// orderEvent describes a resting or incoming order. Resting orders keep
+// only their remainder in Amount, so totals are taken from InitialAmount.
+func orderEvent(eventType events.Type, order Order) events.Event {
+	remaining := order.Amount - order.FilledAmount
+	orderType := "limit"
+	if order.Type == Market {
+		orderType = "market"
+	}
+	return events.Event{
+		Type:      eventType,
+		Asset:     order.Asset,
+		OrderID:   order.ID,
+		Trader:    order.Trader,
+		IsBuy:     order.IsBuyOrder,
+		OrderType: orderType,
+		Price:     order.Price,
+		Amount:    order.InitialAmount,
+		Filled:    order.InitialAmount - remaining,
+		Remaining: remaining,
+	}
+}
+
+func (e *MatchingEngine) emitRejection(order Order, reason string) {
+	event := orderEvent(events.OrderRejected, order)
+	event.Reason = reason
+	e.emit(event)
+}
+
+func (e *MatchingEngine) emitTrade(fill Fill) {
+	e.emit(events.Event{
+		Type:  events.TradeExecuted,
+		Asset: fill.Asset,
+		IsBuy: fill.TakerIsBuy,
+		Price: fill.Price,
+		Trade: &events.Trade{
+			TradeID:      fill.TradeID,
+			TakerOrderID: fill.TakerOrderID,
+			MakerOrderID: fill.MakerOrderID,
+			Taker:        fill.Taker,
+			Maker:        fill.Maker,
+			Price:        fill.Price,
+			Amount:       fill.Amount,
+			TakerIsBuy:   fill.TakerIsBuy,
+			Venue:        fill.Venue,
+			TakerFee:     fill.TakerFee,
+			MakerFee:     fill.MakerFee,
+		},
+	})
+}
+
+// emitFillStatus reports how much of an order has filled so far
+func (e *MatchingEngine) emitFillStatus(order Order) {
+	if order.FilledAmount >= order.Amount {
+		e.emit(orderEvent(events.OrderFilled, order))
+	} else {
+		e.emit(orderEvent(events.OrderPartiallyFilled, order))
+	}
+}
+
+// emitTakerOutcome reports what became of an incoming order once matching
+// and the pool fallback are done. An unfilled remainder that did not rest is
+// cancelled for reason.
+func (e *MatchingEngine) emitTakerOutcome(order Order, result MatchResult, rested bool, reason string) {
+	taker := order
+	taker.Amount = order.InitialAmount
+	taker.FilledAmount = result.FilledAmount
+	if result.FilledAmount > 0 {
+		e.emitFillStatus(taker)
+	}
+	switch {
+	case rested:
+		e.emit(orderEvent(events.OrderRested, taker))
+		e.emitLevelChange(order.Asset, order.IsBuyOrder, order.Price, result.RemainingAmount)
+	case result.RemainingAmount > 0:
+		event := orderEvent(events.OrderCancelled, taker)
+		event.Reason = reason
+		e.emit(event)
+	}
+}
+
+// emitLevelChange reports a change to the resting amount at a price level,
+// with the book checksum after it; it must be called after the book has
+// changed
+func (e *MatchingEngine) emitLevelChange(asset string, isBuy bool, price float64, change float64) {
+	size, checksum := e.updateChecksum(asset, isBuy, price)
+	e.emit(events.Event{
+		Type:  events.BookDelta,
+		Asset: asset,
+		IsBuy: isBuy,
+		Price: price,
+		Delta: &events.Delta{Change: change, Size: size, Checksum: checksum},
+	})
+}
+
+// emitRemoval reports an order leaving the book without filling
+func (e *MatchingEngine) emitRemoval(eventType events.Type, order Order) {
+	e.emit(orderEvent(eventType, order))
+	e.emitLevelChange(order.Asset, order.IsBuyOrder, order.Price, -(order.Amount - order.FilledAmount))
+}
+
+// emitAmendment reports an amended order and the levels it moved between
+func (e *MatchingEngine) emitAmendment(before Order, after Order) {
+	e.emit(orderEvent(events.OrderAmended, after))
+	oldRemaining := before.Amount - before.FilledAmount
+	newRemaining := after.Amount - after.FilledAmount
+	if before.Price == after.Price {
+		e.emitLevelChange(after.Asset, after.IsBuyOrder, after.Price, newRemaining-oldRemaining)
+		return
+	}
+	e.emitLevelChange(before.Asset, before.IsBuyOrder, before.Price, -oldRemaining)
+	e.emitLevelChange(after.Asset, after.IsBuyOrder, after.Price, newRemaining)
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"matching-engine/internal/account"
+	"matching-engine/internal/events"
+	"matching-engine/internal/journal"
+	"testing"
+)
+
+// runEventSession takes orders through every event type and returns the
+// engine, its journal directory and the events it published
+func runEventSession(t *testing.T) (*MatchingEngine, string, []events.Event) {
+	dir := t.TempDir()
+	wal, err := journal.Open(dir, journal.Options{Sync: journal.SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	engine := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	engine.SetAccountManager(account.NewManager(), "USD")
+	engine.SetJournal(wal)
+	published := events.NewMemory(0)
+	engine.SetPublisher(published)
+
+	engine.Deposit("bob", "BTC", 10)
+	engine.Deposit("alice", "USD", 1000)
+	sell := engine.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 101, Amount: 3, Type: Limit})
+	engine.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Price: 101, Amount: 1, Type: Limit, IsBuyOrder: true})
+	if result := engine.ProcessOrder(Order{Trader: "carol", Asset: "BTC", Price: 101, Amount: 1, Type: Limit, IsBuyOrder: true}); result.Success {
+		t.Fatalf("Expected carol's order to be rejected, got %+v", result)
+	}
+	if _, err := engine.AmendOrder(sell.OrderID, 102, 2.5); err != nil {
+		t.Fatalf("Amend failed: %v", err)
+	}
+	engine.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Price: 99, Amount: 1, Type: Limit, IsBuyOrder: true, Expiration: 50})
+	engine.ExpireOrders(60)
+	if _, err := engine.CancelOrder(sell.OrderID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	engine.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Amount: 1, Type: Market, IsBuyOrder: true})
+
+	return engine, dir, published.Events(0, 0)
+}
+
+func TestEventsDescribeOrderLifecycle(t *testing.T) {
+	engine, _, published := runEventSession(t)
+
+	expected := []events.Type{
+		events.OrderAccepted, events.OrderRested, events.BookDelta,
+		events.OrderAccepted, events.TradeExecuted, events.OrderPartiallyFilled, events.BookDelta, events.OrderFilled,
+		events.OrderRejected,
+		events.OrderAmended, events.BookDelta, events.BookDelta,
+		events.OrderAccepted, events.OrderRested, events.BookDelta,
+		events.OrderExpired, events.BookDelta,
+		events.OrderCancelled, events.BookDelta,
+		events.OrderAccepted, events.OrderCancelled,
+	}
+	if len(published) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(expected), len(published), published)
+	}
+	for i, event := range published {
+		if event.Type != expected[i] {
+			t.Errorf("Event %d: expected %s, got %s", i+1, expected[i], event.Type)
+		}
+		if event.Seq != uint64(i+1) {
+			t.Errorf("Event %d: expected seq %d, got %d", i+1, i+1, event.Seq)
+		}
+		if event.JournalSeq == 0 {
+			t.Errorf("Event %d (%s) is not tied to a journal record", i+1, event.Type)
+		}
+	}
+	if engine.EventSeq() != uint64(len(expected)) {
+		t.Errorf("Expected the engine to be at event %d, got %d", len(expected), engine.EventSeq())
+	}
+
+	if trade := published[4].Trade; trade == nil || trade.Amount != 1 || trade.Price != 101 || trade.Maker != "bob" {
+		t.Errorf("Unexpected trade %+v", published[4].Trade)
+	}
+	if maker := published[5]; maker.Trader != "bob" || maker.Filled != 1 || maker.Remaining != 2 {
+		t.Errorf("Unexpected maker fill %+v", maker)
+	}
+	if delta := published[6].Delta; delta == nil || delta.Change != -1 || delta.Size != 2 {
+		t.Errorf("Unexpected fill delta %+v", published[6].Delta)
+	}
+	if published[8].Trader != "carol" || published[8].Reason == "" {
+		t.Errorf("Unexpected rejection %+v", published[8])
+	}
+	if amended := published[9]; amended.Price != 102 || amended.Filled != 1 || amended.Remaining != 1.5 {
+		t.Errorf("Unexpected amendment %+v", amended)
+	}
+	if from, to := published[10], published[11]; from.Price != 101 || from.Delta.Change != -2 || from.Delta.Size != 0 ||
+		to.Price != 102 || to.Delta.Change != 1.5 || to.Delta.Size != 1.5 {
+		t.Errorf("Unexpected amendment deltas %+v %+v", from.Delta, to.Delta)
+	}
+}
+
+func TestReplayReproducesEvents(t *testing.T) {
+	_, dir, published := runEventSession(t)
+
+	replayed := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	replayed.SetAccountManager(account.NewManager(), "USD")
+	republished := events.NewMemory(0)
+	replayed.SetPublisher(republished)
+	if _, err := replayed.Replay(dir, 1); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	want, _ := json.Marshal(published)
+	got, _ := json.Marshal(republished.Events(0, 0))
+	if string(got) != string(want) {
+		t.Fatalf("Replayed events differ:\nrecorded %s\nreplayed %s", want, got)
+	}
+
+	// A publisher that kept part of the stream only gets the rest
+	resumed := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	resumed.SetAccountManager(account.NewManager(), "USD")
+	kept := events.NewMemory(0)
+	kept.Publish(published[:10])
+	resumed.SetPublisher(kept)
+	if _, err := resumed.Replay(dir, 1); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	got, _ = json.Marshal(kept.Events(0, 0))
+	if string(got) != string(want) {
+		t.Fatalf("Resumed events differ:\nrecorded %s\nresumed %s", want, got)
+	}
+}
+
+// flakyPublisher refuses batches while down
+type flakyPublisher struct {
+	*events.Memory
+	down bool
+}
+
+func (p *flakyPublisher) Publish(batch []events.Event) error {
+	if p.down {
+		return errors.New("publisher down")
+	}
+	return p.Memory.Publish(batch)
+}
+
+func TestRefusedEventsAreRetried(t *testing.T) {
+	engine := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	engine.SetAccountManager(account.NewManager(), "USD")
+	publisher := &flakyPublisher{Memory: events.NewMemory(0), down: true}
+	engine.SetPublisher(publisher)
+
+	engine.Deposit("bob", "BTC", 10)
+	engine.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 101, Amount: 1, Type: Limit})
+	if got := publisher.LastSeq(); got != 0 {
+		t.Fatalf("Expected nothing published while down, got up to %d", got)
+	}
+
+	// The events survive a snapshot taken while they wait
+	restored := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	restored.SetAccountManager(account.NewManager(), "USD")
+	restored.Restore(engine.Snapshot())
+	restored.SetPublisher(publisher)
+
+	publisher.down = false
+	restored.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 102, Amount: 1, Type: Limit})
+	published := publisher.Events(0, 0)
+	if len(published) != 6 {
+		t.Fatalf("Expected the refused events ahead of the new ones, got %+v", published)
+	}
+	for i, event := range published {
+		if event.Seq != uint64(i+1) {
+			t.Errorf("Event %d has seq %d", i+1, event.Seq)
+		}
+	}
+}
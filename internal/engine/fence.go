@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNotPrimary refuses commands on a standby
+	ErrNotPrimary = errors.New("engine is not the primary")
+	// ErrFenced refuses commands once another primary has taken over
+	ErrFenced = errors.New("engine is fenced by a newer primary")
+)
+
+// Fence decides whether the engine may accept commands. Check returns nil
+// while the engine is primary, ErrNotPrimary or ErrFenced otherwise.
+type Fence interface {
+	Check() error
+}
+
+// SetFence makes every command check f before it is accepted
+func (e *MatchingEngine) SetFence(f Fence) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fence = f
+}
+
+// checkFence must be called with e.mu held
+func (e *MatchingEngine) checkFence() error {
+	if e.fence == nil {
+		return nil
+	}
+	return e.fence.Check()
+}
+
+// refused reports whether err only means this engine may not take commands
+func refused(err error) bool {
+	return errors.Is(err, ErrNotPrimary) || errors.Is(err, ErrFenced)
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"fmt"
+	"matching-engine/internal/hedge"
+	"matching-engine/internal/journal"
+	"matching-engine/pkg/utils"
+)
+
+// SetHedger makes every pool fill add to the house exposure hedged by h, and
+// journals the hedge trades h places. Set it before replaying so the house
+// position is rebuilt, but only run it on the primary.
+func (e *MatchingEngine) SetHedger(h *hedge.Hedger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.hedger = h
+	if h != nil {
+		h.SetRecorder(e.recordHedge)
+	}
+}
+
+// Hedger returns the hedger of pool exposure, if any
+func (e *MatchingEngine) Hedger() *hedge.Hedger {
+	return e.hedger
+}
+
+// recordExposure passes the house side of a pool fill to the hedger
+func (e *MatchingEngine) recordExposure(fill Fill) {
+	if e.hedger == nil {
+		return
+	}
+	e.hedger.RecordPoolFill(fill.Asset, fill.TakerIsBuy, fill.Amount, fill.Price)
+}
+
+// recordHedge journals a hedge trade and books it. The trade has already
+// happened at the venue, so it is booked even if it cannot be journaled.
+func (e *MatchingEngine) recordHedge(trade hedge.Trade) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.begin(trade.Time)()
+
+	if err := e.record(journal.RecordHedge, trade); err != nil {
+		utils.LogError(fmt.Errorf("hedge trade %s is booked but not journaled: %w", trade.ID, err))
+	}
+	if e.hedger != nil {
+		e.hedger.ApplyTrade(trade)
+	}
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDGenerator names orders submitted without an ID and every trade. It is
+// only called with the engine lock held.
+type IDGenerator interface {
+	NextOrderID() string
+	NextTradeID() string
+	// Observe is told every order ID met while replaying the journal, and
+	// the IDs returned by Last when restoring a snapshot, so new IDs do not
+	// repeat earlier ones
+	Observe(id string)
+	// Last returns the newest order and trade IDs handed out or observed
+	Last() (orderID string, tradeID string)
+}
+
+// SequentialIDs numbers orders ord-1, ord-2, ... and trades trd-1, trd-2, ...
+type SequentialIDs struct {
+	orders uint64
+	trades uint64
+}
+
+func (s *SequentialIDs) NextOrderID() string {
+	s.orders++
+	return fmt.Sprintf("ord-%d", s.orders)
+}
+
+func (s *SequentialIDs) NextTradeID() string {
+	s.trades++
+	return fmt.Sprintf("trd-%d", s.trades)
+}
+
+func (s *SequentialIDs) Observe(id string) {
+	if n, ok := sequenceOf(id, "ord-"); ok && n > s.orders {
+		s.orders = n
+	}
+	if n, ok := sequenceOf(id, "trd-"); ok && n > s.trades {
+		s.trades = n
+	}
+}
+
+func (s *SequentialIDs) Last() (string, string) {
+	return fmt.Sprintf("ord-%d", s.orders), fmt.Sprintf("trd-%d", s.trades)
+}
+
+func sequenceOf(id string, prefix string) (uint64, bool) {
+	if !strings.HasPrefix(id, prefix) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(id, prefix), 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"matching-engine/internal/engine/liquiditypool"
+	"matching-engine/internal/journal"
+)
+
+// Journal payloads. Commands are journaled once accepted, with whatever
+// outside input decided their outcome.
+type newOrderCommand struct {
+	Order
+	// ReferencePrice is the pool price the order was checked against
+	ReferencePrice float64 `json:"reference_price"`
+}
+
+// rejectionCommand is an order refused by the pre-trade checks. It changes
+// no state but keeps the event stream reproducible.
+type rejectionCommand struct {
+	Order
+	Reason string `json:"reason"`
+}
+
+type cancelCommand struct {
+	OrderID string `json:"order_id"`
+}
+
+type amendCommand struct {
+	OrderID string  `json:"order_id"`
+	Price   float64 `json:"price"`
+	Amount  float64 `json:"amount"`
+}
+
+type expiryCommand struct {
+	Now int64 `json:"now"`
+}
+
+// transferCommand is a deposit or withdrawal
+type transferCommand struct {
+	Trader string  `json:"trader"`
+	Asset  string  `json:"asset"`
+	Amount float64 `json:"amount"`
+}
+
+// poolResult records what the liquidity venues did for an order, so a replay
+// does not have to ask them again
+type poolResult struct {
+	OrderID string                    `json:"order_id"`
+	Fills   []liquiditypool.VenueFill `json:"fills"`
+	Error   string                    `json:"error,omitempty"`
+}
+
+// SetJournal makes the engine append every command to j before applying it
+func (e *MatchingEngine) SetJournal(j *journal.Journal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if j != nil {
+		j.SetClock(e.now)
+	}
+	e.journal = j
+}
+
+// Journal returns the write-ahead journal, if any
+func (e *MatchingEngine) Journal() *journal.Journal {
+	return e.journal
+}
+
+// record appends a command to the journal; it must be called with e.mu held.
+// Nothing is journaled while replaying. With a fence set, the fence is
+// checked before appending. Once appended a record may already be on its way
+// to standbys and subscribers, so it is never refused afterwards; a primary
+// fenced in between leaves a record its successor lacks, and replication
+// refuses to follow it with that journal.
+func (e *MatchingEngine) record(recordType journal.RecordType, command interface{}) error {
+	if e.replaying {
+		return nil
+	}
+	if err := e.checkFence(); err != nil {
+		return err
+	}
+	if e.journal == nil {
+		return nil
+	}
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", recordType, err)
+	}
+	seq, err := e.journal.Append(recordType, payload)
+	if err != nil {
+		return fmt.Errorf("journaling %s: %w", recordType, err)
+	}
+	if e.commandSeq == 0 {
+		e.commandSeq = seq
+	}
+	return nil
+}
+
+// RecordSubject is the order and trader a journal record is about
+type RecordSubject struct {
+	OrderID string
+	Trader  string
+}
+
+// SubjectOf decodes the subject of a journal record. Records that name no
+// order or trader, such as expiries, have an empty subject; cancels, amends
+// and pool results only name the order.
+func SubjectOf(record journal.Record) (RecordSubject, error) {
+	switch record.Type {
+	case journal.RecordNewOrder, journal.RecordRejection:
+		var order Order
+		if err := json.Unmarshal(record.Payload, &order); err != nil {
+			return RecordSubject{}, fmt.Errorf("decoding %s %d: %w", record.Type, record.Seq, err)
+		}
+		return RecordSubject{OrderID: order.ID, Trader: order.Trader}, nil
+	case journal.RecordCancel, journal.RecordAmend, journal.RecordPoolResult:
+		var command cancelCommand
+		if err := json.Unmarshal(record.Payload, &command); err != nil {
+			return RecordSubject{}, fmt.Errorf("decoding %s %d: %w", record.Type, record.Seq, err)
+		}
+		return RecordSubject{OrderID: command.OrderID}, nil
+	case journal.RecordDeposit, journal.RecordWithdrawal, journal.RecordLiquidation:
+		var command transferCommand
+		if err := json.Unmarshal(record.Payload, &command); err != nil {
+			return RecordSubject{}, fmt.Errorf("decoding %s %d: %w", record.Type, record.Seq, err)
+		}
+		return RecordSubject{Trader: command.Trader}, nil
+	}
+	return RecordSubject{}, nil
+}
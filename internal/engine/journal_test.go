@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"matching-engine/internal/journal"
+	"testing"
+)
+
+func TestCommandsAreJournaled(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := journal.Open(dir, journal.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockLP := &MockLiquidityPool{shouldFail: true}
+	engine := NewMatchingEngine(mockLP)
+	engine.SetJournal(wal)
+
+	engine.ProcessOrder(Order{ID: "sell-1", Asset: "BTC", Price: 101, Amount: 2, Type: Limit})
+	engine.ProcessOrder(Order{ID: "buy-1", Asset: "BTC", Price: 99, Amount: 1, Type: Limit, IsBuyOrder: true, Expiration: 50})
+	if _, err := engine.AmendOrder("sell-1", 99, 3); !errors.Is(err, ErrWouldCross) {
+		t.Errorf("Expected crossing amend to be refused, got %v", err)
+	}
+	if _, err := engine.AmendOrder("sell-1", 102, 3); err != nil {
+		t.Fatalf("Amend failed: %v", err)
+	}
+	if _, err := engine.CancelOrder("sell-1"); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if expired := engine.ExpireOrders(60); len(expired) != 1 {
+		t.Fatalf("Expected buy-1 to expire, got %+v", expired)
+	}
+	wal.Close()
+
+	types := make([]journal.RecordType, 0)
+	var amend amendCommand
+	if err := journal.Read(dir, 1, func(record journal.Record) error {
+		types = append(types, record.Type)
+		if record.Type == journal.RecordAmend {
+			return json.Unmarshal(record.Payload, &amend)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each order's pool fallback is journaled even when it fails; rejected
+	// commands are not journaled at all
+	expected := []journal.RecordType{
+		journal.RecordNewOrder, journal.RecordPoolResult,
+		journal.RecordNewOrder, journal.RecordPoolResult,
+		journal.RecordAmend, journal.RecordCancel, journal.RecordExpiry,
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected records %v, got %v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("Record %d: expected %s, got %s", i+1, expected[i], types[i])
+		}
+	}
+	if amend.OrderID != "sell-1" || amend.Price != 102 || amend.Amount != 3 {
+		t.Errorf("Unexpected amend payload: %+v", amend)
+	}
+
+	// Once the journal is gone, commands are refused rather than applied
+	if _, err := engine.CancelOrder("buy-1"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("Expected buy-1 to be gone, got %v", err)
+	}
+	if result := engine.ProcessOrder(Order{ID: "sell-2", Asset: "BTC", Price: 101, Amount: 1, Type: Limit}); result.Success {
+		t.Errorf("Expected order to be rejected without a journal, got %+v", result)
+	}
+}
+
+func TestSubjectOfRecords(t *testing.T) {
+	_, dir, published := runEventSession(t)
+	sellID := published[0].OrderID
+
+	subjects := make(map[journal.RecordType][]RecordSubject)
+	err := journal.Read(dir, 1, func(record journal.Record) error {
+		subject, err := SubjectOf(record)
+		subjects[record.Type] = append(subjects[record.Type], subject)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects[journal.RecordDeposit][0]; got.Trader != "bob" || got.OrderID != "" {
+		t.Errorf("Unexpected deposit subject %+v", got)
+	}
+	if got := subjects[journal.RecordNewOrder][0]; got.Trader != "bob" || got.OrderID != sellID {
+		t.Errorf("Unexpected new order subject %+v", got)
+	}
+	if got := subjects[journal.RecordRejection][0]; got.Trader != "carol" {
+		t.Errorf("Unexpected rejection subject %+v", got)
+	}
+	for _, recordType := range []journal.RecordType{journal.RecordAmend, journal.RecordCancel} {
+		if got := subjects[recordType][0]; got.OrderID != sellID || got.Trader != "" {
+			t.Errorf("Unexpected %s subject %+v", recordType, got)
+		}
+	}
+	if got := subjects[journal.RecordExpiry][0]; got != (RecordSubject{}) {
+		t.Errorf("Expected an expiry to have no subject, got %+v", got)
+	}
+}
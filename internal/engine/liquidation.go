@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"matching-engine/internal/account"
+	"matching-engine/internal/journal"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/margin"
+	"matching-engine/internal/risk"
+	"matching-engine/pkg/utils"
+	"math"
+)
+
+// liquidationCommand is a filled liquidation with the mark price its ADL
+// queue was ranked at
+type liquidationCommand struct {
+	risk.Liquidation
+	MarkPrice float64 `json:"mark_price"`
+}
+
+// InsuranceFund returns the fund backing liquidation shortfalls
+func (e *MatchingEngine) InsuranceFund() *risk.InsuranceFund {
+	return e.insurance
+}
+
+// SetPositionProvider sets the source of open positions for the ADL queue
+func (e *MatchingEngine) SetPositionProvider(positions risk.PositionProvider) {
+	e.adl.SetPositionProvider(positions)
+}
+
+// ADLQueue returns the auto-deleveraging ranking for one side of an asset,
+// valued at the last pool price
+func (e *MatchingEngine) ADLQueue(asset string, isLong bool) []risk.ADLCandidate {
+	markPrice := e.Price(asset)
+	return e.adl.Queue(asset, markPrice, isLong)
+}
+
+// Liquidate settles a filled liquidation of a margin position. A surplus
+// over the bankruptcy price goes to the insurance fund; a shortfall is paid
+// by the fund, and what it cannot cover is closed against the ADL queue at
+// the bankruptcy price, moving the deleveraged counterparties' positions.
+// The liquidated position is closed by Size, at the fill price for the part
+// not deleveraged.
+func (e *MatchingEngine) Liquidate(liq risk.Liquidation) (risk.LiquidationOutcome, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.begin(e.clock())()
+
+	if liq.SettlementAsset == "" {
+		liq.SettlementAsset = e.quoteAsset
+	}
+	if err := e.checkLiquidation(liq); err != nil {
+		return risk.LiquidationOutcome{}, err
+	}
+	command := liquidationCommand{Liquidation: liq, MarkPrice: e.Price(liq.Asset)}
+	if err := e.record(journal.RecordLiquidation, command); err != nil {
+		return risk.LiquidationOutcome{}, err
+	}
+	return e.liquidate(command), nil
+}
+
+// checkLiquidation refuses a liquidation that does not close part of an open
+// margin position
+func (e *MatchingEngine) checkLiquidation(liq risk.Liquidation) error {
+	if e.margin == nil {
+		return errors.New("liquidation requires margin")
+	}
+	if liq.ID == "" || liq.Trader == "" || liq.Asset == "" {
+		return errors.New("liquidation needs an id, trader and asset")
+	}
+	if liq.SettlementAsset != e.quoteAsset {
+		return fmt.Errorf("liquidations settle in %s, not %s", e.quoteAsset, liq.SettlementAsset)
+	}
+	if liq.Size <= 0 || liq.FillPrice <= 0 || liq.BankruptcyPrice <= 0 {
+		return errors.New("liquidation size and prices must be positive")
+	}
+	position, ok := e.margin.Positions().Position(liq.Trader, liq.Asset, liq.Isolated)
+	if !ok || (position.Size > 0) != liq.IsLong || math.Abs(position.Size) < liq.Size {
+		return fmt.Errorf("%s has no %s position of %.8f to liquidate", liq.Trader, liq.Asset, liq.Size)
+	}
+	return nil
+}
+
+// liquidate applies a checked liquidation; it must be called with e.mu held.
+// A surplus is only there to collect once the position is closed, while a
+// shortfall must be covered before the trader can pay for closing it.
+func (e *MatchingEngine) liquidate(command liquidationCommand) risk.LiquidationOutcome {
+	liq := command.Liquidation
+	if liq.Surplus() >= 0 {
+		e.closeLiquidated(liq, risk.LiquidationOutcome{})
+		return e.adl.Settle(liq, command.MarkPrice)
+	}
+	outcome := e.adl.Settle(liq, command.MarkPrice)
+	e.closeLiquidated(liq, outcome)
+	return outcome
+}
+
+// closeLiquidated closes the liquidated position and the counterparty
+// positions deleveraged against it. The trader pays the loss from its margin
+// and what the fund covered; for a cross position that is its available
+// balance. A loss beyond that is left with ClearingAccount.
+func (e *MatchingEngine) closeLiquidated(liq risk.Liquidation, outcome risk.LiquidationOutcome) {
+	direction := -1.0
+	if !liq.IsLong {
+		direction = 1.0
+	}
+	deleveraged := 0.0
+	for _, d := range outcome.Deleveraged {
+		deleveraged += d.Size
+	}
+
+	before := e.margin.Positions().Current(liq.Trader, liq.Asset, liq.Isolated)
+	position, realized, closed := before, 0.0, 0.0
+	for _, leg := range []struct{ size, price float64 }{
+		{liq.Size - deleveraged, liq.FillPrice},
+		{deleveraged, liq.BankruptcyPrice},
+	} {
+		if leg.size <= 0 {
+			continue
+		}
+		var change margin.Change
+		position, change = position.Fill(direction*leg.size, leg.price, 0)
+		realized += change.Realized
+		closed += change.Closed
+	}
+
+	holdKey := margin.IsolatedHoldKey(liq.Trader, liq.Asset)
+	isolatedMargin, _ := e.accounts.HoldFor(holdKey)
+	release := 0.0
+	if liq.Isolated && before.Size != 0 {
+		release = isolatedMargin.Amount * closed / math.Abs(before.Size)
+	}
+
+	transfers := make([]account.Transfer, 0, 2+len(outcome.Deleveraged))
+	switch {
+	case realized > 0:
+		transfers = append(transfers, account.Transfer{From: ClearingAccount, To: liq.Trader, Asset: e.quoteAsset, Amount: realized})
+	case realized < 0:
+		loss := -realized
+		paid := 0.0
+		if liq.Isolated {
+			paid = math.Min(loss, isolatedMargin.Amount)
+			if paid > 0 {
+				transfers = append(transfers, account.Transfer{From: liq.Trader, To: ClearingAccount, Asset: e.quoteAsset, Amount: paid, HoldOrderID: holdKey})
+			}
+			release -= paid
+			// The fund paid its cover into the available balance
+			if covered := math.Min(loss-paid, outcome.Covered); covered > 0 {
+				transfers = append(transfers, account.Transfer{From: liq.Trader, To: ClearingAccount, Asset: e.quoteAsset, Amount: covered})
+				paid += covered
+			}
+		} else if available := e.accounts.Balance(liq.Trader, e.quoteAsset).Available; available > 0 {
+			paid = math.Min(loss, available)
+			transfers = append(transfers, account.Transfer{From: liq.Trader, To: ClearingAccount, Asset: e.quoteAsset, Amount: paid})
+		}
+		if unpaid := loss - paid; unpaid > 0 {
+			utils.LogError(fmt.Errorf("liquidation %s: %.8f %s of the loss of %s is not paid and left with %s",
+				liq.ID, unpaid, e.quoteAsset, liq.Trader, ClearingAccount))
+		}
+	}
+
+	counterparties := make([]positionSettlement, 0, len(outcome.Deleveraged))
+	for _, d := range outcome.Deleveraged {
+		order := Order{Trader: d.Trader, Asset: d.Asset, MarginType: Cross}
+		if d.Isolated {
+			order.MarginType = Isolated
+		}
+		quantity := d.Size
+		if d.IsLong {
+			quantity = -quantity
+		}
+		side := e.planPosition(order, e.margin.Positions().Current(d.Trader, d.Asset, d.Isolated), quantity, d.Price, 0)
+		counterparties = append(counterparties, side)
+		transfers = append(transfers, side.transfers...)
+	}
+
+	if len(transfers) > 0 {
+		if err := e.accounts.Settle(ledger.Reference{TradeID: liq.ID}, transfers...); err != nil {
+			utils.LogError(fmt.Errorf("settling liquidation %s, positions left open: %w", liq.ID, err))
+			return
+		}
+	}
+	e.margin.Positions().Set(position)
+	if release > 0 {
+		e.accounts.ReduceHold(holdKey, release)
+	}
+	for _, side := range counterparties {
+		e.applyPosition(side)
+	}
+}
+
+// absorbShortfall has the insurance fund cover a loss a trader's isolated
+// margin could not pay. The fund pays the trader, who passes it on to
+// ClearingAccount; what the fund cannot cover is left with ClearingAccount.
+func (e *MatchingEngine) absorbShortfall(trader string, shortfall float64, reference string) {
+	covered, uncovered := e.insurance.Absorb(e.quoteAsset, shortfall, trader, reference)
+	if covered > 0 {
+		transfer := account.Transfer{From: trader, To: ClearingAccount, Asset: e.quoteAsset, Amount: covered, Type: ledger.EntryLiquidation}
+		if err := e.accounts.Settle(ledger.Reference{TradeID: reference}, transfer); err != nil {
+			utils.LogError(fmt.Errorf("passing on insurance cover of %s for %s: %w", trader, reference, err))
+		}
+	}
+	if uncovered > 0 {
+		utils.LogError(fmt.Errorf("%s: %.8f %s of the shortfall of %s is not covered and left with %s",
+			reference, uncovered, e.quoteAsset, trader, ClearingAccount))
+	}
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"matching-engine/internal/account"
+	"matching-engine/internal/journal"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/margin"
+	"matching-engine/internal/risk"
+	"testing"
+	"time"
+)
+
+// newLiquidationEngine has alice long 10 BTC at 100 on 100 of isolated
+// margin against the cross shorts of bob (6) and carol (4)
+func newLiquidationEngine(t *testing.T, wal *journal.Journal) *MatchingEngine {
+	engine := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	accounts := account.NewManager()
+	accounts.SetLedger(ledger.New())
+	engine.SetAccountManager(accounts, "USD")
+	manager := margin.NewManager(accounts, engine, margin.Config{
+		QuoteAsset: "USD",
+		Haircuts:   map[string]float64{"USD": 0},
+	})
+	if err := engine.SetMarginManager(manager); err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	engine.SetClock(func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	})
+	engine.SetJournal(wal)
+	return engine
+}
+
+func TestLiquidationDeleveragesWhatTheFundCannotCover(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := journal.Open(dir, journal.Options{Sync: journal.SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := newLiquidationEngine(t, wal)
+	for _, trader := range []string{"alice", "bob", "carol"} {
+		engine.Deposit(trader, "USD", 1000)
+	}
+	if err := engine.Deposit(InsuranceAccount, "USD", 20); err != nil {
+		t.Fatal(err)
+	}
+	engine.ProcessOrder(Order{ID: "bob-sell", Trader: "bob", Asset: "BTC", Price: 100, Amount: 6, Type: Limit, Leverage: 10, MarginType: Cross})
+	engine.ProcessOrder(Order{ID: "carol-sell", Trader: "carol", Asset: "BTC", Price: 100, Amount: 4, Type: Limit, Leverage: 5, MarginType: Cross})
+	engine.ProcessOrder(Order{ID: "alice-buy", Trader: "alice", Asset: "BTC", Price: 100, Amount: 10, Type: Limit, IsBuyOrder: true, Leverage: 10, MarginType: Isolated})
+	engine.priceMu.Lock()
+	engine.lastPrices["BTC"] = 85
+	engine.priceMu.Unlock()
+
+	// Filled at 85 against a bankruptcy price of 90: the fund covers 20 of
+	// the 50 shortfall and the other 30, 6 BTC at 90, goes to bob, who ranks
+	// first in the ADL queue
+	liq := risk.Liquidation{ID: "liq-1", Trader: "alice", Asset: "BTC", Isolated: true, IsLong: true, Size: 10, BankruptcyPrice: 90, FillPrice: 85}
+	outcome, err := engine.Liquidate(liq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Covered != 20 || outcome.Uncovered != 30 || len(outcome.Deleveraged) != 1 || outcome.Deleveraged[0].Trader != "bob" || outcome.Deleveraged[0].Size != 6 {
+		t.Fatalf("Unexpected outcome %+v", outcome)
+	}
+
+	positions := engine.Margin().Positions()
+	if _, ok := positions.Position("alice", "BTC", true); ok {
+		t.Errorf("Expected alice's position closed")
+	}
+	if _, ok := positions.Position("bob", "BTC", false); ok {
+		t.Errorf("Expected bob's position deleveraged")
+	}
+	if p, ok := positions.Position("carol", "BTC", false); !ok || p.Size != -4 {
+		t.Errorf("Expected carol still short 4, got %+v", p)
+	}
+	accounts := engine.Accounts()
+	if balance := accounts.Balance("alice", "USD"); balance.Available != 900 || balance.Held != 0 {
+		t.Errorf("Expected alice to lose her margin and nothing more, got %+v", balance)
+	}
+	if balance := accounts.Balance("bob", "USD"); balance.Available != 1060 {
+		t.Errorf("Expected bob to realize 60 at the bankruptcy price, got %+v", balance)
+	}
+	if balance := engine.InsuranceFund().Balance("USD"); balance != 0 {
+		t.Errorf("Expected the fund used up, got %f", balance)
+	}
+	if _, ok := accounts.Reconcile(); !ok {
+		t.Errorf("Expected the ledger to reconcile with the balances")
+	}
+
+	if _, err := engine.Liquidate(liq); err == nil {
+		t.Errorf("Expected a closed position to be refused")
+	}
+	wal.Close()
+
+	replayed := newLiquidationEngine(t, nil)
+	if _, err := replayed.Replay(dir, 1); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if replayed.StateHash() != engine.StateHash() {
+		t.Fatalf("Replayed state differs:\nrecorded %+v\nreplayed %+v", engine.State(), replayed.State())
+	}
+}
+
+func TestIsolatedShortfallIsCoveredByTheFund(t *testing.T) {
+	engine := newLiquidationEngine(t, nil)
+	for _, trader := range []string{"alice", "bob"} {
+		engine.Deposit(trader, "USD", 1000)
+	}
+	engine.Deposit(InsuranceAccount, "USD", 30)
+	engine.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 100, Amount: 10, Type: Limit, Leverage: 10, MarginType: Cross})
+	engine.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Price: 100, Amount: 10, Type: Limit, IsBuyOrder: true, Leverage: 10, MarginType: Isolated})
+
+	// Closing at 85 loses 150 on 100 of margin; the fund pays the other 50
+	// as far as it can
+	engine.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 85, Amount: 10, Type: Limit, IsBuyOrder: true, Leverage: 10, MarginType: Cross})
+	engine.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Price: 85, Amount: 10, Type: Limit, Leverage: 10, MarginType: Isolated})
+
+	if balance := engine.Accounts().Balance("alice", "USD"); balance.Available != 900 || balance.Held != 0 {
+		t.Errorf("Expected alice to lose only her margin, got %+v", balance)
+	}
+	if balance := engine.InsuranceFund().Balance("USD"); balance != 0 {
+		t.Errorf("Expected the fund to pay 30, left with %f", balance)
+	}
+	if balance := engine.Accounts().Balance(ClearingAccount, "USD"); balance.Available != -20 {
+		t.Errorf("Expected clearing to bear the 20 the fund could not cover, got %+v", balance)
+	}
+	events := engine.InsuranceFund().Events(0)
+	if last := events[len(events)-1]; last.Type != risk.AuditFundExhausted || last.Amount != 20 || last.Trader != "alice" {
+		t.Errorf("Expected the uncovered shortfall audited, got %+v", last)
+	}
+}
@@ -0,0 +1,341 @@
+// Package amm is an in-process constant-product market maker implementing
+// liquiditypool.LiquidityPoolClient. Each asset trades against the quote
+// asset from its own pair of reserves, keeping base*quote constant apart
+// from the fee, which stays in the pool.
+package amm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"matching-engine/internal/engine/liquiditypool"
+	"sync"
+	"time"
+)
+
+// DefaultQuoteTTL is how long quotes stay executable unless changed
+const DefaultQuoteTTL = 5 * time.Second
+
+// MaxExecutions is how many executions are kept for repeated orders and
+// TradeStatus; the oldest are forgotten first
+const MaxExecutions = 10000
+
+// MaxTradeShare caps a single trade at this share of the base reserve so an
+// unbounded market order cannot drain the pool
+const MaxTradeShare = 0.5
+
+// Reserves are the balances of one asset pair
+type Reserves struct {
+	Base  float64 `json:"base"`
+	Quote float64 `json:"quote"`
+}
+
+// Price returns the marginal price, the ratio of the reserves
+func (r Reserves) Price() float64 {
+	return r.Quote / r.Base
+}
+
+type pool struct {
+	reserves Reserves
+	// version changes with every trade, invalidating older quotes
+	version uint64
+}
+
+type quote struct {
+	liquiditypool.Quote
+	version uint64
+}
+
+// AMM holds one constant-product pool per asset
+type AMM struct {
+	mu         sync.Mutex
+	feeRate    float64
+	quoteTTL   time.Duration
+	pools      map[string]*pool
+	quotes     map[string]quote
+	executions map[string]liquiditypool.Execution
+	// executed holds the order IDs of executions, oldest first
+	executed []string
+	quoteSeq uint64
+	now      func() time.Time
+}
+
+// New creates an AMM charging feeRate on the input of every trade
+func New(feeRate float64) *AMM {
+	return &AMM{
+		feeRate:    feeRate,
+		quoteTTL:   DefaultQuoteTTL,
+		pools:      make(map[string]*pool),
+		quotes:     make(map[string]quote),
+		executions: make(map[string]liquiditypool.Execution),
+		now:        time.Now,
+	}
+}
+
+// AddPool sets the reserves of an asset's pool
+func (a *AMM) AddPool(asset string, base float64, quoteReserve float64) error {
+	if base <= 0 || quoteReserve <= 0 {
+		return fmt.Errorf("reserves of %s must be positive", asset)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, ok := a.pools[asset]
+	if !ok {
+		p = &pool{}
+		a.pools[asset] = p
+	}
+	p.reserves = Reserves{Base: base, Quote: quoteReserve}
+	p.version++
+	return nil
+}
+
+// SetClock replaces the clock quote expiry is checked against
+func (a *AMM) SetClock(now func() time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.now = now
+}
+
+// SetQuoteTTL changes how long new quotes can be executed
+func (a *AMM) SetQuoteTTL(ttl time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.quoteTTL = ttl
+}
+
+// Reserves returns the reserves of an asset's pool
+func (a *AMM) Reserves(asset string) (Reserves, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, ok := a.pools[asset]
+	if !ok {
+		return Reserves{}, false
+	}
+	return p.reserves, true
+}
+
+func (a *AMM) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.pool("price", asset)
+	if err != nil {
+		return 0, err
+	}
+	return p.reserves.Price(), nil
+}
+
+// GetAvailableLiquidity returns the largest amount whose average price stays
+// within limitPrice
+func (a *AMM) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.pool("liquidity", asset)
+	if err != nil {
+		return 0, err
+	}
+	return a.available(p.reserves, isBuyOrder, limitPrice), nil
+}
+
+func (a *AMM) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (liquiditypool.Quote, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.pool("quote", asset)
+	if err != nil {
+		return liquiditypool.Quote{}, err
+	}
+	a.expireQuotes()
+	amount = min(amount, a.available(p.reserves, isBuy, limitPrice))
+	if amount <= 0 {
+		return liquiditypool.Quote{}, &liquiditypool.Error{Op: "quote", Kind: liquiditypool.ErrRejected, Err: errors.New("no liquidity within limit")}
+	}
+
+	a.quoteSeq++
+	q := quote{
+		Quote: liquiditypool.Quote{
+			ID:        fmt.Sprintf("amm-q-%d", a.quoteSeq),
+			Asset:     asset,
+			IsBuy:     isBuy,
+			Amount:    amount,
+			Price:     a.averagePrice(p.reserves, isBuy, amount),
+			ExpiresAt: a.now().Add(a.quoteTTL),
+		},
+		version: p.version,
+	}
+	a.quotes[q.ID] = q
+	return q.Quote, nil
+}
+
+// ExecuteQuote trades a quote once per order. Quotes that expired or were
+// overtaken by another trade are rejected without moving reserves.
+func (a *AMM) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (liquiditypool.Execution, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if execution, ok := a.executions[orderId]; ok {
+		return execution, nil
+	}
+
+	execution := liquiditypool.Execution{OrderID: orderId, QuoteID: quoteID, Status: liquiditypool.ExecutionRejected}
+	q, ok := a.quotes[quoteID]
+	delete(a.quotes, quoteID)
+	if ok && a.now().Before(q.ExpiresAt) {
+		if p := a.pools[q.Asset]; p != nil && p.version == q.version {
+			p.trade(q.IsBuy, q.Amount, q.Price)
+			execution.Status = liquiditypool.ExecutionFilled
+			execution.FilledAmount = q.Amount
+			execution.Price = q.Price
+		}
+	}
+	a.remember(execution)
+	return execution, nil
+}
+
+// ApplyExecution moves the reserves of asset as a filled execution did,
+// without a quote. Replay uses it to bring a copy of the AMM in line with the
+// one that traded.
+func (a *AMM) ApplyExecution(asset string, isBuy bool, execution liquiditypool.Execution) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.pool("apply", asset)
+	if err != nil {
+		return err
+	}
+	if _, ok := a.executions[execution.OrderID]; ok {
+		return nil
+	}
+	if execution.Status == liquiditypool.ExecutionFilled && execution.FilledAmount > 0 {
+		p.trade(isBuy, execution.FilledAmount, execution.Price)
+	}
+	a.remember(execution)
+	return nil
+}
+
+// Pools returns the reserves of every pool
+func (a *AMM) Pools() map[string]Reserves {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pools := make(map[string]Reserves, len(a.pools))
+	for asset, p := range a.pools {
+		pools[asset] = p.reserves
+	}
+	return pools
+}
+
+// RestorePools replaces the reserves of the pools in pools, as returned by
+// Pools. Outstanding quotes on them can no longer be executed.
+func (a *AMM) RestorePools(pools map[string]Reserves) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for asset, reserves := range pools {
+		p, ok := a.pools[asset]
+		if !ok {
+			p = &pool{}
+			a.pools[asset] = p
+		}
+		p.reserves = reserves
+		p.version++
+	}
+}
+
+func (a *AMM) TradeStatus(ctx context.Context, orderId string) (liquiditypool.Execution, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if execution, ok := a.executions[orderId]; ok {
+		return execution, nil
+	}
+	return liquiditypool.Execution{OrderID: orderId, Status: liquiditypool.ExecutionNotFound}, nil
+}
+
+// expireQuotes drops quotes past their expiry; it must be called with a.mu
+// held
+func (a *AMM) expireQuotes() {
+	now := a.now()
+	for id, q := range a.quotes {
+		if !now.Before(q.ExpiresAt) {
+			delete(a.quotes, id)
+		}
+	}
+}
+
+// remember keeps an execution for its order, forgetting the oldest beyond
+// MaxExecutions; it must be called with a.mu held
+func (a *AMM) remember(execution liquiditypool.Execution) {
+	if execution.OrderID == "" {
+		return
+	}
+	a.executions[execution.OrderID] = execution
+	a.executed = append(a.executed, execution.OrderID)
+	if excess := len(a.executed) - MaxExecutions; excess > 0 {
+		for _, orderID := range a.executed[:excess] {
+			delete(a.executions, orderID)
+		}
+		a.executed = a.executed[excess:]
+	}
+}
+
+// pool must be called with a.mu held
+func (a *AMM) pool(op string, asset string) (*pool, error) {
+	p, ok := a.pools[asset]
+	if !ok {
+		return nil, &liquiditypool.Error{Op: op, Kind: liquiditypool.ErrRejected, Err: fmt.Errorf("no pool for %s", asset)}
+	}
+	return p, nil
+}
+
+// trade moves the reserves by amount of base at price
+func (p *pool) trade(isBuy bool, amount float64, price float64) {
+	cost := amount * price
+	if isBuy {
+		p.reserves.Base -= amount
+		p.reserves.Quote += cost
+	} else {
+		p.reserves.Base += amount
+		p.reserves.Quote -= cost
+	}
+	p.version++
+}
+
+// averagePrice is the quote paid or received per unit of base for amount.
+// The fee is taken from the input: quote when buying, base when selling.
+func (a *AMM) averagePrice(r Reserves, isBuy bool, amount float64) float64 {
+	keep := 1 - a.feeRate
+	if isBuy {
+		return r.Quote / ((r.Base - amount) * keep)
+	}
+	return r.Quote * keep / (r.Base + amount*keep)
+}
+
+// available solves averagePrice(amount) = limitPrice for amount, capped at
+// MaxTradeShare of the base reserve
+func (a *AMM) available(r Reserves, isBuy bool, limitPrice float64) float64 {
+	amount := r.Base * MaxTradeShare
+	if limitPrice > 0 {
+		keep := 1 - a.feeRate
+		if isBuy {
+			amount = min(amount, r.Base-r.Quote/(limitPrice*keep))
+		} else {
+			amount = min(amount, (r.Quote*keep/limitPrice-r.Base)/keep)
+		}
+		// Rounding can leave the average a hair beyond the limit
+		for step := 1e-15; amount > 0 && !within(a.averagePrice(r, isBuy, amount), isBuy, limitPrice); step *= 2 {
+			amount -= amount * step
+		}
+	}
+	return max(amount, 0)
+}
+
+func within(price float64, isBuy bool, limitPrice float64) bool {
+	if isBuy {
+		return price <= limitPrice
+	}
+	return price >= limitPrice
+}
@@ -0,0 +1,152 @@
+package amm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"matching-engine/internal/engine/liquiditypool"
+	"math"
+	"testing"
+	"time"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestBuyMovesReservesAlongTheCurve(t *testing.T) {
+	pool := New(0)
+	pool.AddPool("BTC", 100, 10000)
+	ctx := context.Background()
+
+	if price, _ := pool.GetCurrentPrice(ctx, "BTC"); price != 100 {
+		t.Fatalf("Expected reserve ratio 100, got %f", price)
+	}
+
+	quote, err := pool.RequestQuote(ctx, "BTC", true, 10, 0)
+	if err != nil {
+		t.Fatalf("RequestQuote: %v", err)
+	}
+	// 10000*10/90 quote in for 10 base out
+	if !near(quote.Price, 10000.0/90) {
+		t.Errorf("Expected average price %f, got %f", 10000.0/90, quote.Price)
+	}
+
+	execution, err := pool.ExecuteQuote(ctx, quote.ID, "ord-1")
+	if err != nil || execution.Status != liquiditypool.ExecutionFilled || execution.FilledAmount != 10 {
+		t.Fatalf("Expected 10 filled, got %+v (%v)", execution, err)
+	}
+	reserves, _ := pool.Reserves("BTC")
+	if !near(reserves.Base*reserves.Quote, 100*10000) {
+		t.Errorf("Expected k to be preserved without fees, got %f", reserves.Base*reserves.Quote)
+	}
+
+	// Repeating the order returns the first execution
+	again, _ := pool.ExecuteQuote(ctx, "amm-q-99", "ord-1")
+	if again != execution {
+		t.Errorf("Expected the recorded execution, got %+v", again)
+	}
+	if status, _ := pool.TradeStatus(ctx, "ord-1"); status != execution {
+		t.Errorf("Expected status to match the execution, got %+v", status)
+	}
+}
+
+func TestFeeStaysInPool(t *testing.T) {
+	pool := New(0.003)
+	pool.AddPool("ETH", 1000, 2000000)
+	ctx := context.Background()
+
+	quote, _ := pool.RequestQuote(ctx, "ETH", false, 5, 0)
+	if quote.Price >= 2000 {
+		t.Errorf("Expected a sell to get less than the reserve ratio, got %f", quote.Price)
+	}
+	pool.ExecuteQuote(ctx, quote.ID, "ord-1")
+
+	reserves, _ := pool.Reserves("ETH")
+	if reserves.Base*reserves.Quote <= 1000*2000000 {
+		t.Errorf("Expected k to grow by the fee, got %f", reserves.Base*reserves.Quote)
+	}
+}
+
+func TestAvailableLiquidityRespectsLimit(t *testing.T) {
+	pool := New(0.003)
+	pool.AddPool("BTC", 100, 10000)
+	ctx := context.Background()
+
+	for _, isBuy := range []bool{true, false} {
+		limit := 101.0
+		if !isBuy {
+			limit = 99
+		}
+		available, err := pool.GetAvailableLiquidity(ctx, "BTC", isBuy, limit)
+		if err != nil || available <= 0 {
+			t.Fatalf("Expected liquidity within %f, got %f (%v)", limit, available, err)
+		}
+		quote, _ := pool.RequestQuote(ctx, "BTC", isBuy, 1000, limit)
+		if !near(quote.Amount, available) || !near(quote.Price, limit) {
+			t.Errorf("Expected quote of %f at %f, got %+v", available, limit, quote)
+		}
+	}
+
+	// Limits better than the pool price leave nothing
+	if available, _ := pool.GetAvailableLiquidity(ctx, "BTC", true, 99); available != 0 {
+		t.Errorf("Expected no liquidity below the pool price, got %f", available)
+	}
+	if available, _ := pool.GetAvailableLiquidity(ctx, "BTC", true, 0); available != 100*MaxTradeShare {
+		t.Errorf("Expected unbounded buys capped at %f, got %f", 100*MaxTradeShare, available)
+	}
+	if _, err := pool.GetAvailableLiquidity(ctx, "XYZ", true, 0); !errors.Is(err, liquiditypool.ErrRejected) {
+		t.Errorf("Expected unknown assets to be rejected, got %v", err)
+	}
+}
+
+func TestStaleQuotesAreRejected(t *testing.T) {
+	pool := New(0)
+	pool.AddPool("BTC", 100, 10000)
+	now := time.Unix(1000, 0)
+	pool.SetClock(func() time.Time { return now })
+	ctx := context.Background()
+
+	expired, _ := pool.RequestQuote(ctx, "BTC", true, 1, 0)
+	now = now.Add(DefaultQuoteTTL)
+	if execution, _ := pool.ExecuteQuote(ctx, expired.ID, "ord-1"); execution.Status != liquiditypool.ExecutionRejected {
+		t.Errorf("Expected expired quote to be rejected, got %+v", execution)
+	}
+
+	first, _ := pool.RequestQuote(ctx, "BTC", true, 1, 0)
+	second, _ := pool.RequestQuote(ctx, "BTC", true, 1, 0)
+	pool.ExecuteQuote(ctx, first.ID, "ord-2")
+	if execution, _ := pool.ExecuteQuote(ctx, second.ID, "ord-3"); execution.Status != liquiditypool.ExecutionRejected {
+		t.Errorf("Expected quote overtaken by a trade to be rejected, got %+v", execution)
+	}
+}
+
+func TestQuotesAndExecutionsAreBounded(t *testing.T) {
+	pool := New(0)
+	pool.AddPool("BTC", 100, 10000)
+	now := time.Unix(1000, 0)
+	pool.SetClock(func() time.Time { return now })
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		pool.RequestQuote(ctx, "BTC", true, 1, 0)
+	}
+	now = now.Add(DefaultQuoteTTL)
+	live, _ := pool.RequestQuote(ctx, "BTC", true, 1, 0)
+	if _, ok := pool.quotes[live.ID]; len(pool.quotes) != 1 || !ok {
+		t.Errorf("Expected only the live quote to be kept, got %d quotes", len(pool.quotes))
+	}
+
+	for i := 0; i <= MaxExecutions; i++ {
+		pool.ExecuteQuote(ctx, "amm-q-0", fmt.Sprintf("ord-%d", i))
+	}
+	if len(pool.executions) != MaxExecutions {
+		t.Errorf("Expected %d executions to be kept, got %d", MaxExecutions, len(pool.executions))
+	}
+	if status, _ := pool.TradeStatus(ctx, "ord-0"); status.Status != liquiditypool.ExecutionNotFound {
+		t.Errorf("Expected the oldest execution to be forgotten, got %+v", status)
+	}
+	if status, _ := pool.TradeStatus(ctx, "ord-1"); status.Status != liquiditypool.ExecutionRejected {
+		t.Errorf("Expected a later execution to be kept, got %+v", status)
+	}
+}
@@ -0,0 +1,252 @@
+package liquiditypool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the pool while the breaker is
+// open. It matches ErrUnavailable.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker open", ErrUnavailable)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerConfig controls when the breaker opens and how reads are retried
+type BreakerConfig struct {
+	// FailureThreshold consecutive failures open the breaker
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before letting a probe through
+	OpenTimeout time.Duration
+	// MaxRetries is the number of extra attempts for liquidity and price reads
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled after each one
+	RetryBackoff time.Duration
+	// RetryTrades allows retrying ExecuteQuote. Only enable it against pools
+	// that deduplicate executions on the order ID idempotency key.
+	RetryTrades bool
+}
+
+// BreakerStats is a snapshot of the breaker for health reporting
+type BreakerStats struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenedAt            time.Time    `json:"opened_at,omitempty"`
+	LastError           string       `json:"last_error,omitempty"`
+}
+
+// Breaker wraps a LiquidityPoolClient with a circuit breaker and retries.
+// Timeouts, unavailability and bad responses count as failures; rejections
+// mean the pool is healthy and are returned as is.
+type Breaker struct {
+	client LiquidityPoolClient
+	config BreakerConfig
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError error
+	now       func() time.Time
+}
+
+func NewBreaker(client LiquidityPoolClient, config BreakerConfig) *Breaker {
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = 1
+	}
+	return &Breaker{
+		client: client,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+func (b *Breaker) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
+	var available float64
+	err := b.retry(ctx, b.config.MaxRetries, func() error {
+		var err error
+		available, err = b.client.GetAvailableLiquidity(ctx, asset, isBuyOrder, limitPrice)
+		return err
+	})
+	return available, err
+}
+
+func (b *Breaker) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (Quote, error) {
+	var quote Quote
+	err := b.retry(ctx, b.config.MaxRetries, func() error {
+		var err error
+		quote, err = b.client.RequestQuote(ctx, asset, isBuy, amount, limitPrice)
+		return err
+	})
+	return quote, err
+}
+
+func (b *Breaker) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (Execution, error) {
+	// A lost execution response may still have traded, so repeating it is
+	// only safe when the pool recognises the retry by its order ID
+	retries := 0
+	if b.config.RetryTrades && orderId != "" {
+		retries = b.config.MaxRetries
+	}
+
+	var execution Execution
+	err := b.retry(ctx, retries, func() error {
+		var err error
+		execution, err = b.client.ExecuteQuote(ctx, quoteID, orderId)
+		return err
+	})
+	return execution, err
+}
+
+func (b *Breaker) TradeStatus(ctx context.Context, orderId string) (Execution, error) {
+	var execution Execution
+	err := b.retry(ctx, b.config.MaxRetries, func() error {
+		var err error
+		execution, err = b.client.TradeStatus(ctx, orderId)
+		return err
+	})
+	return execution, err
+}
+
+func (b *Breaker) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	var price float64
+	err := b.retry(ctx, b.config.MaxRetries, func() error {
+		var err error
+		price, err = b.client.GetCurrentPrice(ctx, asset)
+		return err
+	})
+	return price, err
+}
+
+// State returns the current breaker state
+func (b *Breaker) State() BreakerState {
+	return b.Stats().State
+}
+
+// Stats returns the breaker state for health endpoints
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := BreakerStats{
+		State:               b.currentState(),
+		ConsecutiveFailures: b.failures,
+		OpenedAt:            b.openedAt,
+	}
+	if b.lastError != nil {
+		stats.LastError = b.lastError.Error()
+	}
+	return stats
+}
+
+// retry runs call through the breaker, retrying transient failures with
+// exponential backoff until retries are used up or ctx is done
+func (b *Breaker) retry(ctx context.Context, retries int, call func() error) error {
+	backoff := b.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := b.do(call)
+		if err == nil || !retryable(err) || errors.Is(err, ErrCircuitOpen) || attempt >= retries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// do makes a single call if the breaker allows it and records the outcome
+func (b *Breaker) do(call func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := call()
+	b.record(err)
+	return err
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		// Only one probe at a time while half-open
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		b.state = BreakerHalfOpen
+	}
+	return true
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.probing
+	b.probing = false
+
+	if err == nil || !countsAsFailure(err) {
+		b.failures = 0
+		b.state = BreakerClosed
+		return
+	}
+
+	b.failures++
+	b.lastError = err
+	if wasProbe || b.failures >= b.config.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// currentState must be called with b.mu held. An open breaker reports
+// half-open once OpenTimeout has passed.
+func (b *Breaker) currentState() BreakerState {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func countsAsFailure(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrBadResponse)
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable)
+}
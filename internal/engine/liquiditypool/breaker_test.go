@@ -0,0 +1,130 @@
+package liquiditypool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyPool fails every call with err until err is cleared
+type flakyPool struct {
+	err    error
+	prices int
+	trades int
+}
+
+func (p *flakyPool) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
+	return 10, p.err
+}
+
+func (p *flakyPool) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (Quote, error) {
+	return Quote{ID: "q-1", Asset: asset, IsBuy: isBuy, Amount: amount, Price: 100}, p.err
+}
+
+func (p *flakyPool) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (Execution, error) {
+	p.trades++
+	if p.err != nil {
+		return Execution{}, p.err
+	}
+	return Execution{OrderID: orderId, QuoteID: quoteID, Status: ExecutionFilled, FilledAmount: 1, Price: 100}, nil
+}
+
+func (p *flakyPool) TradeStatus(ctx context.Context, orderId string) (Execution, error) {
+	return Execution{OrderID: orderId, Status: ExecutionNotFound}, p.err
+}
+
+func (p *flakyPool) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	p.prices++
+	if p.err != nil {
+		return 0, p.err
+	}
+	return 100, nil
+}
+
+func TestBreakerOpensAndProbes(t *testing.T) {
+	pool := &flakyPool{err: &Error{Op: "price", Kind: ErrUnavailable}}
+	breaker := NewBreaker(pool, BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute})
+	now := time.Unix(1000, 0)
+	breaker.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := breaker.GetCurrentPrice(ctx, "BTC"); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("call %d: expected unavailable, got %v", i, err)
+		}
+	}
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("expected open breaker, got %s", breaker.State())
+	}
+
+	// Open: fail fast without reaching the pool
+	if _, err := breaker.GetCurrentPrice(ctx, "BTC"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected circuit open, got %v", err)
+	}
+	if pool.prices != 2 {
+		t.Fatalf("open breaker called the pool: %d calls", pool.prices)
+	}
+
+	// A failed probe reopens the breaker
+	now = now.Add(time.Minute)
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatalf("expected half-open breaker, got %s", breaker.State())
+	}
+	breaker.GetCurrentPrice(ctx, "BTC")
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("expected failed probe to reopen, got %s", breaker.State())
+	}
+
+	// A successful probe closes it
+	now = now.Add(time.Minute)
+	pool.err = nil
+	if price, err := breaker.GetCurrentPrice(ctx, "BTC"); err != nil || price != 100 {
+		t.Fatalf("probe: got %f, %v", price, err)
+	}
+	if stats := breaker.Stats(); stats.State != BreakerClosed || stats.ConsecutiveFailures != 0 {
+		t.Fatalf("expected closed breaker, got %+v", stats)
+	}
+}
+
+func TestBreakerIgnoresRejections(t *testing.T) {
+	pool := &flakyPool{err: &Error{Op: "price", Kind: ErrRejected}}
+	breaker := NewBreaker(pool, BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, MaxRetries: 3})
+
+	breaker.GetCurrentPrice(context.Background(), "BTC")
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("rejection opened the breaker")
+	}
+	if pool.prices != 1 {
+		t.Fatalf("rejection was retried: %d calls", pool.prices)
+	}
+}
+
+func TestBreakerRetriesReadsButNotTrades(t *testing.T) {
+	pool := &flakyPool{err: &Error{Op: "execute", Kind: ErrTimeout}}
+	config := BreakerConfig{FailureThreshold: 100, OpenTimeout: time.Minute, MaxRetries: 2, RetryBackoff: time.Millisecond}
+	ctx := context.Background()
+
+	breaker := NewBreaker(pool, config)
+	breaker.GetCurrentPrice(ctx, "BTC")
+	if pool.prices != 3 {
+		t.Fatalf("expected 3 price attempts, got %d", pool.prices)
+	}
+	breaker.ExecuteQuote(ctx, "q-1", "ord-1")
+	if pool.trades != 1 {
+		t.Fatalf("trade retried without idempotency: %d attempts", pool.trades)
+	}
+
+	config.RetryTrades = true
+	breaker = NewBreaker(pool, config)
+	pool.trades = 0
+	breaker.ExecuteQuote(ctx, "q-1", "ord-1")
+	if pool.trades != 3 {
+		t.Fatalf("expected 3 idempotent trade attempts, got %d", pool.trades)
+	}
+	pool.trades = 0
+	breaker.ExecuteQuote(ctx, "q-1", "")
+	if pool.trades != 1 {
+		t.Fatalf("trade without order ID was retried: %d attempts", pool.trades)
+	}
+}
@@ -2,86 +2,189 @@ package liquiditypool
 
 import (
     "bytes"
+    "context"
     "encoding/json"
+    "errors"
     "fmt"
+    "io"
     "net/http"
+    "net/url"
+    "strconv"
+    "time"
 )
 
+// DefaultTimeout caps every HTTP call when the caller's context has no deadline
+const DefaultTimeout = 2 * time.Second
+
 type Client struct {
     baseURL string
     client  *http.Client
 }
 
 func NewClient(baseURL string) LiquidityPoolClient {
+    return NewClientWithTimeout(baseURL, DefaultTimeout)
+}
+
+func NewClientWithTimeout(baseURL string, timeout time.Duration) LiquidityPoolClient {
     return &Client{
         baseURL: baseURL,
-        client:  &http.Client{},
+        client:  &http.Client{Timeout: timeout},
     }
 }
 
-func (c *Client) GetAvailableLiquidity(isBuyOrder bool) (float64, bool) {
-    resp, err := c.client.Get(fmt.Sprintf("%s/liquidity?isBuyOrder=%v", c.baseURL, isBuyOrder))
-    if err != nil {
-        return 0, false
+func (c *Client) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
+    query := url.Values{}
+    query.Set("asset", asset)
+    query.Set("isBuyOrder", strconv.FormatBool(isBuyOrder))
+    if limitPrice > 0 {
+        query.Set("limitPrice", strconv.FormatFloat(limitPrice, 'f', -1, 64))
     }
-    defer resp.Body.Close()
 
     var result struct {
         Amount float64 `json:"amount"`
     }
-    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-        return 0, false
+    endpoint := fmt.Sprintf("%s/liquidity?%s", c.baseURL, query.Encode())
+    if err := c.do(ctx, "liquidity", http.MethodGet, endpoint, nil, nil, &result); err != nil {
+        return 0, err
+    }
+    return result.Amount, nil
+}
+
+func (c *Client) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (Quote, error) {
+    payload := struct {
+        Asset      string  `json:"asset"`
+        IsBuy      bool    `json:"is_buy"`
+        Amount     float64 `json:"amount"`
+        LimitPrice float64 `json:"limit_price,omitempty"`
+    }{
+        Asset:      asset,
+        IsBuy:      isBuy,
+        Amount:     amount,
+        LimitPrice: limitPrice,
+    }
+
+    var quote Quote
+    if err := c.do(ctx, "quote", http.MethodPost, c.baseURL+"/quote", nil, payload, &quote); err != nil {
+        return Quote{}, err
     }
-    return result.Amount, true
+    if quote.ID == "" || quote.Price <= 0 || quote.Amount < 0 || quote.Amount > amount {
+        return Quote{}, &Error{Op: "quote", Kind: ErrBadResponse, Err: fmt.Errorf("invalid quote %+v for %f %s", quote, amount, asset)}
+    }
+    return quote, nil
 }
 
-func (c *Client) TradeWithPool(orderId string, amount float64, isBuy bool) (float64, error) {
+func (c *Client) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (Execution, error) {
     payload := struct {
-        OrderID string  `json:"order_id"`
-        Amount  float64 `json:"amount"`
-        IsBuy   bool    `json:"is_buy"`
+        QuoteID string `json:"quote_id"`
+        OrderID string `json:"order_id"`
     }{
+        QuoteID: quoteID,
         OrderID: orderId,
-        Amount:  amount,
-        IsBuy:   isBuy,
     }
 
-    jsonData, err := json.Marshal(payload)
-    if err != nil {
-        return 0, err
+    // The order ID doubles as idempotency key so the pool can recognise a
+    // retried execution instead of trading twice
+    header := http.Header{"Idempotency-Key": []string{orderId}}
+    var execution Execution
+    if err := c.do(ctx, "execute", http.MethodPost, c.baseURL+"/execute", header, payload, &execution); err != nil {
+        return Execution{}, err
+    }
+    if err := validExecution(execution, orderId); err != nil {
+        return Execution{}, &Error{Op: "execute", Kind: ErrBadResponse, Err: err}
+    }
+    // An execution is never final without a trade or a rejection; the
+    // caller reconciles it through TradeStatus
+    if execution.Status == ExecutionNotFound {
+        return Execution{}, &Error{Op: "execute", Kind: ErrBadResponse, Err: fmt.Errorf("no trade for order %q", orderId)}
     }
+    return execution, nil
+}
 
-    resp, err := c.client.Post(
-        fmt.Sprintf("%s/trade", c.baseURL),
-        "application/json",
-        bytes.NewBuffer(jsonData),
-    )
+func (c *Client) TradeStatus(ctx context.Context, orderId string) (Execution, error) {
+    var execution Execution
+    endpoint := fmt.Sprintf("%s/trades/%s", c.baseURL, url.PathEscape(orderId))
+    err := c.do(ctx, "status", http.MethodGet, endpoint, nil, nil, &execution)
+    var poolErr *Error
+    if errors.As(err, &poolErr) && poolErr.StatusCode == http.StatusNotFound {
+        return Execution{OrderID: orderId, Status: ExecutionNotFound}, nil
+    }
     if err != nil {
-        return 0, err
+        return Execution{}, err
     }
-    defer resp.Body.Close()
+    if err := validExecution(execution, orderId); err != nil {
+        return Execution{}, &Error{Op: "status", Kind: ErrBadResponse, Err: err}
+    }
+    return execution, nil
+}
 
+func (c *Client) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
     var result struct {
-        FilledAmount float64 `json:"filled_amount"`
+        Price float64 `json:"price"`
     }
-    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+    endpoint := fmt.Sprintf("%s/price/%s", c.baseURL, url.PathEscape(asset))
+    if err := c.do(ctx, "price", http.MethodGet, endpoint, nil, nil, &result); err != nil {
         return 0, err
     }
-    return result.FilledAmount, nil
+    if result.Price <= 0 {
+        return 0, &Error{Op: "price", Kind: ErrBadResponse, Err: fmt.Errorf("non-positive price %f for %s", result.Price, asset)}
+    }
+    return result.Price, nil
 }
 
-func (c *Client) GetCurrentPrice(asset string) float64 {
-    resp, err := c.client.Get(fmt.Sprintf("%s/price/%s", c.baseURL, asset))
+// do sends a request and decodes a JSON response into out, mapping every
+// failure to a typed Error
+func (c *Client) do(ctx context.Context, op string, method string, endpoint string, header http.Header, payload interface{}, out interface{}) error {
+    var body io.Reader
+    if payload != nil {
+        jsonData, err := json.Marshal(payload)
+        if err != nil {
+            return &Error{Op: op, Kind: ErrRejected, Err: err}
+        }
+        body = bytes.NewBuffer(jsonData)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+    if err != nil {
+        return &Error{Op: op, Kind: ErrRejected, Err: err}
+    }
+    for key, values := range header {
+        req.Header[key] = values
+    }
+    if payload != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+
+    resp, err := c.client.Do(req)
     if err != nil {
-        return 0
+        return transportError(op, err)
     }
     defer resp.Body.Close()
 
-    var result struct {
-        Price float64 `json:"price"`
+    if err := statusError(op, resp); err != nil {
+        return err
     }
-    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-        return 0
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        if ctx.Err() != nil {
+            return transportError(op, ctx.Err())
+        }
+        return &Error{Op: op, Kind: ErrBadResponse, Err: err}
     }
-    return result.Price
-}
\ No newline at end of file
+    return nil
+}
+
+// validExecution checks an execution belongs to the order and is consistent
+func validExecution(execution Execution, orderId string) error {
+    if execution.OrderID != orderId {
+        return fmt.Errorf("execution for order %q, expected %q", execution.OrderID, orderId)
+    }
+    switch execution.Status {
+    case ExecutionFilled:
+        if execution.FilledAmount < 0 || (execution.FilledAmount > 0 && execution.Price <= 0) {
+            return fmt.Errorf("filled %f at %f", execution.FilledAmount, execution.Price)
+        }
+    case ExecutionRejected, ExecutionNotFound:
+    default:
+        return fmt.Errorf("unknown status %q", execution.Status)
+    }
+    return nil
+}
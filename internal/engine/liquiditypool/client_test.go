@@ -0,0 +1,118 @@
+package liquiditypool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientSendsAssetAndLimitPrice(t *testing.T) {
+	var quoteRequest struct {
+		Asset      string  `json:"asset"`
+		Amount     float64 `json:"amount"`
+		LimitPrice float64 `json:"limit_price"`
+		IsBuy      bool    `json:"is_buy"`
+	}
+	var execute struct {
+		QuoteID string `json:"quote_id"`
+		OrderID string `json:"order_id"`
+	}
+	var idempotencyKey string
+	var liquidityQuery map[string]string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/liquidity":
+			liquidityQuery = map[string]string{
+				"asset":      r.URL.Query().Get("asset"),
+				"isBuyOrder": r.URL.Query().Get("isBuyOrder"),
+				"limitPrice": r.URL.Query().Get("limitPrice"),
+			}
+			json.NewEncoder(w).Encode(map[string]float64{"amount": 7})
+		case "/quote":
+			json.NewDecoder(r.Body).Decode(&quoteRequest)
+			json.NewEncoder(w).Encode(Quote{ID: "q-1", Asset: quoteRequest.Asset, Amount: quoteRequest.Amount, Price: 2500})
+		case "/execute":
+			idempotencyKey = r.Header.Get("Idempotency-Key")
+			json.NewDecoder(r.Body).Decode(&execute)
+			json.NewEncoder(w).Encode(Execution{OrderID: execute.OrderID, QuoteID: execute.QuoteID, Status: ExecutionFilled, FilledAmount: 3, Price: 2500})
+		case "/trades/ord-1":
+			json.NewEncoder(w).Encode(Execution{OrderID: "ord-1", QuoteID: "q-1", Status: ExecutionFilled, FilledAmount: 3, Price: 2500})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	available, err := client.GetAvailableLiquidity(context.Background(), "ETH", true, 2500.5)
+	if err != nil || available != 7 {
+		t.Fatalf("Expected 7 available, got %f (%v)", available, err)
+	}
+	if liquidityQuery["asset"] != "ETH" || liquidityQuery["isBuyOrder"] != "true" || liquidityQuery["limitPrice"] != "2500.5" {
+		t.Errorf("Unexpected liquidity query: %v", liquidityQuery)
+	}
+
+	quote, err := client.RequestQuote(context.Background(), "ETH", true, 3, 2500.5)
+	if err != nil || quote.ID != "q-1" {
+		t.Fatalf("Expected quote q-1, got %+v (%v)", quote, err)
+	}
+	if quoteRequest.Asset != "ETH" || quoteRequest.LimitPrice != 2500.5 || quoteRequest.Amount != 3 || !quoteRequest.IsBuy {
+		t.Errorf("Unexpected quote request: %+v", quoteRequest)
+	}
+
+	execution, err := client.ExecuteQuote(context.Background(), quote.ID, "ord-1")
+	if err != nil || execution.FilledAmount != 3 || execution.Price != 2500 {
+		t.Fatalf("Expected 3 filled at 2500, got %+v (%v)", execution, err)
+	}
+	if execute.QuoteID != "q-1" || execute.OrderID != "ord-1" || idempotencyKey != "ord-1" {
+		t.Errorf("Unexpected execute request: %+v, key %q", execute, idempotencyKey)
+	}
+
+	if status, err := client.TradeStatus(context.Background(), "ord-1"); err != nil || status.Status != ExecutionFilled {
+		t.Errorf("Expected filled status, got %+v (%v)", status, err)
+	}
+	if status, err := client.TradeStatus(context.Background(), "ord-2"); err != nil || status.Status != ExecutionNotFound {
+		t.Errorf("Expected not found status, got %+v (%v)", status, err)
+	}
+}
+
+func TestClientReturnsTypedErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/price/DOWN":
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		case "/price/BAD":
+			w.Write([]byte("not json"))
+		case "/price/SLOW":
+			time.Sleep(200 * time.Millisecond)
+			json.NewEncoder(w).Encode(map[string]float64{"price": 1})
+		case "/quote":
+			http.Error(w, "unknown asset", http.StatusUnprocessableEntity)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	if _, err := client.GetCurrentPrice(context.Background(), "DOWN"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+	if _, err := client.GetCurrentPrice(context.Background(), "BAD"); !errors.Is(err, ErrBadResponse) {
+		t.Errorf("Expected ErrBadResponse, got %v", err)
+	}
+	if _, err := client.RequestQuote(context.Background(), "XYZ", true, 1, 0); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected ErrRejected, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetCurrentPrice(ctx, "SLOW"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
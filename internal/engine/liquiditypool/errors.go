@@ -0,0 +1,62 @@
+package liquiditypool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Error kinds returned by LiquidityPoolClient implementations. Use errors.Is
+// to test for them.
+var (
+	ErrTimeout     = errors.New("liquidity pool timed out")
+	ErrUnavailable = errors.New("liquidity pool unavailable")
+	ErrRejected    = errors.New("liquidity pool rejected the request")
+	ErrBadResponse = errors.New("bad response from liquidity pool")
+)
+
+// Error describes a failed pool call. It unwraps to one of the error kinds.
+type Error struct {
+	Op         string
+	Kind       error
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Op, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// transportError classifies an error returned by http.Client
+func transportError(op string, err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &Error{Op: op, Kind: ErrTimeout, Err: err}
+	}
+	return &Error{Op: op, Kind: ErrUnavailable, Err: err}
+}
+
+// statusError classifies a non-2xx response; nil means success
+func statusError(op string, resp *http.Response) error {
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return &Error{Op: op, Kind: ErrUnavailable, StatusCode: resp.StatusCode}
+	default:
+		return &Error{Op: op, Kind: ErrRejected, StatusCode: resp.StatusCode}
+	}
+}
@@ -1,7 +1,53 @@
 package liquiditypool
 
+import (
+    "context"
+    "time"
+)
+
+// Quote is a firm price the pool holds for one execution until ExpiresAt
+type Quote struct {
+    ID        string    `json:"quote_id"`
+    Asset     string    `json:"asset"`
+    IsBuy     bool      `json:"is_buy"`
+    Amount    float64   `json:"amount"`
+    Price     float64   `json:"price"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExecutionStatus is the outcome of a pool trade
+type ExecutionStatus string
+
+const (
+    // ExecutionFilled means FilledAmount traded at Price; it may be less than quoted
+    ExecutionFilled ExecutionStatus = "filled"
+    // ExecutionRejected means nothing traded, e.g. the quote had expired
+    ExecutionRejected ExecutionStatus = "rejected"
+    // ExecutionNotFound means the pool has no trade for the order
+    ExecutionNotFound ExecutionStatus = "not_found"
+)
+
+// Execution is the result of executing a quote for an order
+type Execution struct {
+    OrderID      string          `json:"order_id"`
+    QuoteID      string          `json:"quote_id"`
+    Status       ExecutionStatus `json:"status"`
+    FilledAmount float64         `json:"filled_amount"`
+    Price        float64         `json:"price"`
+}
+
+// LiquidityPoolClient is the engine's view of the liquidity pool. limitPrice is
+// the worst price the order accepts; 0 means no limit (market orders). Errors
+// unwrap to ErrTimeout, ErrUnavailable, ErrRejected or ErrBadResponse.
+//
+// Trades are two-phase: RequestQuote reserves a price, ExecuteQuote trades it
+// for an order. The pool executes each order ID at most once and returns the
+// original execution for repeats, so after a lost response TradeStatus tells
+// whether the order traded.
 type LiquidityPoolClient interface {
-    GetAvailableLiquidity(isBuyOrder bool) (float64, bool)
-    TradeWithPool(orderId string, amount float64, isBuy bool) (float64, error)
-    GetCurrentPrice(asset string) float64
-} 
\ No newline at end of file
+    GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error)
+    RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (Quote, error)
+    ExecuteQuote(ctx context.Context, quoteID string, orderId string) (Execution, error)
+    TradeStatus(ctx context.Context, orderId string) (Execution, error)
+    GetCurrentPrice(ctx context.Context, asset string) (float64, error)
+}
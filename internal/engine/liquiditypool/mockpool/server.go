@@ -0,0 +1,314 @@
+// Package mockpool provides an in-memory liquidity pool served over HTTP. It
+// speaks the same protocol as liquiditypool.Client and can inject latency,
+// partial fills, errors and lost responses. Tests use NewServer; cmd/lppool
+// serves it standalone.
+package mockpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"matching-engine/internal/engine/liquiditypool"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultQuoteTTL is how long quotes stay executable unless changed
+const DefaultQuoteTTL = 5 * time.Second
+
+// Faults degrades the pool to exercise client error handling
+type Faults struct {
+	// Latency delays every response
+	Latency time.Duration
+	// FillRatio is the share of a quote an execution fills; 0 means 1
+	FillRatio float64
+	// ErrorRate is the probability a request fails with 503
+	ErrorRate float64
+	// LoseRate is the probability an execution trades but its response is lost
+	LoseRate float64
+}
+
+// Pool is the state and protocol of the fake pool. Serve it with NewServer,
+// or mount it on any http.Server.
+type Pool struct {
+	mu         sync.Mutex
+	liquidity  map[string]float64
+	prices     map[string]float64
+	quoteTTL   time.Duration
+	quotes     map[string]liquiditypool.Quote
+	executions map[string]liquiditypool.Execution
+	quoteSeq   int
+	lose       int
+	hide       int
+	faults     Faults
+	rand       *rand.Rand
+	now        func() time.Time
+}
+
+func NewPool() *Pool {
+	return &Pool{
+		liquidity:  make(map[string]float64),
+		prices:     make(map[string]float64),
+		quoteTTL:   DefaultQuoteTTL,
+		quotes:     make(map[string]liquiditypool.Quote),
+		executions: make(map[string]liquiditypool.Execution),
+		rand:       rand.New(rand.NewSource(1)),
+		now:        time.Now,
+	}
+}
+
+// Server is a Pool listening on a local address
+type Server struct {
+	*Pool
+	*httptest.Server
+}
+
+// NewServer starts a pool on a local address; call Close when done
+func NewServer() *Server {
+	pool := NewPool()
+	return &Server{Pool: pool, Server: httptest.NewServer(pool)}
+}
+
+// SetPrice sets the price the pool quotes for asset
+func (p *Pool) SetPrice(asset string, price float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prices[asset] = price
+}
+
+// SetLiquidity sets how much of asset the pool will trade, on either side
+func (p *Pool) SetLiquidity(asset string, amount float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.liquidity[asset] = amount
+}
+
+// SetQuoteTTL changes how long new quotes can be executed
+func (p *Pool) SetQuoteTTL(ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.quoteTTL = ttl
+}
+
+// LoseResponses makes the next n executions trade but drop the connection
+// instead of answering
+func (p *Pool) LoseResponses(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lose = n
+}
+
+// HideExecutions makes the next n status requests answer not found, as a
+// pool that has yet to book an execution would
+func (p *Pool) HideExecutions(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hide = n
+}
+
+// SetFaults injects latency, partial fills and errors. seed makes the
+// random failures reproducible.
+func (p *Pool) SetFaults(faults Faults, seed int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.faults = faults
+	p.rand = rand.New(rand.NewSource(seed))
+}
+
+// Execution returns the execution recorded for an order
+func (p *Pool) Execution(orderID string) (liquiditypool.Execution, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	execution, ok := p.executions[orderID]
+	return execution, ok
+}
+
+func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	faults := p.faults
+	fail := faults.ErrorRate > 0 && p.rand.Float64() < faults.ErrorRate
+	p.mu.Unlock()
+
+	if faults.Latency > 0 {
+		select {
+		case <-time.After(faults.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fail {
+		http.Error(w, "injected failure", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/liquidity" && r.Method == http.MethodGet:
+		p.handleLiquidity(w, r)
+	case r.URL.Path == "/quote" && r.Method == http.MethodPost:
+		p.handleQuote(w, r)
+	case r.URL.Path == "/execute" && r.Method == http.MethodPost:
+		p.handleExecute(w, r)
+	case strings.HasPrefix(r.URL.Path, "/trades/") && r.Method == http.MethodGet:
+		p.handleStatus(w, strings.TrimPrefix(r.URL.Path, "/trades/"))
+	case strings.HasPrefix(r.URL.Path, "/price/") && r.Method == http.MethodGet:
+		p.handlePrice(w, strings.TrimPrefix(r.URL.Path, "/price/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Pool) handleLiquidity(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var limit float64
+	fmt.Sscan(query.Get("limitPrice"), &limit)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	amount := 0.0
+	if p.acceptable(query.Get("asset"), query.Get("isBuyOrder") == "true", limit) {
+		amount = p.liquidity[query.Get("asset")]
+	}
+	writeJSON(w, map[string]float64{"amount": amount})
+}
+
+func (p *Pool) handleQuote(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Asset      string  `json:"asset"`
+		IsBuy      bool    `json:"is_buy"`
+		Amount     float64 `json:"amount"`
+		LimitPrice float64 `json:"limit_price"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount <= 0 {
+		http.Error(w, "invalid quote request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.acceptable(req.Asset, req.IsBuy, req.LimitPrice) {
+		http.Error(w, "no price within limit", http.StatusUnprocessableEntity)
+		return
+	}
+	p.quoteSeq++
+	quote := liquiditypool.Quote{
+		ID:        fmt.Sprintf("q-%d", p.quoteSeq),
+		Asset:     req.Asset,
+		IsBuy:     req.IsBuy,
+		Amount:    min(req.Amount, p.liquidity[req.Asset]),
+		Price:     p.prices[req.Asset],
+		ExpiresAt: p.now().Add(p.quoteTTL),
+	}
+	p.quotes[quote.ID] = quote
+	writeJSON(w, quote)
+}
+
+func (p *Pool) handleExecute(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		QuoteID string `json:"quote_id"`
+		OrderID string `json:"order_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OrderID == "" {
+		http.Error(w, "invalid execute request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	execution, seen := p.executions[req.OrderID]
+	if !seen {
+		execution = p.execute(req.QuoteID, req.OrderID)
+	}
+	lose := p.lose > 0 || (p.faults.LoseRate > 0 && p.rand.Float64() < p.faults.LoseRate)
+	if p.lose > 0 {
+		p.lose--
+	}
+	p.mu.Unlock()
+
+	if lose {
+		dropConnection(w)
+		return
+	}
+	writeJSON(w, execution)
+}
+
+// execute trades a quote once; it must be called with p.mu held
+func (p *Pool) execute(quoteID string, orderID string) liquiditypool.Execution {
+	execution := liquiditypool.Execution{OrderID: orderID, QuoteID: quoteID, Status: liquiditypool.ExecutionRejected}
+	quote, ok := p.quotes[quoteID]
+	delete(p.quotes, quoteID)
+	if ok && p.now().Before(quote.ExpiresAt) {
+		filled := min(quote.Amount, p.liquidity[quote.Asset])
+		if p.faults.FillRatio > 0 {
+			filled *= min(p.faults.FillRatio, 1)
+		}
+		p.liquidity[quote.Asset] -= filled
+		execution.Status = liquiditypool.ExecutionFilled
+		execution.FilledAmount = filled
+		execution.Price = quote.Price
+	}
+	p.executions[orderID] = execution
+	return execution
+}
+
+func (p *Pool) handleStatus(w http.ResponseWriter, orderID string) {
+	p.mu.Lock()
+	execution, ok := p.executions[orderID]
+	if p.hide > 0 {
+		p.hide--
+		ok = false
+	}
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown order", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, execution)
+}
+
+func (p *Pool) handlePrice(w http.ResponseWriter, asset string) {
+	p.mu.Lock()
+	price, ok := p.prices[asset]
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown asset", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]float64{"price": price})
+}
+
+// acceptable reports whether the pool price is within limit; it must be
+// called with p.mu held
+func (p *Pool) acceptable(asset string, isBuy bool, limit float64) bool {
+	price, ok := p.prices[asset]
+	if !ok {
+		return false
+	}
+	if limit <= 0 {
+		return true
+	}
+	if isBuy {
+		return price <= limit
+	}
+	return price >= limit
+}
+
+// dropConnection closes the connection without writing a response, as if
+// it was lost on the way back
+func dropConnection(w http.ResponseWriter) {
+	if hijacker, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hijacker.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,318 @@
+package liquiditypool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoLiquidity is returned by Route when no venue quoted the order
+var ErrNoLiquidity = errors.New("no venue has liquidity")
+
+// reconcileAttempts is how often a venue is asked for the status of an
+// execution whose response was lost, reconcileBackoff the pause before asking
+// again, doubled each time. A venue may not have booked the execution yet, so
+// an order it does not know is asked about again until the attempts run out.
+const (
+	reconcileAttempts = 3
+	reconcileBackoff  = 50 * time.Millisecond
+)
+
+// Venue is a liquidity provider the router can send orders to. FeeRate is
+// the venue's charge on notional, used to compare prices net of fees.
+type Venue struct {
+	Name    string
+	Client  LiquidityPoolClient
+	FeeRate float64
+}
+
+// RouteRequest is the part of an order the book could not fill
+type RouteRequest struct {
+	OrderID    string
+	Asset      string
+	IsBuy      bool
+	Amount     float64
+	LimitPrice float64
+	// Accept, if set, filters quotes by price; venues should already honour
+	// LimitPrice but quotes beyond it are never executed
+	Accept func(price float64) bool
+}
+
+func (req RouteRequest) accepts(price float64) bool {
+	return req.Accept == nil || req.Accept(price)
+}
+
+// VenueFill is the execution of part of an order at one venue
+type VenueFill struct {
+	Venue string
+	Execution
+}
+
+// PendingTrade is an execution whose outcome could not be confirmed, or
+// that needs looking at for Reason
+type PendingTrade struct {
+	Venue   string `json:"venue"`
+	OrderID string `json:"order_id"`
+	QuoteID string `json:"quote_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Router splits orders across venues by best price net of fees. Every
+// venue call is bounded by the router timeout, and with a budget set all the
+// calls of one Route together are bounded by the budget.
+type Router struct {
+	mu           sync.Mutex
+	venues       []Venue
+	timeout      time.Duration
+	budget       time.Duration
+	unreconciled []PendingTrade
+}
+
+func NewRouter(timeout time.Duration, venues ...Venue) *Router {
+	r := &Router{timeout: timeout}
+	for _, venue := range venues {
+		r.AddVenue(venue)
+	}
+	return r
+}
+
+// AddVenue adds or replaces the venue with the same name
+func (r *Router) AddVenue(venue Venue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.venues {
+		if r.venues[i].Name == venue.Name {
+			r.venues[i] = venue
+			return
+		}
+	}
+	r.venues = append(r.venues, venue)
+}
+
+// Venues returns the venues in the order they were added
+func (r *Router) Venues() []Venue {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Venue(nil), r.venues...)
+}
+
+// SetTimeout changes how long a single venue call may take
+func (r *Router) SetTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeout = timeout
+}
+
+// SetBudget bounds the total time Route may take, reconciliation and
+// fallthrough to other venues included; 0 leaves it unbounded. An execution
+// whose outcome is still unknown when the budget runs out is kept for review
+// like one that could not be reconciled.
+func (r *Router) SetBudget(budget time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.budget = budget
+}
+
+// Unreconciled returns executions whose outcome is still unknown and those
+// flagged for review
+func (r *Router) Unreconciled() []PendingTrade {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PendingTrade(nil), r.unreconciled...)
+}
+
+// Flag keeps an execution for manual review with the unreconciled ones
+func (r *Router) Flag(trade PendingTrade) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unreconciled = append(r.unreconciled, trade)
+}
+
+// venueQuote is a quote from one venue ranked by its price net of fees
+type venueQuote struct {
+	venue    Venue
+	quote    Quote
+	netPrice float64
+}
+
+// Route quotes every venue in parallel, takes the best net prices until the
+// amount is covered and executes each venue's share. Fills are returned best
+// price first; the share of a venue that fails goes to the next best venues.
+func (r *Router) Route(req RouteRequest) ([]VenueFill, error) {
+	venues := r.Venues()
+	r.mu.Lock()
+	timeout, budget := r.timeout, r.budget
+	r.mu.Unlock()
+
+	ctx := context.Background()
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	quotes := make([]*venueQuote, len(venues))
+	var wg sync.WaitGroup
+	for i, venue := range venues {
+		wg.Add(1)
+		go func(i int, venue Venue) {
+			defer wg.Done()
+			quote, err := quoteVenue(ctx, venue, req, req.Amount, timeout)
+			if err == nil {
+				quotes[i] = &venueQuote{venue: venue, quote: quote, netPrice: netPrice(quote.Price, venue.FeeRate, req.IsBuy)}
+			}
+		}(i, venue)
+	}
+	wg.Wait()
+
+	ranked := make([]*venueQuote, 0, len(quotes))
+	for _, q := range quotes {
+		if q != nil && q.quote.Amount > 0 && req.accepts(q.quote.Price) {
+			ranked = append(ranked, q)
+		}
+	}
+	if len(ranked) == 0 {
+		return nil, ErrNoLiquidity
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if req.IsBuy {
+			return ranked[i].netPrice < ranked[j].netPrice
+		}
+		return ranked[i].netPrice > ranked[j].netPrice
+	})
+
+	// Allocate greedily. A share a venue fails to fill passes to the venues
+	// not yet tried; a venue is never tried twice, as it would take the
+	// order ID for a repeat of its first execution. Once the budget is
+	// spent no further venue is tried.
+	result := make([]VenueFill, 0, len(ranked))
+	remaining := req.Amount
+	for next := 0; remaining > 0 && next < len(ranked) && ctx.Err() == nil; {
+		unallocated := remaining
+		allocated := make([]*venueQuote, 0, len(ranked)-next)
+		shares := make([]float64, 0, len(ranked)-next)
+		for ; unallocated > 0 && next < len(ranked); next++ {
+			q := ranked[next]
+			share := min(unallocated, q.quote.Amount)
+			unallocated -= share
+			allocated = append(allocated, q)
+			shares = append(shares, share)
+		}
+		for _, fill := range r.fill(ctx, req, allocated, shares, timeout) {
+			remaining -= fill.FilledAmount
+			result = append(result, fill)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no venue filled order %s", req.OrderID)
+	}
+	return result, nil
+}
+
+// fill executes each venue's share in parallel and returns the fills in the
+// order of the venues. A venue given less than it quoted is quoted again for
+// its share since quotes execute in full.
+func (r *Router) fill(ctx context.Context, req RouteRequest, venues []*venueQuote, shares []float64, timeout time.Duration) []VenueFill {
+	fills := make([]*VenueFill, len(venues))
+	var wg sync.WaitGroup
+	for i, q := range venues {
+		wg.Add(1)
+		go func(i int, q *venueQuote, share float64) {
+			defer wg.Done()
+			quote := q.quote
+			if share < quote.Amount {
+				requote, err := quoteVenue(ctx, q.venue, req, share, timeout)
+				if err != nil || requote.Amount <= 0 || !req.accepts(requote.Price) {
+					return
+				}
+				quote = requote
+			}
+			execution, err := r.execute(ctx, q.venue, quote, req.OrderID, timeout)
+			if err == nil && execution.Status == ExecutionFilled && execution.FilledAmount > 0 {
+				fills[i] = &VenueFill{Venue: q.venue.Name, Execution: execution}
+			}
+		}(i, q, shares[i])
+	}
+	wg.Wait()
+
+	result := make([]VenueFill, 0, len(fills))
+	for _, fill := range fills {
+		if fill != nil {
+			result = append(result, *fill)
+		}
+	}
+	return result
+}
+
+// quoteVenue checks a venue's liquidity and asks it for a quote of up to amount
+func quoteVenue(parent context.Context, venue Venue, req RouteRequest, amount float64, timeout time.Duration) (Quote, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	available, err := venue.Client.GetAvailableLiquidity(ctx, req.Asset, req.IsBuy, req.LimitPrice)
+	cancel()
+	if err != nil {
+		return Quote{}, fmt.Errorf("checking %s liquidity: %w", venue.Name, err)
+	}
+	if available <= 0 {
+		return Quote{}, ErrNoLiquidity
+	}
+
+	ctx, cancel = context.WithTimeout(parent, timeout)
+	defer cancel()
+	quote, err := venue.Client.RequestQuote(ctx, req.Asset, req.IsBuy, min(amount, available), req.LimitPrice)
+	if err != nil {
+		return Quote{}, fmt.Errorf("requesting %s quote: %w", venue.Name, err)
+	}
+	return quote, nil
+}
+
+// execute trades a quote at a venue. An execution whose response is lost is
+// reconciled by order ID; if the venue cannot tell its outcome within the
+// reconcile attempts or before parent is done it is kept for manual review.
+func (r *Router) execute(parent context.Context, venue Venue, quote Quote, orderID string, timeout time.Duration) (Execution, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	execution, err := venue.Client.ExecuteQuote(ctx, quote.ID, orderID)
+	cancel()
+	if err == nil || errors.Is(err, ErrRejected) {
+		return execution, err
+	}
+
+	// The venue may have traded even though we never saw the answer
+	backoff := reconcileBackoff
+	for attempt := 0; attempt < reconcileAttempts && parent.Err() == nil; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-parent.Done():
+				continue
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		execution, err = venue.Client.TradeStatus(ctx, orderID)
+		cancel()
+		if err == nil && execution.Status == ExecutionNotFound {
+			err = fmt.Errorf("%s has no trade for order %s", venue.Name, orderID)
+		}
+		if err == nil {
+			return execution, nil
+		}
+	}
+
+	r.mu.Lock()
+	r.unreconciled = append(r.unreconciled, PendingTrade{Venue: venue.Name, OrderID: orderID, QuoteID: quote.ID})
+	r.mu.Unlock()
+	return Execution{}, fmt.Errorf("%s outcome of order %s is unknown: %w", venue.Name, orderID, err)
+}
+
+// netPrice is what a fill at price really costs a buyer or earns a seller
+// after the venue fee
+func netPrice(price float64, feeRate float64, isBuy bool) float64 {
+	if isBuy {
+		return price * (1 + feeRate)
+	}
+	return price * (1 - feeRate)
+}
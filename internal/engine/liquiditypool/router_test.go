@@ -0,0 +1,155 @@
+package liquiditypool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fixedVenue quotes a fixed price for up to its liquidity
+type fixedVenue struct {
+	mu        sync.Mutex
+	price     float64
+	liquidity float64
+	quotes    map[string]Quote
+	err       error
+	// reject refuses every execution
+	reject bool
+}
+
+func (v *fixedVenue) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
+	return v.liquidity, v.err
+}
+
+func (v *fixedVenue) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (Quote, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.quotes == nil {
+		v.quotes = make(map[string]Quote)
+	}
+	quote := Quote{ID: fmt.Sprintf("q-%d", len(v.quotes)+1), Asset: asset, IsBuy: isBuy, Amount: min(amount, v.liquidity), Price: v.price}
+	v.quotes[quote.ID] = quote
+	return quote, nil
+}
+
+func (v *fixedVenue) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (Execution, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	quote := v.quotes[quoteID]
+	if v.reject {
+		return Execution{OrderID: orderId, QuoteID: quoteID, Status: ExecutionRejected}, nil
+	}
+	return Execution{OrderID: orderId, QuoteID: quoteID, Status: ExecutionFilled, FilledAmount: quote.Amount, Price: quote.Price}, nil
+}
+
+func (v *fixedVenue) TradeStatus(ctx context.Context, orderId string) (Execution, error) {
+	return Execution{OrderID: orderId, Status: ExecutionNotFound}, nil
+}
+
+func (v *fixedVenue) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	return v.price, v.err
+}
+
+func TestRouterSplitsByPriceNetOfFees(t *testing.T) {
+	router := NewRouter(time.Second,
+		// Cheaper on paper but dearer after its fee
+		Venue{Name: "a", Client: &fixedVenue{price: 100, liquidity: 6}, FeeRate: 0.01},
+		Venue{Name: "b", Client: &fixedVenue{price: 100.5, liquidity: 5}},
+		Venue{Name: "down", Client: &fixedVenue{price: 90, liquidity: 100, err: &Error{Op: "liquidity", Kind: ErrUnavailable}}},
+	)
+
+	fills, err := router.Route(RouteRequest{OrderID: "ord-1", Asset: "BTC", IsBuy: true, Amount: 10})
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	if len(fills) != 2 {
+		t.Fatalf("Expected fills from two venues, got %+v", fills)
+	}
+	if fills[0].Venue != "b" || fills[0].FilledAmount != 5 || fills[0].Price != 100.5 {
+		t.Errorf("Expected 5 at 100.5 from b first, got %+v", fills[0])
+	}
+	if fills[1].Venue != "a" || fills[1].FilledAmount != 5 || fills[1].Price != 100 {
+		t.Errorf("Expected the remaining 5 at 100 from a, got %+v", fills[1])
+	}
+}
+
+func TestRouterSellsToHighestNetPrice(t *testing.T) {
+	router := NewRouter(time.Second,
+		Venue{Name: "a", Client: &fixedVenue{price: 100, liquidity: 10}, FeeRate: 0.002},
+		Venue{Name: "b", Client: &fixedVenue{price: 99.9, liquidity: 10}},
+	)
+
+	fills, err := router.Route(RouteRequest{OrderID: "ord-1", Asset: "BTC", Amount: 4})
+	if err != nil || len(fills) != 1 || fills[0].Venue != "b" || fills[0].FilledAmount != 4 {
+		t.Fatalf("Expected all 4 sold at b, got %+v (%v)", fills, err)
+	}
+
+	if _, err := NewRouter(time.Second).Route(RouteRequest{OrderID: "ord-2", Asset: "BTC", Amount: 1}); err != ErrNoLiquidity {
+		t.Errorf("Expected ErrNoLiquidity without venues, got %v", err)
+	}
+}
+
+func TestRouterPassesUnfilledSharesOn(t *testing.T) {
+	router := NewRouter(time.Second,
+		Venue{Name: "best", Client: &fixedVenue{price: 99, liquidity: 3, reject: true}},
+		Venue{Name: "next", Client: &fixedVenue{price: 100, liquidity: 3}},
+		Venue{Name: "last", Client: &fixedVenue{price: 101, liquidity: 10}},
+	)
+
+	fills, err := router.Route(RouteRequest{OrderID: "ord-1", Asset: "BTC", IsBuy: true, Amount: 5})
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	if len(fills) != 2 {
+		t.Fatalf("Expected fills from two venues, got %+v", fills)
+	}
+	if fills[0].Venue != "next" || fills[0].FilledAmount != 2 {
+		t.Errorf("Expected next to fill its share of 2, got %+v", fills[0])
+	}
+	if fills[1].Venue != "last" || fills[1].FilledAmount != 3 {
+		t.Errorf("Expected last to take the 3 best refused, got %+v", fills[1])
+	}
+}
+
+// hangingVenue quotes like fixedVenue but never answers an execution or a
+// status request before the caller gives up
+type hangingVenue struct {
+	fixedVenue
+}
+
+func (v *hangingVenue) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (Execution, error) {
+	<-ctx.Done()
+	return Execution{}, ctx.Err()
+}
+
+func (v *hangingVenue) TradeStatus(ctx context.Context, orderId string) (Execution, error) {
+	<-ctx.Done()
+	return Execution{}, ctx.Err()
+}
+
+func TestRouteStopsWhenItsBudgetIsSpent(t *testing.T) {
+	next := &fixedVenue{price: 100, liquidity: 10}
+	router := NewRouter(time.Second,
+		Venue{Name: "hanging", Client: &hangingVenue{fixedVenue{price: 99, liquidity: 10}}},
+		Venue{Name: "next", Client: next},
+	)
+	router.SetBudget(100 * time.Millisecond)
+
+	start := time.Now()
+	fills, err := router.Route(RouteRequest{OrderID: "ord-1", Asset: "BTC", IsBuy: true, Amount: 5})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected Route to stop at its budget, took %v", elapsed)
+	}
+	if err == nil || len(fills) != 0 {
+		t.Fatalf("Expected no fills once the budget ran out, got %+v, %v", fills, err)
+	}
+	if len(next.quotes) != 1 {
+		t.Errorf("Expected the next venue only quoted, not executed with, got quotes %+v", next.quotes)
+	}
+	unreconciled := router.Unreconciled()
+	if len(unreconciled) != 1 || unreconciled[0].Venue != "hanging" || unreconciled[0].OrderID != "ord-1" {
+		t.Errorf("Expected the unanswered execution kept for review, got %+v", unreconciled)
+	}
+}
@@ -0,0 +1,252 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"matching-engine/internal/account"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/margin"
+	"matching-engine/pkg/utils"
+	"math"
+	"time"
+)
+
+// ClearingAccount pays out realized profits and collects realized losses of
+// margin positions
+const ClearingAccount = "clearing"
+
+// SetMarginManager switches settlement from spot delivery to margin positions.
+// Fills then update positions and only realized P&L and fees move between
+// accounts. Requires SetAccountManager to have been called.
+func (e *MatchingEngine) SetMarginManager(m *margin.Manager) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.accounts == nil {
+		return errors.New("margin requires an account manager")
+	}
+	e.accounts.AddSystemAccount(ClearingAccount)
+	e.margin = m
+	e.adl.SetPositionProvider(m.Positions())
+	return nil
+}
+
+// Margin returns the margin manager, or nil if positions are not tracked
+func (e *MatchingEngine) Margin() *margin.Manager {
+	return e.margin
+}
+
+// Price values an asset in the quote asset for margin purposes at the last
+// price seen for it, 0 before any has been. Margin checks run with the engine
+// locked, so it never calls the pool; RefreshPrices keeps it current.
+func (e *MatchingEngine) Price(asset string) float64 {
+	if asset == e.quoteAsset {
+		return 1
+	}
+	return e.lastPrice(asset)
+}
+
+// RefreshPrices fetches the pool price of assets, and of every other asset
+// priced so far, each interval until ctx is done. It does not take the
+// engine lock, so a slow pool never holds up matching.
+func (e *MatchingEngine) RefreshPrices(ctx context.Context, assets []string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		known := make(map[string]bool)
+		for _, asset := range assets {
+			known[asset] = true
+		}
+		e.priceMu.Lock()
+		for asset := range e.lastPrices {
+			known[asset] = true
+		}
+		e.priceMu.Unlock()
+		for asset := range known {
+			if asset == e.quoteAsset {
+				continue
+			}
+			if _, err := e.fetchPrice(asset); err != nil {
+				utils.LogError(fmt.Errorf("refreshing price of %s: %w", asset, err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkMargin verifies the order's initial margin against the trader's
+// portfolio for cross orders, or quote balance for isolated ones
+func (e *MatchingEngine) checkMargin(order Order, currentPrice float64) error {
+	price := order.Price
+	if order.Type == Market {
+		price = currentPrice
+	}
+	quantity := order.Amount
+	if !order.IsBuyOrder {
+		quantity = -quantity
+	}
+	return e.margin.CheckOrder(order.Trader, order.Asset, order.MarginType == Isolated, quantity, price, order.Leverage)
+}
+
+// positionSettlement is what a fill does to one side's margin: the position
+// it leaves, the transfers of its realized P&L and fee, the isolated margin it
+// frees and the isolated margin it has to post
+type positionSettlement struct {
+	order     Order
+	position  margin.Position
+	transfers []account.Transfer
+	release   float64
+	hold      float64
+	// shortfall is the part of an isolated loss its margin could not pay
+	shortfall float64
+}
+
+// settleMarginFill applies a fill to both sides' positions. The maker's order
+// margin is released for the filled part as the position now carries it.
+// Both sides are worked out first and positions only move once every
+// transfer is made and the isolated margin they open can be posted, so a
+// fill either settles in full or, with an error, changes nothing.
+func (e *MatchingEngine) settleMarginFill(fill Fill, taker Order, maker Order) error {
+	takerQuantity := fill.Amount
+	if !fill.TakerIsBuy {
+		takerQuantity = -takerQuantity
+	}
+	takerSide := e.planPosition(taker, e.margin.Positions().Current(taker.Trader, taker.Asset, taker.MarginType == Isolated), takerQuantity, fill.Price, fill.TakerFee)
+	sides := []positionSettlement{takerSide}
+
+	orderMargin := 0.0
+	if !fill.FromPool {
+		before := e.margin.Positions().Current(maker.Trader, maker.Asset, maker.MarginType == Isolated)
+		if before.Trader == taker.Trader && before.Isolated == (taker.MarginType == Isolated) {
+			// A trader filling its own order moves the same position twice
+			before = takerSide.position
+		}
+		sides = append(sides, e.planPosition(maker, before, -takerQuantity, fill.Price, fill.MakerFee))
+		if hold, ok := e.accounts.HoldFor(maker.ID); ok {
+			orderMargin = math.Min(margin.InitialMargin(fill.Amount*maker.Price, maker.Leverage), hold.Amount)
+		}
+	}
+
+	// Each trader must be left able to post the isolated margin the fill
+	// opens once its P&L, fee and released margin have moved
+	needed := make(map[string]float64)
+	for _, side := range sides {
+		needed[side.order.Trader] += side.hold - side.release
+		for _, transfer := range side.transfers {
+			if transfer.HoldOrderID == "" {
+				needed[transfer.From] += transfer.Amount
+			}
+			needed[transfer.To] -= transfer.Amount
+		}
+	}
+	needed[maker.Trader] -= orderMargin
+	for _, side := range sides {
+		if side.hold <= 0 {
+			continue
+		}
+		if err := e.accounts.CheckAvailable(side.order.Trader, e.quoteAsset, needed[side.order.Trader]); err != nil {
+			return fmt.Errorf("posting isolated margin of %s in %s: %w", side.order.Trader, side.order.Asset, err)
+		}
+	}
+
+	transfers := make([]account.Transfer, 0, 4)
+	for _, side := range sides {
+		transfers = append(transfers, side.transfers...)
+	}
+	released := e.accounts.ReduceHold(maker.ID, orderMargin)
+	if len(transfers) > 0 {
+		if err := e.accounts.Settle(fillReference(fill), transfers...); err != nil {
+			if released > 0 {
+				e.accounts.PlaceHold(maker.ID, maker.Trader, e.quoteAsset, released)
+			}
+			return err
+		}
+	}
+	for _, side := range sides {
+		e.applyPosition(side)
+		if side.shortfall > 0 {
+			e.absorbShortfall(side.order.Trader, side.shortfall, fill.TradeID)
+		}
+	}
+	return nil
+}
+
+// planPosition works out what adding quantity at price does to a trader's
+// position, starting from before, and the transfers that settle it.
+// Isolated positions keep their margin in a dedicated hold, so their losses
+// are paid from it and cannot reach the rest of the account.
+func (e *MatchingEngine) planPosition(order Order, before margin.Position, quantity float64, price float64, fee float64) positionSettlement {
+	isolated := order.MarginType == Isolated
+	holdKey := margin.IsolatedHoldKey(order.Trader, order.Asset)
+	isolatedMargin, _ := e.accounts.HoldFor(holdKey)
+
+	position, change := before.Fill(quantity, price, order.Leverage)
+	side := positionSettlement{order: order, position: position, transfers: make([]account.Transfer, 0, 2)}
+	if isolated && change.Closed > 0 && change.SizeBefore > 0 {
+		side.release = isolatedMargin.Amount * change.Closed / change.SizeBefore
+	}
+
+	switch {
+	case change.Realized > 0:
+		side.transfers = append(side.transfers, account.Transfer{
+			From: ClearingAccount, To: order.Trader, Asset: e.quoteAsset, Amount: change.Realized,
+		})
+	case change.Realized < 0:
+		loss := -change.Realized
+		transfer := account.Transfer{From: order.Trader, To: ClearingAccount, Asset: e.quoteAsset, Amount: loss}
+		if isolated {
+			// The loss is capped at the position's margin; anything beyond
+			// it is a liquidation shortfall for the insurance fund, not the
+			// trader's other funds
+			if loss > isolatedMargin.Amount {
+				side.shortfall = loss - isolatedMargin.Amount
+				loss = isolatedMargin.Amount
+			}
+			transfer.Amount, transfer.HoldOrderID = loss, holdKey
+			side.release -= loss
+		}
+		if transfer.Amount > 0 {
+			side.transfers = append(side.transfers, transfer)
+		}
+	}
+	if side.release < 0 {
+		side.release = 0
+	}
+
+	if fee > 0 {
+		side.transfers = append(side.transfers, account.Transfer{
+			From: order.Trader, To: FeeAccount, Asset: e.quoteAsset, Amount: fee, Type: ledger.EntryFee,
+		})
+	} else if fee < 0 {
+		side.transfers = append(side.transfers, account.Transfer{
+			From: FeeAccount, To: order.Trader, Asset: e.quoteAsset, Amount: -fee, Type: ledger.EntryFee,
+		})
+	}
+
+	if isolated && change.Opened > 0 {
+		side.hold = margin.InitialMargin(change.Opened*price, order.Leverage)
+	}
+	return side
+}
+
+// applyPosition moves a position once its transfers are settled, then frees
+// and posts its isolated margin, which settleMarginFill has checked can be
+// posted
+func (e *MatchingEngine) applyPosition(side positionSettlement) {
+	e.margin.Positions().Set(side.position)
+	holdKey := margin.IsolatedHoldKey(side.order.Trader, side.order.Asset)
+	if side.release > 0 {
+		e.accounts.ReduceHold(holdKey, side.release)
+	}
+	if side.hold > 0 {
+		if err := e.accounts.PlaceHold(holdKey, side.order.Trader, e.quoteAsset, side.hold); err != nil {
+			utils.LogError(fmt.Errorf("posting isolated margin of %s in %s: %w", side.order.Trader, side.order.Asset, err))
+		}
+	}
+}
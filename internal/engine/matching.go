@@ -1,35 +1,120 @@
 package engine
 
 import (
+    "context"
+    "errors"
     "fmt"
+    "matching-engine/internal/account"
     "matching-engine/internal/engine/liquiditypool"
+    "matching-engine/internal/events"
+    "matching-engine/internal/fees"
+    "matching-engine/internal/hedge"
+    "matching-engine/internal/journal"
+    "matching-engine/internal/margin"
+    "matching-engine/internal/risk"
+    "matching-engine/pkg/utils"
     "sort"
-    
+    "sync"
+    "sync/atomic"
+    "time"
 )
 
+// DefaultPoolTimeout bounds each liquidity pool call made while matching
+const DefaultPoolTimeout = 500 * time.Millisecond
+
+// DefaultRouteBudget bounds all the venue calls routing one order makes. The
+// book is locked while an order is routed, so this is the longest a slow
+// venue can hold up every other command.
+const DefaultRouteBudget = 4 * DefaultPoolTimeout
+
+// DefaultVenue names the liquidity pool passed to NewMatchingEngine
+const DefaultVenue = "pool"
+
 type MatchingEngine struct {
-    orderBook     OrderBook
-    liquidityPool liquiditypool.LiquidityPoolClient
+    mu             sync.Mutex
+    orderBook      OrderBook
+    liquidityPool  liquiditypool.LiquidityPoolClient
+    insurance      *risk.InsuranceFund
+    adl            *risk.AutoDeleverager
+    accounts       *account.Manager
+    quoteAsset     string
+    fees           *fees.Calculator
+    margin         *margin.Manager
+    ids            IDGenerator
+    poolTimeout    atomic.Int64
+    priceMu        sync.Mutex
+    lastPrices     map[string]float64
+    router         *liquiditypool.Router
+    hedger         *hedge.Hedger
+    journal        *journal.Journal
+    clock          func() time.Time
+    commandTime    atomic.Int64
+    replaying      bool
+    replayPool     *poolResult
+    fence          Fence
+    publisher      events.Publisher
+    eventSeq       uint64
+    pendingEvents  []events.Event
+    unpublished    []events.Event
+    commandSeq     uint64
+    statuses       map[string]*OrderStatus
+    finishedOrders []string
+    checksums      map[string]uint32
 }
 
 func NewMatchingEngine(lp liquiditypool.LiquidityPoolClient) *MatchingEngine {
-    return &MatchingEngine{
+    insurance := risk.NewInsuranceFund()
+    e := &MatchingEngine{
         orderBook: OrderBook{
             BuyOrders:  make([]Order, 0),
             SellOrders: make([]Order, 0),
         },
         liquidityPool: lp,
+        insurance:     insurance,
+        adl:           risk.NewAutoDeleverager(insurance, nil),
+        quoteAsset:    "USD",
+        fees:          fees.NewCalculator(fees.Schedule{}),
+        lastPrices:    make(map[string]float64),
+        router: liquiditypool.NewRouter(DefaultPoolTimeout, liquiditypool.Venue{
+            Name:   DefaultVenue,
+            Client: lp,
+        }),
+        ids:       &SequentialIDs{},
+        clock:     time.Now,
+        statuses:  make(map[string]*OrderStatus),
+        checksums: make(map[string]uint32),
     }
+    e.poolTimeout.Store(int64(DefaultPoolTimeout))
+    e.router.SetBudget(DefaultRouteBudget)
+    insurance.SetClock(e.now)
+    e.fees.SetClock(e.now)
+    return e
 }
 
 func (e *MatchingEngine) ProcessOrder(order Order) MatchResult {
+    // The price is looked up before taking the lock, so a slow pool does not
+    // hold up other commands while it answers
+    currentPrice, priceErr := e.fetchPrice(order.Asset)
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    defer e.begin(e.clock())()
+
+    if order.ID == "" {
+        order.ID = e.ids.NextOrderID()
+    }
     order.InitialAmount = order.Amount
     order.FilledAmount = 0
 
-    currentPrice := e.liquidityPool.GetCurrentPrice(order.Asset)
+    // Stop loss and take profit only trigger on a live price; a failed
+    // lookup falls back to the last known price for valuation only
+    if priceErr != nil {
+        currentPrice = e.lastPrice(order.Asset)
+    }
 
-    if e.checkStopLossAndTakeProfit(order, currentPrice) {
+    if priceErr == nil && e.checkStopLossAndTakeProfit(order, currentPrice) {
         return MatchResult{
+            OrderID:         order.ID,
             Success:         true,
             FilledAmount:    order.Amount,
             RemainingAmount: 0,
@@ -38,13 +123,47 @@ func (e *MatchingEngine) ProcessOrder(order Order) MatchResult {
         }
     }
 
+    if err := e.checkFunds(order, currentPrice); err != nil {
+        // Rejections are journaled too, so replay reproduces their events
+        if journalErr := e.record(journal.RecordRejection, rejectionCommand{Order: order, Reason: err.Error()}); journalErr != nil {
+            if !refused(journalErr) {
+                utils.LogError(journalErr)
+            }
+        } else {
+            e.emitRejection(order, err.Error())
+        }
+        return MatchResult{
+            OrderID:         order.ID,
+            Success:         false,
+            RemainingAmount: order.Amount,
+            Message:         fmt.Sprintf("Order %s rejected: %v", order.ID, err),
+        }
+    }
+
+    // The order is accepted. It is journaled with the price it was checked
+    // against, so a replay does not have to look either up again.
+    if err := e.record(journal.RecordNewOrder, newOrderCommand{Order: order, ReferencePrice: currentPrice}); err != nil {
+        utils.LogError(err)
+        return MatchResult{
+            OrderID:         order.ID,
+            Success:         false,
+            RemainingAmount: order.Amount,
+            Message:         fmt.Sprintf("Order %s rejected: %v", order.ID, err),
+        }
+    }
+    return e.execute(order, currentPrice)
+}
+
+// execute matches an accepted order; it must be called with e.mu held
+func (e *MatchingEngine) execute(order Order, currentPrice float64) MatchResult {
+    e.emit(orderEvent(events.OrderAccepted, order))
     if order.Type == Market {
-        return e.processMarketOrder(order)
+        return e.processMarketOrder(order, currentPrice)
     }
-    return e.processLimitOrder(order)
+    return e.processLimitOrder(order, currentPrice)
 }
 
-func (e *MatchingEngine) processMarketOrder(order Order) MatchResult {
+func (e *MatchingEngine) processMarketOrder(order Order, currentPrice float64) MatchResult {
     var matchingOrders *[]Order
     if order.IsBuyOrder {
         matchingOrders = &e.orderBook.SellOrders
@@ -52,20 +171,31 @@ func (e *MatchingEngine) processMarketOrder(order Order) MatchResult {
         matchingOrders = &e.orderBook.BuyOrders
     }
 
-    result := e.matchOrders(order, matchingOrders)
-    
-    if result.RemainingAmount > 0 {
-        if lpFilled, err := e.tryLiquidityPool(order, result.RemainingAmount); err == nil {
-            result.FilledAmount += lpFilled
-            result.RemainingAmount -= lpFilled
-            result.Success = result.RemainingAmount == 0
+    result, settleErr := e.matchOrders(order, matchingOrders)
+
+    if result.RemainingAmount > 0 && settleErr == nil {
+        if venueFills, err := e.tryLiquidityPool(order, result.RemainingAmount); err == nil {
+            settled := true
+            for _, venueFill := range venueFills {
+                settled = e.addPoolFill(&result, order, venueFill) && settled
+            }
+            result.Success = result.RemainingAmount == 0 && settled
         }
     }
 
+    reason := "no liquidity for the remainder of a market order"
+    if settleErr != nil {
+        reason = settleErr.Error()
+    }
+    e.emitTakerOutcome(order, result, false, reason)
+    e.summarize(&result, order)
+    if settleErr != nil {
+        result.Message += "; stopped: " + settleErr.Error()
+    }
     return result
 }
 
-func (e *MatchingEngine) processLimitOrder(order Order) MatchResult {
+func (e *MatchingEngine) processLimitOrder(order Order, currentPrice float64) MatchResult {
     var matchingOrders *[]Order
     if order.IsBuyOrder {
         matchingOrders = &e.orderBook.SellOrders
@@ -73,37 +203,54 @@ func (e *MatchingEngine) processLimitOrder(order Order) MatchResult {
         matchingOrders = &e.orderBook.BuyOrders
     }
 
-    result := e.matchOrders(order, matchingOrders)
+    result, stopErr := e.matchOrders(order, matchingOrders)
 
-    if result.RemainingAmount > 0 {
-        if lpFilled, err := e.tryLiquidityPool(order, result.RemainingAmount); err == nil {
-            result.FilledAmount += lpFilled
-            result.RemainingAmount -= lpFilled
-            result.Success = result.RemainingAmount == 0
+    rested := false
+    if result.RemainingAmount > 0 && stopErr == nil {
+        if venueFills, err := e.tryLiquidityPool(order, result.RemainingAmount); err == nil {
+            settled := true
+            for _, venueFill := range venueFills {
+                settled = e.addPoolFill(&result, order, venueFill) && settled
+            }
+            result.Success = result.RemainingAmount == 0 && settled
         }
 
         if result.RemainingAmount > 0 {
             remainingOrder := order
             remainingOrder.Amount = result.RemainingAmount
-            if order.IsBuyOrder {
-                e.orderBook.BuyOrders = append(e.orderBook.BuyOrders, remainingOrder)
-            } else {
-                e.orderBook.SellOrders = append(e.orderBook.SellOrders, remainingOrder)
+            // Only an order whose funds are held may rest
+            if stopErr = e.holdRestingOrder(remainingOrder); stopErr == nil {
+                rested = true
+                if order.IsBuyOrder {
+                    e.orderBook.BuyOrders = append(e.orderBook.BuyOrders, remainingOrder)
+                } else {
+                    e.orderBook.SellOrders = append(e.orderBook.SellOrders, remainingOrder)
+                }
             }
         }
     }
 
+    reason := ""
+    if stopErr != nil {
+        reason = stopErr.Error()
+    }
+    e.emitTakerOutcome(order, result, rested, reason)
+    e.summarize(&result, order)
+    if stopErr != nil {
+        result.Message += "; stopped: " + stopErr.Error()
+    }
     return result
 }
 
 // 1. Change in matchOrders function - replace the current function with this version
-func (e *MatchingEngine) matchOrders(order Order, matchingOrders *[]Order) MatchResult {
+//
+// Each fill is settled before the book changes. A fill that cannot settle
+// leaves both orders as they were and stops matching; the error says why.
+func (e *MatchingEngine) matchOrders(order Order, matchingOrders *[]Order) (MatchResult, error) {
     remainingAmount := order.Amount
     filledAmount := 0.0
-    weightedSum := 0.0
-    executedPrice := 0.0
-    orderbookFilled := 0.0
-    lpFilled := 0.0
+    fills := make([]Fill, 0)
+    var settleErr error
 
     // Sort orders by price
     if order.IsBuyOrder {
@@ -132,11 +279,30 @@ func (e *MatchingEngine) matchOrders(order Order, matchingOrders *[]Order) Match
 
         matchAmount := min(remainingAmount, matched.Amount-matched.FilledAmount)
         if matchAmount > 0 {
+            fill := Fill{
+                TradeID:      e.nextTradeID(),
+                TakerOrderID: order.ID,
+                MakerOrderID: matched.ID,
+                Taker:        order.Trader,
+                Maker:        matched.Trader,
+                Asset:        order.Asset,
+                Price:        matched.Price,
+                Amount:       matchAmount,
+                TakerIsBuy:   order.IsBuyOrder,
+            }
+            e.applyFees(&fill)
+            if err := e.settleFill(fill, order, *matched); err != nil {
+                settleErr = fmt.Errorf("settling fill against order %s: %w", matched.ID, err)
+                break
+            }
+            e.recordVolume(fill)
             remainingAmount -= matchAmount
             filledAmount += matchAmount
-            orderbookFilled += matchAmount
-            weightedSum += matchAmount * matched.Price
             matched.FilledAmount += matchAmount
+            fills = append(fills, fill)
+            e.emitTrade(fill)
+            e.emitFillStatus(*matched)
+            e.emitLevelChange(matched.Asset, matched.IsBuyOrder, matched.Price, -matchAmount)
 
             // Add log details
             // fmt.Printf("Matched %.2f units between %s and %s at price %.2f\n",
@@ -146,24 +312,49 @@ func (e *MatchingEngine) matchOrders(order Order, matchingOrders *[]Order) Match
 
     e.cleanupOrders(matchingOrders)
 
-    if filledAmount > 0 {
-        executedPrice = weightedSum / filledAmount
-    }
-
-    // Correct message format
-    message := fmt.Sprintf("Order %s: Initial: %.2f, Filled: %.2f (%.2f from orderbook, %.2f from LP)",
-        order.ID, order.InitialAmount, filledAmount, orderbookFilled, lpFilled)
-    
     return MatchResult{
+        OrderID:         order.ID,
         Success:         remainingAmount == 0,
         FilledAmount:    filledAmount,
         RemainingAmount: remainingAmount,
-        ExecutedPrice:   executedPrice,
-        Message:         message,
+        Fills:           fills,
+    }, settleErr
+}
+
+// summarize splits the fills of a result into orderbook and pool quantities
+// and average prices, blends them into ExecutedPrice and sets the message
+func (e *MatchingEngine) summarize(result *MatchResult, order Order) {
+    orderbookNotional, poolNotional := 0.0, 0.0
+    result.OrderbookFilled, result.PoolFilled = 0, 0
+    for _, fill := range result.Fills {
+        if fill.FromPool {
+            result.PoolFilled += fill.Amount
+            poolNotional += fill.Amount * fill.Price
+        } else {
+            result.OrderbookFilled += fill.Amount
+            orderbookNotional += fill.Amount * fill.Price
+        }
     }
+
+    result.OrderbookPrice, result.PoolPrice, result.ExecutedPrice = 0, 0, 0
+    if result.OrderbookFilled > 0 {
+        result.OrderbookPrice = orderbookNotional / result.OrderbookFilled
+    }
+    if result.PoolFilled > 0 {
+        result.PoolPrice = poolNotional / result.PoolFilled
+    }
+    if filled := result.OrderbookFilled + result.PoolFilled; filled > 0 {
+        result.ExecutedPrice = (orderbookNotional + poolNotional) / filled
+    }
+
+    if result.RemainingAmount > 0 {
+        result.Message = fmt.Sprintf("Order partially filled. Initial amount: %.2f, Filled: %.2f, Unfilled: %.2f",
+            order.InitialAmount, result.FilledAmount, result.RemainingAmount)
+        return
+    }
+    result.Message = e.formatMessage(order, result.OrderbookFilled, result.PoolFilled)
 }
 
-// 2. Change in formatMessage function
 func (e *MatchingEngine) formatMessage(order Order, orderbookFill float64, lpFill float64) string {
     return fmt.Sprintf("Order %s: Initial: %.2f, Filled: %.2f (%.2f from orderbook, %.2f from LP)",
         order.ID, order.InitialAmount, orderbookFill + lpFill, orderbookFill, lpFill)
@@ -174,11 +365,17 @@ func (e *MatchingEngine) cleanupOrders(orders *[]Order) {
     for _, order := range *orders {
         if order.FilledAmount < order.Amount {
             newOrders = append(newOrders, order)
+        } else {
+            e.releaseHold(order.ID)
         }
     }
     *orders = newOrders
 }
 
+func (e *MatchingEngine) nextTradeID() string {
+    return e.ids.NextTradeID()
+}
+
 func min(a, b float64) float64 {
     if a < b {
         return a
@@ -215,17 +412,113 @@ func (e *MatchingEngine) isPriceAcceptable(order Order, currentPrice float64) bo
     return currentPrice >= order.Price
 }
 
-func (e *MatchingEngine) tryLiquidityPool(order Order, amount float64) (float64, error) {
-    if e.liquidityPool == nil {
-        return 0, fmt.Errorf("no liquidity pool available")
+// tryLiquidityPool routes up to amount of the order across the liquidity
+// venues. Limit orders only take quotes at or better than their price; the
+// rest is left to rest in the book. It runs with e.mu held, for as long as
+// the route budget allows at most.
+func (e *MatchingEngine) tryLiquidityPool(order Order, amount float64) ([]liquiditypool.VenueFill, error) {
+    if e.replaying {
+        return e.replayedPoolResult(order)
+    }
+    fills, err := e.router.Route(liquiditypool.RouteRequest{
+        OrderID:    order.ID,
+        Asset:      order.Asset,
+        IsBuy:      order.IsBuyOrder,
+        Amount:     amount,
+        LimitPrice: worstAcceptablePrice(order),
+        Accept: func(price float64) bool {
+            return e.isPriceAcceptable(order, price)
+        },
+    })
+    if err != nil && !errors.Is(err, liquiditypool.ErrNoLiquidity) {
+        utils.LogError(fmt.Errorf("routing order %s: %w", order.ID, err))
     }
 
-    available, ok := e.liquidityPool.GetAvailableLiquidity(order.IsBuyOrder)
-    if !ok || available <= 0 {
-        return 0, fmt.Errorf("insufficient liquidity in pool")
+    // The venues have already traded, so a journal failure cannot undo them
+    outcome := poolResult{OrderID: order.ID, Fills: fills}
+    if err != nil {
+        outcome.Error = err.Error()
+    }
+    if journalErr := e.record(journal.RecordPoolResult, outcome); journalErr != nil {
+        utils.LogError(journalErr)
     }
+    for _, fill := range fills {
+        if !e.isPriceAcceptable(order, fill.Price) {
+            // The venue broke its quote; the trade stands, settled at the
+            // limit, but must be looked at
+            reason := fmt.Sprintf("filled at %.8f, beyond the limit %.8f the taker is charged", fill.Price, order.Price)
+            utils.LogError(fmt.Errorf("%s %s for order %s", fill.Venue, reason, order.ID))
+            e.router.Flag(liquiditypool.PendingTrade{Venue: fill.Venue, OrderID: order.ID, QuoteID: fill.QuoteID, Reason: reason})
+        }
+    }
+    return fills, err
+}
+
+// AddVenue adds a liquidity provider the pool fallback can route to
+func (e *MatchingEngine) AddVenue(venue liquiditypool.Venue) {
+    e.router.AddVenue(venue)
+}
+
+// Router returns the router splitting the pool fallback across venues
+func (e *MatchingEngine) Router() *liquiditypool.Router {
+    return e.router
+}
 
-    lpAmount := min(amount, available)
-    filled, err := e.liquidityPool.TradeWithPool(order.ID, lpAmount, order.IsBuyOrder)
-    return filled, err
+// UnreconciledPoolTrades returns venue executions that could not be
+// confirmed either way or that filled beyond the order's limit
+func (e *MatchingEngine) UnreconciledPoolTrades() []liquiditypool.PendingTrade {
+    return e.router.Unreconciled()
+}
+
+// SetPoolTimeout changes how long a single liquidity pool call may take
+func (e *MatchingEngine) SetPoolTimeout(timeout time.Duration) {
+    e.poolTimeout.Store(int64(timeout))
+    e.router.SetTimeout(timeout)
+}
+
+// SetRouteBudget changes how long routing one order to the venues may take
+// in all, see DefaultRouteBudget; 0 leaves it unbounded
+func (e *MatchingEngine) SetRouteBudget(budget time.Duration) {
+    e.router.SetBudget(budget)
+}
+
+// LiquidityPool returns the client prices are taken from, which is also the
+// default venue
+func (e *MatchingEngine) LiquidityPool() liquiditypool.LiquidityPoolClient {
+    return e.liquidityPool
+}
+
+func (e *MatchingEngine) poolContext() (context.Context, context.CancelFunc) {
+    return context.WithTimeout(context.Background(), time.Duration(e.poolTimeout.Load()))
+}
+
+// fetchPrice asks the pool for the current price and remembers it
+func (e *MatchingEngine) fetchPrice(asset string) (float64, error) {
+    ctx, cancel := e.poolContext()
+    defer cancel()
+
+    price, err := e.liquidityPool.GetCurrentPrice(ctx, asset)
+    if err != nil {
+        return 0, err
+    }
+    e.priceMu.Lock()
+    e.lastPrices[asset] = price
+    e.priceMu.Unlock()
+    return price, nil
+}
+
+// lastPrice returns the last price fetched successfully, or 0
+func (e *MatchingEngine) lastPrice(asset string) float64 {
+    e.priceMu.Lock()
+    defer e.priceMu.Unlock()
+    return e.lastPrices[asset]
+}
+
+// worstAcceptablePrice is the limit passed to the pool: the order's own price
+// for limit orders, 0 (no limit) for market orders
+func worstAcceptablePrice(order Order) float64 {
+    if order.Type == Limit {
+        return order.Price
+    }
+    return 0
 }
@@ -1,32 +1,52 @@
 package engine
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"matching-engine/internal/engine/liquiditypool"
 	"testing"
 	"time"
 )
 
 type MockLiquidityPool struct {
 	shouldFail bool
+	quotes     map[string]liquiditypool.Quote
 }
 
-func (m *MockLiquidityPool) GetAvailableLiquidity(isBuyOrder bool) (float64, bool) {
+func (m *MockLiquidityPool) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
 	if m.shouldFail {
-		return 0.0, false
+		return 0.0, &liquiditypool.Error{Op: "liquidity", Kind: liquiditypool.ErrUnavailable, Err: errors.New("mock failure")}
 	}
-	return 1000.0, true
+	return 1000.0, nil
 }
 
-func (m *MockLiquidityPool) TradeWithPool(orderId string, amount float64, isBuy bool) (float64, error) {
-	if m.shouldFail {
-		return 0.0, nil
+func (m *MockLiquidityPool) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (liquiditypool.Quote, error) {
+	if m.quotes == nil {
+		m.quotes = make(map[string]liquiditypool.Quote)
 	}
-	// We only fill half of the requested amount
-	return amount / 2, nil
+	quote := liquiditypool.Quote{ID: fmt.Sprintf("q-%d", len(m.quotes)+1), Asset: asset, IsBuy: isBuy, Amount: amount, Price: 100.0}
+	m.quotes[quote.ID] = quote
+	return quote, nil
+}
+
+func (m *MockLiquidityPool) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (liquiditypool.Execution, error) {
+	// We only fill half of the quoted amount
+	return liquiditypool.Execution{
+		OrderID:      orderId,
+		QuoteID:      quoteID,
+		Status:       liquiditypool.ExecutionFilled,
+		FilledAmount: m.quotes[quoteID].Amount / 2,
+		Price:        100.0,
+	}, nil
+}
+
+func (m *MockLiquidityPool) TradeStatus(ctx context.Context, orderId string) (liquiditypool.Execution, error) {
+	return liquiditypool.Execution{OrderID: orderId, Status: liquiditypool.ExecutionNotFound}, nil
 }
 
-func (m *MockLiquidityPool) GetCurrentPrice(asset string) float64 {
-	return 100.0 // Fixed price for testing
+func (m *MockLiquidityPool) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	return 100.0, nil // Fixed price for testing
 }
 
 func TestMarketOrderMatching(t *testing.T) {
@@ -268,7 +288,7 @@ func TestComplexOrderScenario(t *testing.T) {
 	}
 
 	// Get current price from mock liquidity pool
-	currentPrice := mockLP.GetCurrentPrice("BTC")
+	currentPrice, _ := mockLP.GetCurrentPrice(context.Background(), "BTC")
 
 	// Calculate weighted average price for verification
 	executedPrice := (5.0*100.0 + 7.0*102.0 + 1.5*currentPrice) / 13.5
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"matching-engine/internal/events"
+	"matching-engine/internal/journal"
+	"matching-engine/pkg/utils"
+)
+
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	ErrInvalidAmend  = errors.New("invalid amendment")
+	ErrWouldCross    = errors.New("amended price would cross the book")
+)
+
+// CancelOrder removes a resting order from the book and releases its hold
+func (e *MatchingEngine) CancelOrder(orderID string) (Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.begin(e.clock())()
+
+	return e.cancelOrder(orderID)
+}
+
+// cancelOrder must be called with e.mu held
+func (e *MatchingEngine) cancelOrder(orderID string) (Order, error) {
+	orders, i, ok := e.findResting(orderID)
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	if err := e.record(journal.RecordCancel, cancelCommand{OrderID: orderID}); err != nil {
+		return Order{}, err
+	}
+	order := (*orders)[i]
+	*orders = append((*orders)[:i], (*orders)[i+1:]...)
+	e.releaseHold(orderID)
+	e.emitRemoval(events.OrderCancelled, order)
+	return order, nil
+}
+
+// AmendOrder changes the price and total amount of a resting order. The
+// amount must stay above what has already filled, and the new price may not
+// cross the opposite side of the book. The order's hold is replaced by one
+// for the amended remainder.
+func (e *MatchingEngine) AmendOrder(orderID string, price float64, amount float64) (Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.begin(e.clock())()
+
+	orders, i, ok := e.findResting(orderID)
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	order := (*orders)[i]
+	if price <= 0 || amount <= order.FilledAmount {
+		return Order{}, fmt.Errorf("%w: price %.8f, amount %.8f with %.8f filled",
+			ErrInvalidAmend, price, amount, order.FilledAmount)
+	}
+	if e.crosses(order.IsBuyOrder, price) {
+		return Order{}, ErrWouldCross
+	}
+
+	amended := amend(order, price, amount)
+
+	// The order's own hold counts towards the amended one
+	e.releaseHold(orderID)
+	err := e.checkFunds(unfilled(amended), price)
+	if err == nil {
+		err = e.holdRestingOrder(unfilled(amended))
+	}
+	if err == nil {
+		if err = e.record(journal.RecordAmend, amendCommand{OrderID: orderID, Price: price, Amount: amount}); err != nil {
+			e.releaseHold(orderID)
+		}
+	}
+	if err != nil {
+		return Order{}, e.restoreHold(order, err)
+	}
+	(*orders)[i] = amended
+	e.emitAmendment(order, amended)
+	return amended, nil
+}
+
+// restoreHold puts back the hold of a resting order whose amendment failed
+// with cause. An order whose hold cannot be restored is cancelled rather
+// than left resting without funds behind it. It must be called with e.mu
+// held.
+func (e *MatchingEngine) restoreHold(order Order, cause error) error {
+	holdErr := e.holdRestingOrder(unfilled(order))
+	if holdErr == nil {
+		return cause
+	}
+	if _, err := e.cancelOrder(order.ID); err != nil {
+		utils.LogError(fmt.Errorf("cancelling order %s left without a hold: %w", order.ID, err))
+	}
+	return fmt.Errorf("%w; order cancelled as its hold could not be restored: %v", cause, holdErr)
+}
+
+// applyAmend replays a journaled amendment, whose checks already passed; it
+// must be called with e.mu held
+func (e *MatchingEngine) applyAmend(command amendCommand) error {
+	orders, i, ok := e.findResting(command.OrderID)
+	if !ok {
+		return ErrOrderNotFound
+	}
+	order := (*orders)[i]
+	amended := amend(order, command.Price, command.Amount)
+	e.releaseHold(amended.ID)
+	if err := e.holdRestingOrder(unfilled(amended)); err != nil {
+		return err
+	}
+	(*orders)[i] = amended
+	e.emitAmendment(order, amended)
+	return nil
+}
+
+// amend returns order with a new price and resting amount. The initial
+// amount moves by as much as the resting amount, so it still covers what
+// filled before the order rested.
+func amend(order Order, price float64, amount float64) Order {
+	order.InitialAmount += amount - order.Amount
+	order.Price = price
+	order.Amount = amount
+	return order
+}
+
+// unfilled is the part of a resting order its hold covers
+func unfilled(order Order) Order {
+	order.Amount -= order.FilledAmount
+	order.FilledAmount = 0
+	return order
+}
+
+// ExpireOrders removes every resting order whose expiration (unix seconds)
+// is at or before now and returns the removed orders
+func (e *MatchingEngine) ExpireOrders(now int64) []Order {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.begin(e.clock())()
+
+	return e.expireOrders(now)
+}
+
+// expireOrders must be called with e.mu held
+func (e *MatchingEngine) expireOrders(now int64) []Order {
+	due := false
+	for _, orders := range [][]Order{e.orderBook.BuyOrders, e.orderBook.SellOrders} {
+		for _, order := range orders {
+			if order.Expiration > 0 && order.Expiration <= now {
+				due = true
+			}
+		}
+	}
+	if !due {
+		return []Order{}
+	}
+	if err := e.record(journal.RecordExpiry, expiryCommand{Now: now}); err != nil {
+		// Expiry is retried on the next tick; a standby leaves it to the
+		// primary
+		if !refused(err) {
+			utils.LogError(err)
+		}
+		return []Order{}
+	}
+
+	expired := make([]Order, 0)
+	for _, orders := range [][]Order{e.orderBook.BuyOrders, e.orderBook.SellOrders} {
+		for _, order := range orders {
+			if order.Expiration > 0 && order.Expiration <= now {
+				expired = append(expired, order)
+			}
+		}
+	}
+	// One at a time, so each book delta sees the level it changed
+	for _, order := range expired {
+		orders, i, _ := e.findResting(order.ID)
+		*orders = append((*orders)[:i], (*orders)[i+1:]...)
+		e.releaseHold(order.ID)
+		e.emitRemoval(events.OrderExpired, order)
+	}
+	return expired
+}
+
+// findResting locates a resting order; it must be called with e.mu held
+func (e *MatchingEngine) findResting(orderID string) (*[]Order, int, bool) {
+	for _, orders := range []*[]Order{&e.orderBook.BuyOrders, &e.orderBook.SellOrders} {
+		for i, order := range *orders {
+			if order.ID == orderID {
+				return orders, i, true
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// crosses reports whether a resting order at price would match the best
+// order on the opposite side
+func (e *MatchingEngine) crosses(isBuy bool, price float64) bool {
+	opposite := e.orderBook.SellOrders
+	if !isBuy {
+		opposite = e.orderBook.BuyOrders
+	}
+	for _, order := range opposite {
+		if order.FilledAmount >= order.Amount {
+			continue
+		}
+		if (isBuy && order.Price <= price) || (!isBuy && order.Price >= price) {
+			return true
+		}
+	}
+	return false
+}
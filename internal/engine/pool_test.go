@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"context"
+	"matching-engine/internal/engine/liquiditypool"
+	"matching-engine/internal/engine/liquiditypool/amm"
+	"matching-engine/internal/engine/liquiditypool/mockpool"
+	"testing"
+	"time"
+)
+
+// hungLiquidityPool never answers until the caller gives up
+type hungLiquidityPool struct{}
+
+func (h *hungLiquidityPool) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func (h *hungLiquidityPool) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (liquiditypool.Quote, error) {
+	<-ctx.Done()
+	return liquiditypool.Quote{}, ctx.Err()
+}
+
+func (h *hungLiquidityPool) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (liquiditypool.Execution, error) {
+	<-ctx.Done()
+	return liquiditypool.Execution{}, ctx.Err()
+}
+
+func (h *hungLiquidityPool) TradeStatus(ctx context.Context, orderId string) (liquiditypool.Execution, error) {
+	<-ctx.Done()
+	return liquiditypool.Execution{}, ctx.Err()
+}
+
+func (h *hungLiquidityPool) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestHungPoolDoesNotBlockMatching(t *testing.T) {
+	engine := NewMatchingEngine(&hungLiquidityPool{})
+	engine.SetPoolTimeout(20 * time.Millisecond)
+
+	engine.orderBook.SellOrders = append(engine.orderBook.SellOrders, Order{
+		ID: "sell-1", Price: 100, Amount: 2, Type: Limit, StopLossPrice: 150,
+	})
+
+	start := time.Now()
+	result := engine.ProcessOrder(Order{ID: "buy-1", Price: 100, Amount: 5, Type: Limit, IsBuyOrder: true, StopLossPrice: 150})
+	elapsed := time.Since(start)
+
+	if elapsed > time.Second {
+		t.Errorf("Expected pool calls to be bounded, took %v", elapsed)
+	}
+	if result.FilledAmount != 2 || result.RemainingAmount != 3 {
+		t.Errorf("Expected book fill of 2 with 3 resting, got %+v", result)
+	}
+	if len(engine.orderBook.BuyOrders) != 1 {
+		t.Errorf("Expected the remainder to rest in the book")
+	}
+}
+
+func TestLostPoolResponseIsReconciled(t *testing.T) {
+	pool := mockpool.NewServer()
+	defer pool.Close()
+	pool.SetPrice("BTC", 100)
+	pool.SetLiquidity("BTC", 10)
+	pool.LoseResponses(1)
+
+	engine := NewMatchingEngine(liquiditypool.NewClient(pool.URL))
+	result := engine.ProcessOrder(Order{ID: "buy-1", Asset: "BTC", Amount: 4, Type: Market, IsBuyOrder: true})
+
+	if result.FilledAmount != 4 || len(result.Fills) != 1 || !result.Fills[0].FromPool {
+		t.Fatalf("Expected the lost execution to be booked, got %+v", result)
+	}
+	if execution, ok := pool.Execution("buy-1"); !ok || execution.FilledAmount != 4 {
+		t.Errorf("Expected one pool execution of 4, got %+v", execution)
+	}
+	if unreconciled := engine.UnreconciledPoolTrades(); len(unreconciled) != 0 {
+		t.Errorf("Expected no unreconciled trades, got %v", unreconciled)
+	}
+
+	// The retried order ID must not trade again
+	again := engine.ProcessOrder(Order{ID: "buy-1", Asset: "BTC", Amount: 4, Type: Market, IsBuyOrder: true})
+	if available, _ := liquiditypool.NewClient(pool.URL).GetAvailableLiquidity(context.Background(), "BTC", true, 0); available != 6 {
+		t.Errorf("Expected 6 left in the pool, got %f (%+v)", available, again)
+	}
+}
+
+func TestUnbookedPoolExecutionIsAskedAgain(t *testing.T) {
+	pool := mockpool.NewServer()
+	defer pool.Close()
+	pool.SetPrice("BTC", 100)
+	pool.SetLiquidity("BTC", 10)
+
+	// The pool books the lost execution only by the last status request.
+	// The transport retries an idempotent request once, so two are lost.
+	pool.LoseResponses(2)
+	pool.HideExecutions(2)
+	engine := NewMatchingEngine(liquiditypool.NewClient(pool.URL))
+	result := engine.ProcessOrder(Order{ID: "buy-1", Asset: "BTC", Amount: 4, Type: Market, IsBuyOrder: true})
+	if result.FilledAmount != 4 {
+		t.Fatalf("Expected the execution to be found on a later request, got %+v", result)
+	}
+
+	// One it never shows is left for review rather than taken as not traded
+	pool.LoseResponses(2)
+	pool.HideExecutions(3)
+	result = engine.ProcessOrder(Order{ID: "buy-2", Asset: "BTC", Amount: 2, Type: Market, IsBuyOrder: true})
+	if result.FilledAmount != 0 {
+		t.Errorf("Expected an unconfirmed execution not to be booked, got %+v", result)
+	}
+	if unreconciled := engine.UnreconciledPoolTrades(); len(unreconciled) != 1 || unreconciled[0].OrderID != "buy-2" {
+		t.Errorf("Expected buy-2 to await review, got %v", unreconciled)
+	}
+}
+
+func TestPoolPriceIsReportedSeparately(t *testing.T) {
+	pool := mockpool.NewServer()
+	defer pool.Close()
+	pool.SetPrice("BTC", 110)
+	pool.SetLiquidity("BTC", 10)
+
+	engine := NewMatchingEngine(liquiditypool.NewClient(pool.URL))
+	engine.orderBook.SellOrders = append(engine.orderBook.SellOrders, Order{
+		ID: "sell-1", Asset: "BTC", Price: 100, Amount: 2, Type: Limit,
+	})
+
+	result := engine.ProcessOrder(Order{ID: "buy-1", Asset: "BTC", Amount: 4, Type: Market, IsBuyOrder: true})
+
+	if result.OrderbookFilled != 2 || result.OrderbookPrice != 100 {
+		t.Errorf("Expected 2 from the book at 100, got %f at %f", result.OrderbookFilled, result.OrderbookPrice)
+	}
+	if result.PoolFilled != 2 || result.PoolPrice != 110 {
+		t.Errorf("Expected 2 from the pool at 110, got %f at %f", result.PoolFilled, result.PoolPrice)
+	}
+	if result.ExecutedPrice != 105 {
+		t.Errorf("Expected blended price 105, got %f", result.ExecutedPrice)
+	}
+	if result.Message != "Order buy-1: Initial: 4.00, Filled: 4.00 (2.00 from orderbook, 2.00 from LP)" {
+		t.Errorf("Unexpected message: %s", result.Message)
+	}
+}
+
+func TestLimitOrderSkipsPoolQuotesBeyondLimit(t *testing.T) {
+	// The mock quotes 100 whatever the limit
+	engine := NewMatchingEngine(&MockLiquidityPool{})
+
+	result := engine.ProcessOrder(Order{ID: "buy-1", Asset: "BTC", Price: 99, Amount: 4, Type: Limit, IsBuyOrder: true})
+
+	if result.PoolFilled != 0 || result.RemainingAmount != 4 {
+		t.Errorf("Expected no pool fill above the limit, got %+v", result)
+	}
+	if len(engine.orderBook.BuyOrders) != 1 || engine.orderBook.BuyOrders[0].Amount != 4 {
+		t.Errorf("Expected the whole order to rest, got %+v", engine.orderBook.BuyOrders)
+	}
+}
+
+func TestLimitOrderSweepsPoolUpToLimit(t *testing.T) {
+	pool := amm.New(0)
+	pool.AddPool("BTC", 100, 10000)
+	engine := NewMatchingEngine(pool)
+
+	result := engine.ProcessOrder(Order{ID: "buy-1", Asset: "BTC", Price: 101, Amount: 10, Type: Limit, IsBuyOrder: true})
+
+	// Average price reaches 101 after buying 100 - 10000/101 from the pool
+	if result.PoolFilled <= 0.99 || result.PoolFilled >= 1 || result.PoolPrice > 101 {
+		t.Errorf("Expected about 0.99 from the pool within 101, got %f at %f", result.PoolFilled, result.PoolPrice)
+	}
+	if len(engine.orderBook.BuyOrders) != 1 || engine.orderBook.BuyOrders[0].Amount != result.RemainingAmount {
+		t.Errorf("Expected the remaining %f to rest, got %+v", result.RemainingAmount, engine.orderBook.BuyOrders)
+	}
+}
@@ -0,0 +1,321 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"matching-engine/internal/account"
+	"matching-engine/internal/engine/liquiditypool"
+	"matching-engine/internal/engine/liquiditypool/amm"
+	"matching-engine/internal/fees"
+	"matching-engine/internal/hedge"
+	"matching-engine/internal/journal"
+	"matching-engine/internal/margin"
+	"matching-engine/pkg/utils"
+	"time"
+)
+
+// SetClock replaces the clock commands are stamped with. Fees, the ledger,
+// the insurance fund and the journal all read time through the engine.
+func (e *MatchingEngine) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clock = now
+}
+
+// SetIDGenerator replaces the generator of order and trade IDs
+func (e *MatchingEngine) SetIDGenerator(ids IDGenerator) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ids = ids
+}
+
+// now is the engine's clock. While a command runs it returns the time the
+// command started, so everything it stamps agrees with its journal record.
+func (e *MatchingEngine) now() time.Time {
+	if t := e.commandTime.Load(); t != 0 {
+		return time.Unix(0, t)
+	}
+	return e.clock()
+}
+
+// begin fixes the time of the command about to run and returns the func
+// that ends it, publishing the command's events; it must be called with e.mu
+// held
+func (e *MatchingEngine) begin(at time.Time) func() {
+	e.commandTime.Store(at.UnixNano())
+	return func() {
+		e.publishEvents()
+		e.commandSeq = 0
+		e.commandTime.Store(0)
+	}
+}
+
+// Replay applies the journal in dir from sequence number from onwards and
+// returns the last sequence number applied. Liquidity pool outcomes and
+// reference prices come from the journal, so no venue is called.
+//
+// Replay only rebuilds what the journal records: configuration such as the
+// default fee schedule, venues and margin settings must match the recorded
+// session. Instrument fee schedules set through SetFeeSchedule are replayed. A
+// hedger set before replay gets back the house position of the pool fills and
+// hedge trades replayed; it should only be run once replay is done. The
+// reserves of in-process AMM venues follow their journaled executions.
+func (e *MatchingEngine) Replay(dir string, from uint64) (uint64, error) {
+	replayer := e.NewReplayer()
+	if err := journal.Read(dir, from, replayer.Apply); err != nil {
+		return replayer.Last(), err
+	}
+	return replayer.Last(), replayer.Flush()
+}
+
+// Replayer applies journal records one at a time, as a standby does with the
+// records streamed from its primary
+type Replayer struct {
+	e *MatchingEngine
+	// pending is a new order held back until the next record, which
+	// carries its pool result if it had one
+	pending *journal.Record
+	last    uint64
+}
+
+// NewReplayer returns a Replayer applying records to e
+func (e *MatchingEngine) NewReplayer() *Replayer {
+	return &Replayer{e: e}
+}
+
+// Apply applies a record, or holds it back if it is a new order
+func (r *Replayer) Apply(record journal.Record) error {
+	r.last = record.Seq
+	if record.Type == journal.RecordPoolResult && r.pending != nil {
+		var outcome poolResult
+		if err := json.Unmarshal(record.Payload, &outcome); err != nil {
+			return fmt.Errorf("record %d: %w", record.Seq, err)
+		}
+		return r.flush(&outcome)
+	}
+	if err := r.flush(nil); err != nil {
+		return err
+	}
+	if record.Type == journal.RecordNewOrder {
+		r.pending = &record
+		return nil
+	}
+	return r.e.applyRecord(record, nil)
+}
+
+// Flush applies a held back new order without a pool result
+func (r *Replayer) Flush() error {
+	return r.flush(nil)
+}
+
+// Pending reports whether a new order is held back waiting for its pool
+// result
+func (r *Replayer) Pending() bool {
+	return r.pending != nil
+}
+
+// Last returns the sequence number of the last record passed to Apply
+func (r *Replayer) Last() uint64 {
+	return r.last
+}
+
+func (r *Replayer) flush(outcome *poolResult) error {
+	if r.pending == nil {
+		return nil
+	}
+	record := *r.pending
+	r.pending = nil
+	return r.e.applyRecord(record, outcome)
+}
+
+// applyRecord replays one record with the engine in replay mode
+func (e *MatchingEngine) applyRecord(record journal.Record, outcome *poolResult) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.replaying = true
+	e.replayPool = outcome
+	defer func() {
+		e.replaying = false
+		e.replayPool = nil
+	}()
+	return e.apply(record)
+}
+
+// apply replays one record; it must be called with e.mu held
+func (e *MatchingEngine) apply(record journal.Record) error {
+	defer e.begin(record.Time)()
+	e.commandSeq = record.Seq
+
+	var err error
+	switch record.Type {
+	case journal.RecordNewOrder:
+		var command newOrderCommand
+		if err = json.Unmarshal(record.Payload, &command); err == nil {
+			e.ids.Observe(command.ID)
+			if command.ReferencePrice > 0 {
+				e.priceMu.Lock()
+				e.lastPrices[command.Asset] = command.ReferencePrice
+				e.priceMu.Unlock()
+			}
+			e.execute(command.Order, command.ReferencePrice)
+		}
+	case journal.RecordRejection:
+		var command rejectionCommand
+		if err = json.Unmarshal(record.Payload, &command); err == nil {
+			e.ids.Observe(command.ID)
+			e.emitRejection(command.Order, command.Reason)
+		}
+	case journal.RecordCancel:
+		var command cancelCommand
+		if err = json.Unmarshal(record.Payload, &command); err == nil {
+			_, err = e.cancelOrder(command.OrderID)
+		}
+	case journal.RecordAmend:
+		var command amendCommand
+		if err = json.Unmarshal(record.Payload, &command); err == nil {
+			err = e.applyAmend(command)
+		}
+	case journal.RecordExpiry:
+		var command expiryCommand
+		if err = json.Unmarshal(record.Payload, &command); err == nil {
+			e.expireOrders(command.Now)
+		}
+	case journal.RecordDeposit, journal.RecordWithdrawal:
+		var command transferCommand
+		if err = json.Unmarshal(record.Payload, &command); err == nil && e.accounts != nil {
+			if record.Type == journal.RecordDeposit {
+				err = e.deposit(command.Trader, command.Asset, command.Amount)
+			} else {
+				err = e.accounts.Withdraw(command.Trader, command.Asset, command.Amount)
+			}
+		}
+	case journal.RecordHedge:
+		var trade hedge.Trade
+		if err = json.Unmarshal(record.Payload, &trade); err == nil && e.hedger != nil {
+			e.hedger.ApplyTrade(trade)
+		}
+	case journal.RecordLiquidation:
+		var command liquidationCommand
+		if err = json.Unmarshal(record.Payload, &command); err == nil {
+			if err = e.checkLiquidation(command.Liquidation); err == nil {
+				e.liquidate(command)
+			}
+		}
+	case journal.RecordFeeSchedule:
+		var schedule fees.Schedule
+		if err = json.Unmarshal(record.Payload, &schedule); err == nil {
+			e.fees.SetSchedule(schedule)
+		}
+	case journal.RecordPoolResult:
+		// Only reached when the order it belongs to is not in the journal,
+		// e.g. when replay starts between the two
+		utils.LogError(fmt.Errorf("record %d: pool result without its order", record.Seq))
+	default:
+		err = fmt.Errorf("unknown record type %s", record.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("replaying record %d (%s): %w", record.Seq, record.Type, err)
+	}
+	return nil
+}
+
+// replayedPoolResult stands in for routing while replaying
+func (e *MatchingEngine) replayedPoolResult(order Order) ([]liquiditypool.VenueFill, error) {
+	outcome := e.replayPool
+	e.replayPool = nil
+	if outcome == nil || outcome.OrderID != order.ID {
+		// The engine stopped before the result was journaled; whatever the
+		// venues did is left to reconciliation
+		return nil, fmt.Errorf("no journaled pool result for order %s", order.ID)
+	}
+	e.applyLocalFills(order, outcome.Fills)
+	if outcome.Error != "" {
+		return outcome.Fills, errors.New(outcome.Error)
+	}
+	return outcome.Fills, nil
+}
+
+// localPools returns the venues whose state lives in this process, by name
+func (e *MatchingEngine) localPools() map[string]*amm.AMM {
+	pools := make(map[string]*amm.AMM)
+	for _, venue := range e.router.Venues() {
+		if pool, ok := venue.Client.(*amm.AMM); ok {
+			pools[venue.Name] = pool
+		}
+	}
+	return pools
+}
+
+// applyLocalFills moves in-process venues as their replayed executions did
+func (e *MatchingEngine) applyLocalFills(order Order, fills []liquiditypool.VenueFill) {
+	pools := e.localPools()
+	for _, fill := range fills {
+		pool, ok := pools[fill.Venue]
+		if !ok {
+			continue
+		}
+		if err := pool.ApplyExecution(order.Asset, order.IsBuyOrder, fill.Execution); err != nil {
+			utils.LogError(fmt.Errorf("replaying %s fill for order %s: %w", fill.Venue, order.ID, err))
+		}
+	}
+}
+
+// State is everything replay has to reproduce
+type State struct {
+	BuyOrders  []Order                               `json:"buy_orders"`
+	SellOrders []Order                               `json:"sell_orders"`
+	Balances   map[string]map[string]account.Balance `json:"balances"`
+	Holds      []account.Hold                        `json:"holds"`
+	Positions  []margin.Position                     `json:"positions"`
+	Insurance  map[string]float64                    `json:"insurance"`
+	// House is the hedger's net exposure by asset
+	House map[string]float64 `json:"house"`
+}
+
+// State returns a copy of the book, balances, holds and positions and the
+// house exposure
+func (e *MatchingEngine) State() State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := State{
+		BuyOrders:  append([]Order{}, e.orderBook.BuyOrders...),
+		SellOrders: append([]Order{}, e.orderBook.SellOrders...),
+		Balances:   make(map[string]map[string]account.Balance),
+		Holds:      []account.Hold{},
+		Positions:  []margin.Position{},
+		Insurance:  e.insurance.Balances(),
+		House:      make(map[string]float64),
+	}
+	if e.hedger != nil {
+		for _, p := range e.hedger.State().Positions {
+			state.House[p.Asset] = p.Size
+		}
+	}
+	if e.accounts != nil {
+		for _, trader := range e.accounts.Traders() {
+			state.Balances[trader] = e.accounts.Balances(trader)
+		}
+		state.Holds = e.accounts.Holds()
+	}
+	if e.margin != nil {
+		state.Positions = e.margin.Positions().All()
+	}
+	return state
+}
+
+// StateHash returns a SHA-256 of State, equal for engines that processed the
+// same commands
+func (e *MatchingEngine) StateHash() string {
+	encoded, err := json.Marshal(e.State())
+	if err != nil {
+		// State holds nothing json cannot encode
+		panic(err)
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
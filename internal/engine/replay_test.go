@@ -0,0 +1,317 @@
+package engine
+
+import (
+	"context"
+	"matching-engine/internal/account"
+	"matching-engine/internal/engine/liquiditypool"
+	"matching-engine/internal/engine/liquiditypool/amm"
+	"matching-engine/internal/fees"
+	"matching-engine/internal/hedge"
+	"matching-engine/internal/journal"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/margin"
+	"os"
+	"testing"
+	"time"
+)
+
+// offlinePool fails the test if replay calls it
+type offlinePool struct {
+	t *testing.T
+}
+
+func (p offlinePool) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
+	p.t.Error("Replay asked the pool for liquidity")
+	return 0, liquiditypool.ErrUnavailable
+}
+
+func (p offlinePool) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (liquiditypool.Quote, error) {
+	p.t.Error("Replay asked the pool for a quote")
+	return liquiditypool.Quote{}, liquiditypool.ErrUnavailable
+}
+
+func (p offlinePool) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (liquiditypool.Execution, error) {
+	p.t.Error("Replay traded with the pool")
+	return liquiditypool.Execution{}, liquiditypool.ErrUnavailable
+}
+
+func (p offlinePool) TradeStatus(ctx context.Context, orderId string) (liquiditypool.Execution, error) {
+	p.t.Error("Replay asked the pool for a trade")
+	return liquiditypool.Execution{}, liquiditypool.ErrUnavailable
+}
+
+func (p offlinePool) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	p.t.Error("Replay asked the pool for a price")
+	return 0, liquiditypool.ErrUnavailable
+}
+
+// newMarginEngine wires accounts, a ledger, margin and tiered fees the same
+// way for the recorded and the replayed engine
+func newMarginEngine(t *testing.T, lp liquiditypool.LiquidityPoolClient) *MatchingEngine {
+	engine := NewMatchingEngine(lp)
+	accounts := account.NewManager()
+	accounts.SetLedger(ledger.New())
+	engine.SetAccountManager(accounts, "USD")
+	manager := margin.NewManager(accounts, engine, margin.Config{
+		QuoteAsset: "USD",
+		Haircuts:   map[string]float64{"USD": 0},
+	})
+	if err := engine.SetMarginManager(manager); err != nil {
+		t.Fatal(err)
+	}
+	engine.SetFeeCalculator(fees.NewCalculator(fees.Schedule{
+		Tiers: []fees.Tier{
+			{MakerRate: 0.0002, TakerRate: 0.0005},
+			{MinVolume: 500, MakerRate: 0.0001, TakerRate: 0.0003},
+		},
+		PoolRate: 0.001,
+	}))
+	return engine
+}
+
+func TestReplayRebuildsState(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := journal.Open(dir, journal.Options{Sync: journal.SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := amm.New(0.003)
+	pool.AddPool("BTC", 100, 10000)
+	recorded := newMarginEngine(t, pool)
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	recorded.SetClock(func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	})
+	recorded.SetJournal(wal)
+
+	for _, trader := range []string{"alice", "bob", "carol"} {
+		if err := recorded.Deposit(trader, "USD", 10000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorded.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 101, Amount: 5, Type: Limit, Leverage: 5, MarginType: Isolated})
+	recorded.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Amount: 2, Type: Market, IsBuyOrder: true, Leverage: 10, MarginType: Cross})
+	recorded.ProcessOrder(Order{Trader: "carol", Asset: "BTC", Price: 100.5, Amount: 4, Type: Limit, IsBuyOrder: true, Leverage: 5, MarginType: Cross})
+	rest := recorded.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Price: 98, Amount: 3, Type: Limit, IsBuyOrder: true, Leverage: 10, MarginType: Cross, Expiration: 100})
+	expiring := recorded.ProcessOrder(Order{Trader: "carol", Asset: "BTC", Price: 97, Amount: 1, Type: Limit, IsBuyOrder: true, Leverage: 5, MarginType: Cross, Expiration: 50})
+	if _, err := recorded.AmendOrder(rest.OrderID, 98.5, 4); err != nil {
+		t.Fatalf("Amend failed: %v", err)
+	}
+	if expired := recorded.ExpireOrders(60); len(expired) != 1 || expired[0].ID != expiring.OrderID {
+		t.Fatalf("Expected %s to expire, got %+v", expiring.OrderID, expired)
+	}
+	if err := recorded.Withdraw("carol", "USD", 100); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	if reserves, _ := pool.Reserves("BTC"); reserves.Base >= 100 {
+		t.Fatalf("Expected the session to trade with the pool, got reserves %+v", reserves)
+	}
+	state := recorded.State()
+	if len(state.Positions) == 0 || len(state.BuyOrders) == 0 || len(state.SellOrders) == 0 {
+		t.Fatalf("Expected the session to leave positions and orders on both sides, got %+v", state)
+	}
+
+	replayed := newMarginEngine(t, offlinePool{t})
+	last, err := replayed.Replay(dir, 1)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if last != wal.NextSeq()-1 {
+		t.Errorf("Expected replay to reach record %d, got %d", wal.NextSeq()-1, last)
+	}
+	if replayed.StateHash() != recorded.StateHash() {
+		t.Fatalf("Replayed state differs:\nrecorded %+v\nreplayed %+v", recorded.State(), replayed.State())
+	}
+
+	// Ledger entries carry the recorded command times
+	recordedEntries := recorded.Accounts().Ledger().Entries(0)
+	replayedEntries := replayed.Accounts().Ledger().Entries(0)
+	if len(replayedEntries) != len(recordedEntries) {
+		t.Fatalf("Expected %d ledger entries, got %d", len(recordedEntries), len(replayedEntries))
+	}
+	for i := range recordedEntries {
+		if !replayedEntries[i].Time.Equal(recordedEntries[i].Time) {
+			t.Errorf("Entry %d: expected time %v, got %v", i, recordedEntries[i].Time, replayedEntries[i].Time)
+		}
+	}
+
+	// New IDs carry on after the journaled ones
+	if id := replayed.ids.NextOrderID(); id != "ord-6" {
+		t.Errorf("Expected the next order to be ord-6, got %s", id)
+	}
+}
+
+func TestRecoverFromSnapshotAndJournalTail(t *testing.T) {
+	journalDir, snapshotDir := t.TempDir(), t.TempDir()
+	// One record per segment, so segments covered by the snapshot can go
+	wal, err := journal.Open(journalDir, journal.Options{Sync: journal.SyncNone, SegmentSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := amm.New(0.003)
+	pool.AddPool("BTC", 100, 10000)
+	pool.AddPool("ETH", 100, 200000)
+	recorded := newMarginEngine(t, pool)
+	recorded.SetJournal(wal)
+
+	for _, trader := range []string{"alice", "bob"} {
+		recorded.Deposit(trader, "USD", 100000)
+	}
+	recorded.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 101, Amount: 5, Type: Limit, Leverage: 5})
+	recorded.ProcessOrder(Order{Trader: "bob", Asset: "ETH", Price: 2030, Amount: 2, Type: Limit, Leverage: 5})
+	recorded.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Price: 99, Amount: 1, Type: Limit, IsBuyOrder: true, Leverage: 5})
+
+	seq, err := recorded.SaveSnapshot(snapshotDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != wal.NextSeq()-1 {
+		t.Fatalf("Expected the snapshot to cover record %d, got %d", wal.NextSeq()-1, seq)
+	}
+
+	recorded.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Amount: 2, Type: Market, IsBuyOrder: true, Leverage: 5})
+	recorded.ProcessOrder(Order{Trader: "alice", Asset: "ETH", Price: 2010, Amount: 1, Type: Limit, IsBuyOrder: true, Leverage: 5})
+	recorded.CancelOrder("ord-3")
+	wal.Close()
+
+	segments, _ := journal.Segments(journalDir)
+	for _, segment := range segments {
+		if segment.FirstSeq <= seq {
+			os.Remove(segment.Path)
+		}
+	}
+
+	recovered := newMarginEngine(t, offlinePool{t})
+	last, err := recovered.Recover(snapshotDir, journalDir)
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if last != wal.NextSeq()-1 {
+		t.Errorf("Expected recovery up to record %d, got %d", wal.NextSeq()-1, last)
+	}
+	if recovered.StateHash() != recorded.StateHash() {
+		t.Fatalf("Recovered state differs:\nrecorded %+v\nrecovered %+v", recorded.State(), recovered.State())
+	}
+	if a, b := recovered.FeeCalculator().Volume("alice"), recorded.FeeCalculator().Volume("alice"); a != b {
+		t.Errorf("Expected fee volume %f, got %f", b, a)
+	}
+}
+
+func TestRecoveryRebuildsHouseAndPoolState(t *testing.T) {
+	journalDir, snapshotDir := t.TempDir(), t.TempDir()
+	wal, err := journal.Open(journalDir, journal.Options{Sync: journal.SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPool := func() *amm.AMM {
+		pool := amm.New(0.003)
+		pool.AddPool("BTC", 100, 10000)
+		return pool
+	}
+	pool := newPool()
+	recorded := newMarginEngine(t, pool)
+	recorded.SetJournal(wal)
+	venue := amm.New(0)
+	venue.AddPool("BTC", 1000, 100000)
+	hedger := hedge.NewHedger(venue, recorded, hedge.Config{DefaultThreshold: 0.5})
+	recorded.SetHedger(hedger)
+
+	recorded.Deposit("alice", "USD", 100000)
+	recorded.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Amount: 2, Type: Market, IsBuyOrder: true, Leverage: 5})
+	if trades := hedger.Rebalance(); len(trades) != 1 {
+		t.Fatalf("Expected one hedge trade, got %+v", trades)
+	}
+	if _, err := recorded.SaveSnapshot(snapshotDir); err != nil {
+		t.Fatal(err)
+	}
+	recorded.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Amount: 1, Type: Market, IsBuyOrder: true, Leverage: 5})
+	wal.Close()
+	if hedger.Exposure("BTC") >= 0 {
+		t.Fatalf("Expected the house to be short after the last fill, got %f", hedger.Exposure("BTC"))
+	}
+
+	rebuilt := map[string]func(*MatchingEngine) error{
+		"replay": func(e *MatchingEngine) error {
+			_, err := e.Replay(journalDir, 1)
+			return err
+		},
+		"recover": func(e *MatchingEngine) error {
+			_, err := e.Recover(snapshotDir, journalDir)
+			return err
+		},
+	}
+	for name, rebuild := range rebuilt {
+		replayedPool := newPool()
+		replayed := newMarginEngine(t, replayedPool)
+		replayedHedger := hedge.NewHedger(offlinePool{t}, replayed, hedge.Config{DefaultThreshold: 0.5})
+		replayed.SetHedger(replayedHedger)
+		if err := rebuild(replayed); err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+
+		if replayed.StateHash() != recorded.StateHash() {
+			t.Errorf("%s: state differs:\nrecorded %+v\nreplayed %+v", name, recorded.State(), replayed.State())
+		}
+		got, _ := replayedPool.Reserves("BTC")
+		want, _ := pool.Reserves("BTC")
+		if got != want {
+			t.Errorf("%s: expected pool reserves %+v, got %+v", name, want, got)
+		}
+		if trades := replayedHedger.Report().Trades; len(trades) != 1 || trades[0].ID != "hedge-1" {
+			t.Errorf("%s: expected the hedge trade back, got %+v", name, trades)
+		}
+		if got, want := replayedHedger.Report().RealizedPnL, hedger.Report().RealizedPnL; got != want {
+			t.Errorf("%s: expected hedge P&L %f, got %f", name, want, got)
+		}
+		if state := replayedHedger.State(); state.Seq != 1 {
+			t.Errorf("%s: expected hedge IDs to carry on after hedge-1, got seq %d", name, state.Seq)
+		}
+	}
+}
+
+func TestFeeSchedulesSurviveRecovery(t *testing.T) {
+	journalDir, snapshotDir := t.TempDir(), t.TempDir()
+	wal, err := journal.Open(journalDir, journal.Options{Sync: journal.SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := newMarginEngine(t, &MockLiquidityPool{shouldFail: true})
+	recorded.SetJournal(wal)
+	for _, trader := range []string{"alice", "bob"} {
+		recorded.Deposit(trader, "USD", 100000)
+	}
+
+	btc := fees.Schedule{Instrument: "BTC", Tiers: []fees.Tier{{MakerRate: -0.0001, TakerRate: 0.001}}}
+	if err := recorded.SetFeeSchedule(btc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorded.SaveSnapshot(snapshotDir); err != nil {
+		t.Fatal(err)
+	}
+	eth := fees.Schedule{Instrument: "ETH", Tiers: []fees.Tier{{TakerRate: 0.002}}}
+	if err := recorded.SetFeeSchedule(eth); err != nil {
+		t.Fatal(err)
+	}
+	recorded.ProcessOrder(Order{Trader: "bob", Asset: "ETH", Price: 2000, Amount: 1, Type: Limit, Leverage: 5})
+	recorded.ProcessOrder(Order{Trader: "alice", Asset: "ETH", Price: 2000, Amount: 1, Type: Limit, IsBuyOrder: true, Leverage: 5})
+	wal.Close()
+
+	recovered := newMarginEngine(t, offlinePool{t})
+	if _, err := recovered.Recover(snapshotDir, journalDir); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	for _, instrument := range []string{"BTC", "ETH"} {
+		if a, b := recovered.FeeCalculator().Schedule(instrument), recorded.FeeCalculator().Schedule(instrument); a.Tiers[0] != b.Tiers[0] {
+			t.Errorf("Expected the %s schedule %+v, got %+v", instrument, b, a)
+		}
+	}
+	if recovered.StateHash() != recorded.StateHash() {
+		t.Fatalf("Recovered state differs:\nrecorded %+v\nrecovered %+v", recorded.State(), recovered.State())
+	}
+}
@@ -0,0 +1,269 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"matching-engine/internal/account"
+	"matching-engine/internal/engine/liquiditypool"
+	"matching-engine/internal/journal"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/margin"
+	"matching-engine/pkg/utils"
+)
+
+const (
+	// PoolAccount is the counterparty account of liquidity pool fills
+	PoolAccount = "liquidity-pool"
+	// FeeAccount collects trading fees and pays maker rebates
+	FeeAccount = "fees"
+	// InsuranceAccount mirrors the insurance fund in the books and ledger
+	InsuranceAccount = "insurance-fund"
+)
+
+var errAccountsDisabled = errors.New("accounts are not enabled")
+
+// SetAccountManager enables balance checks, holds and settlement. Orders are
+// priced in quoteAsset and their Asset is the base asset being traded.
+func (e *MatchingEngine) SetAccountManager(accounts *account.Manager, quoteAsset string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	accounts.AddSystemAccount(PoolAccount)
+	accounts.AddSystemAccount(FeeAccount)
+	accounts.AddSystemAccount(InsuranceAccount)
+	if l := accounts.Ledger(); l != nil {
+		l.SetClock(e.now)
+	}
+	e.insurance.SetBooks(InsuranceAccount, func(entryType ledger.EntryType, from string, to string, asset string, amount float64, reference string) error {
+		if from == ledger.ExternalAccount {
+			return accounts.Deposit(to, asset, amount)
+		}
+		return accounts.Settle(ledger.Reference{TradeID: reference}, account.Transfer{From: from, To: to, Asset: asset, Amount: amount, Type: entryType})
+	})
+	e.accounts = accounts
+	e.quoteAsset = quoteAsset
+}
+
+// Accounts returns the account manager, or nil if balances are not enforced
+func (e *MatchingEngine) Accounts() *account.Manager {
+	return e.accounts
+}
+
+// Deposit credits a trader's available balance. Unlike calling the account
+// manager directly, the deposit is journaled and so survives a replay. A
+// deposit to InsuranceAccount tops up the insurance fund.
+func (e *MatchingEngine) Deposit(trader string, asset string, amount float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.begin(e.clock())()
+
+	if e.accounts == nil {
+		return errAccountsDisabled
+	}
+	if amount <= 0 {
+		return account.ErrInvalidAmount
+	}
+	if err := e.record(journal.RecordDeposit, transferCommand{Trader: trader, Asset: asset, Amount: amount}); err != nil {
+		return err
+	}
+	return e.deposit(trader, asset, amount)
+}
+
+// deposit credits a journaled deposit; it must be called with e.mu held
+func (e *MatchingEngine) deposit(trader string, asset string, amount float64) error {
+	if trader == InsuranceAccount {
+		return e.insurance.Deposit(asset, amount, fmt.Sprintf("deposit-%d", e.commandSeq))
+	}
+	return e.accounts.Deposit(trader, asset, amount)
+}
+
+// Withdraw debits a trader's available balance through the journal
+func (e *MatchingEngine) Withdraw(trader string, asset string, amount float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.begin(e.clock())()
+
+	if e.accounts == nil {
+		return errAccountsDisabled
+	}
+	if amount <= 0 {
+		return account.ErrInvalidAmount
+	}
+	if trader == InsuranceAccount {
+		return errors.New("the insurance fund only pays out liquidation shortfalls")
+	}
+	if e.accounts.Balance(trader, asset).Available < amount {
+		return account.ErrInsufficientFunds
+	}
+	if err := e.record(journal.RecordWithdrawal, transferCommand{Trader: trader, Asset: asset, Amount: amount}); err != nil {
+		return err
+	}
+	return e.accounts.Withdraw(trader, asset, amount)
+}
+
+// checkFunds verifies the trader can pay for the full order. Market buys are
+// estimated at the current pool price.
+func (e *MatchingEngine) checkFunds(order Order, currentPrice float64) error {
+	if e.accounts == nil {
+		return nil
+	}
+	if order.Trader == "" {
+		return errors.New("order has no trader")
+	}
+	if order.Type == Market && currentPrice <= 0 {
+		return errors.New("no reference price for market order")
+	}
+	if e.margin != nil {
+		return e.checkMargin(order, currentPrice)
+	}
+	if !order.IsBuyOrder {
+		return e.accounts.CheckAvailable(order.Trader, order.Asset, order.Amount)
+	}
+
+	price := order.Price
+	if order.Type == Market {
+		price = currentPrice
+	}
+	return e.accounts.CheckAvailable(order.Trader, e.quoteAsset, order.Amount*price)
+}
+
+// holdRestingOrder reserves the funds a resting order may be filled
+// against. An order whose hold cannot be placed must not rest.
+func (e *MatchingEngine) holdRestingOrder(order Order) error {
+	if e.accounts == nil {
+		return nil
+	}
+
+	var err error
+	if e.margin != nil {
+		err = e.accounts.PlaceHold(order.ID, order.Trader, e.quoteAsset, margin.InitialMargin(order.Amount*order.Price, order.Leverage))
+	} else if order.IsBuyOrder {
+		err = e.accounts.PlaceHold(order.ID, order.Trader, e.quoteAsset, order.Amount*order.Price)
+	} else {
+		err = e.accounts.PlaceHold(order.ID, order.Trader, order.Asset, order.Amount)
+	}
+	if err != nil {
+		return fmt.Errorf("placing hold for order %s: %w", order.ID, err)
+	}
+	return nil
+}
+
+// releaseHold returns what is left of an order's hold to its trader
+func (e *MatchingEngine) releaseHold(orderID string) {
+	if e.accounts == nil {
+		return
+	}
+	e.accounts.ReleaseHold(orderID)
+}
+
+// addPoolFill settles and records the part of an order filled by a venue.
+// A venue fill the taker cannot pay for is left with the pool account, which
+// did trade it, and is flagged for review; it is not reported to the taker
+// but no longer remains on the order, so it cannot be filled again. A fill
+// beyond a limit order's price is settled at the limit: the house takes it
+// at the venue's price and bears the difference. It reports whether the
+// fill was settled.
+func (e *MatchingEngine) addPoolFill(result *MatchResult, order Order, venueFill liquiditypool.VenueFill) bool {
+	if venueFill.FilledAmount <= 0 {
+		return true
+	}
+	fill := Fill{
+		TradeID:      e.nextTradeID(),
+		TakerOrderID: order.ID,
+		Taker:        order.Trader,
+		Maker:        PoolAccount,
+		Asset:        order.Asset,
+		Price:        venueFill.Price,
+		Amount:       venueFill.FilledAmount,
+		TakerIsBuy:   order.IsBuyOrder,
+		FromPool:     true,
+		Venue:        venueFill.Venue,
+	}
+	e.recordExposure(fill)
+	if !e.isPriceAcceptable(order, fill.Price) {
+		fill.Price = order.Price
+	}
+	e.applyFees(&fill)
+	if err := e.settleFill(fill, order, Order{}); err != nil {
+		utils.LogError(fmt.Errorf("settling %s fill of %.8f %s for order %s, left with %s: %w",
+			fill.Venue, fill.Amount, fill.Asset, order.ID, PoolAccount, err))
+		if !e.replaying {
+			reason := fmt.Sprintf("executed %.8f but could not settle it with the taker: %v", fill.Amount, err)
+			e.router.Flag(liquiditypool.PendingTrade{Venue: fill.Venue, OrderID: order.ID, QuoteID: venueFill.QuoteID, Reason: reason})
+		}
+		result.RemainingAmount -= fill.Amount
+		return false
+	}
+	e.recordVolume(fill)
+	e.emitTrade(fill)
+	result.FilledAmount += fill.Amount
+	result.RemainingAmount -= fill.Amount
+	result.Fills = append(result.Fills, fill)
+	return true
+}
+
+// settleFill exchanges base and quote between the two sides of a fill. The
+// maker pays out of its hold, the taker out of its available balance. Each
+// side's fee is deducted from what it receives and credited to FeeAccount.
+// Either every transfer is made or, with an error, none is. With margin
+// enabled fills move positions instead, see settleMarginFill.
+func (e *MatchingEngine) settleFill(fill Fill, taker Order, maker Order) error {
+	if e.accounts == nil {
+		return nil
+	}
+	if e.margin != nil {
+		return e.settleMarginFill(fill, taker, maker)
+	}
+
+	makerHold := ""
+	if !fill.FromPool {
+		if _, ok := e.accounts.HoldFor(fill.MakerOrderID); ok {
+			makerHold = fill.MakerOrderID
+		}
+	}
+
+	buyer, seller := fill.Maker, fill.Taker
+	buyerHold, sellerHold := makerHold, ""
+	buyerFee, sellerFee := fill.MakerFee, fill.TakerFee
+	if fill.TakerIsBuy {
+		buyer, seller = fill.Taker, fill.Maker
+		buyerHold, sellerHold = "", makerHold
+		buyerFee, sellerFee = fill.TakerFee, fill.MakerFee
+	}
+
+	transfers := make([]account.Transfer, 0, 4)
+	transfers = append(transfers, legTransfers(buyer, seller, e.quoteAsset, fill.Amount*fill.Price, sellerFee, buyerHold)...)
+	transfers = append(transfers, legTransfers(seller, buyer, fill.Asset, fill.Amount, buyerFee, sellerHold)...)
+
+	return e.accounts.Settle(fillReference(fill), transfers...)
+}
+
+// legTransfers pays amount of asset from one side to the other, diverting
+// the receiver's fee to FeeAccount or topping up a rebate from it
+func legTransfers(from string, to string, asset string, amount float64, fee float64, hold string) []account.Transfer {
+	transfers := make([]account.Transfer, 0, 2)
+	if amount <= 0 {
+		return transfers
+	}
+
+	net := amount
+	if fee > 0 {
+		net = amount - fee
+		transfers = append(transfers, account.Transfer{From: from, To: FeeAccount, Asset: asset, Amount: fee, HoldOrderID: hold, Type: ledger.EntryFee})
+	} else if fee < 0 {
+		transfers = append(transfers, account.Transfer{From: FeeAccount, To: to, Asset: asset, Amount: -fee, Type: ledger.EntryFee})
+	}
+	if net > 0 {
+		transfers = append(transfers, account.Transfer{From: from, To: to, Asset: asset, Amount: net, HoldOrderID: hold})
+	}
+	return transfers
+}
+
+// fillReference links journal entries of a fill to its trade and orders
+func fillReference(fill Fill) ledger.Reference {
+	ref := ledger.Reference{TradeID: fill.TradeID, OrderIDs: []string{fill.TakerOrderID}}
+	if fill.MakerOrderID != "" {
+		ref.OrderIDs = append(ref.OrderIDs, fill.MakerOrderID)
+	}
+	return ref
+}
@@ -0,0 +1,470 @@
+package engine
+
+import (
+	"context"
+	"matching-engine/internal/account"
+	"matching-engine/internal/engine/liquiditypool"
+	"matching-engine/internal/fees"
+	"matching-engine/internal/hedge"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/margin"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHoldsAndSettlement(t *testing.T) {
+	mockLP := &MockLiquidityPool{shouldFail: true}
+	engine := NewMatchingEngine(mockLP)
+	accounts := account.NewManager()
+	engine.SetAccountManager(accounts, "USD")
+
+	accounts.Deposit("seller", "BTC", 10)
+	accounts.Deposit("buyer", "USD", 1000)
+
+	// Resting sell holds the base asset
+	sell := engine.ProcessOrder(Order{ID: "sell-1", Trader: "seller", Asset: "BTC", Price: 100, Amount: 4, Type: Limit})
+	if sell.RemainingAmount != 4 {
+		t.Fatalf("Expected sell order to rest, got remaining %f", sell.RemainingAmount)
+	}
+	if balance := accounts.Balance("seller", "BTC"); balance.Held != 4 || balance.Available != 6 {
+		t.Errorf("Expected 4 held and 6 available, got %+v", balance)
+	}
+
+	// Buyer takes 3 units, paid out of the seller's hold
+	buy := engine.ProcessOrder(Order{ID: "buy-1", Trader: "buyer", Asset: "BTC", Price: 100, Amount: 3, Type: Limit, IsBuyOrder: true})
+	if !buy.Success || len(buy.Fills) != 1 {
+		t.Fatalf("Expected one full fill, got %+v", buy)
+	}
+	if balance := accounts.Balance("buyer", "USD"); balance.Available != 700 {
+		t.Errorf("Expected buyer to have 700 USD, got %+v", balance)
+	}
+	if balance := accounts.Balance("buyer", "BTC"); balance.Available != 3 {
+		t.Errorf("Expected buyer to have 3 BTC, got %+v", balance)
+	}
+	if balance := accounts.Balance("seller", "BTC"); balance.Held != 1 {
+		t.Errorf("Expected 1 BTC still held, got %+v", balance)
+	}
+	if balance := accounts.Balance("seller", "USD"); balance.Available != 300 {
+		t.Errorf("Expected seller to receive 300 USD, got %+v", balance)
+	}
+
+	// Cancelling the rest releases the hold
+	if _, err := engine.CancelOrder("sell-1"); err != nil {
+		t.Fatalf("Expected cancel to succeed, got %v", err)
+	}
+	if balance := accounts.Balance("seller", "BTC"); balance.Held != 0 || balance.Available != 7 {
+		t.Errorf("Expected hold released, got %+v", balance)
+	}
+}
+
+func TestOrderRejectedWithoutFunds(t *testing.T) {
+	mockLP := &MockLiquidityPool{shouldFail: true}
+	engine := NewMatchingEngine(mockLP)
+	accounts := account.NewManager()
+	engine.SetAccountManager(accounts, "USD")
+
+	accounts.Deposit("buyer", "USD", 50)
+
+	result := engine.ProcessOrder(Order{Trader: "buyer", Asset: "BTC", Price: 100, Amount: 1, Type: Limit, IsBuyOrder: true})
+	if result.Success || result.FilledAmount != 0 {
+		t.Errorf("Expected rejection, got %+v", result)
+	}
+	if len(engine.orderBook.BuyOrders) != 0 {
+		t.Errorf("Expected rejected order not to rest")
+	}
+}
+
+func TestExpireOrdersReleasesHolds(t *testing.T) {
+	mockLP := &MockLiquidityPool{shouldFail: true}
+	engine := NewMatchingEngine(mockLP)
+	accounts := account.NewManager()
+	engine.SetAccountManager(accounts, "USD")
+
+	accounts.Deposit("buyer", "USD", 500)
+	engine.ProcessOrder(Order{ID: "buy-exp", Trader: "buyer", Asset: "BTC", Price: 100, Amount: 2, Type: Limit, IsBuyOrder: true, Expiration: 1000})
+
+	if expired := engine.ExpireOrders(999); len(expired) != 0 {
+		t.Fatalf("Expected no expiry before deadline, got %d", len(expired))
+	}
+	if expired := engine.ExpireOrders(1000); len(expired) != 1 {
+		t.Fatalf("Expected one expired order, got %d", len(expired))
+	}
+	if balance := accounts.Balance("buyer", "USD"); balance.Available != 500 || balance.Held != 0 {
+		t.Errorf("Expected hold released on expiry, got %+v", balance)
+	}
+}
+
+func TestFeesChargedOnFills(t *testing.T) {
+	mockLP := &MockLiquidityPool{shouldFail: true}
+	engine := NewMatchingEngine(mockLP)
+	accounts := account.NewManager()
+	engine.SetAccountManager(accounts, "USD")
+	engine.SetFeeCalculator(fees.NewCalculator(fees.Schedule{
+		Tiers: []fees.Tier{{MakerRate: -0.001, TakerRate: 0.002}},
+	}))
+
+	accounts.Deposit("maker", "BTC", 10)
+	accounts.Deposit("taker", "USD", 1000)
+
+	engine.ProcessOrder(Order{ID: "sell-1", Trader: "maker", Asset: "BTC", Price: 100, Amount: 5, Type: Limit})
+	result := engine.ProcessOrder(Order{ID: "buy-1", Trader: "taker", Asset: "BTC", Price: 100, Amount: 5, Type: Limit, IsBuyOrder: true})
+
+	fill := result.Fills[0]
+	if fill.TakerFee != 0.01 || fill.TakerFeeCurrency != "BTC" {
+		t.Errorf("Expected taker fee 0.01 BTC, got %f %s", fill.TakerFee, fill.TakerFeeCurrency)
+	}
+	if fill.MakerFee != -0.5 || fill.MakerFeeCurrency != "USD" {
+		t.Errorf("Expected maker rebate of 0.5 USD, got %f %s", fill.MakerFee, fill.MakerFeeCurrency)
+	}
+
+	if balance := accounts.Balance("taker", "BTC"); balance.Available != 4.99 {
+		t.Errorf("Expected taker to receive 4.99 BTC, got %+v", balance)
+	}
+	if balance := accounts.Balance("maker", "USD"); balance.Available != 500.5 {
+		t.Errorf("Expected maker to receive 500.5 USD, got %+v", balance)
+	}
+	if balance := accounts.Balance(FeeAccount, "USD"); balance.Available != -0.5 {
+		t.Errorf("Expected fee account to pay the rebate, got %+v", balance)
+	}
+}
+
+func TestSettlementIsJournaled(t *testing.T) {
+	mockLP := &MockLiquidityPool{shouldFail: true}
+	engine := NewMatchingEngine(mockLP)
+	accounts := account.NewManager()
+	journal := ledger.New()
+	accounts.SetLedger(journal)
+	engine.SetAccountManager(accounts, "USD")
+	engine.SetFeeCalculator(fees.NewCalculator(fees.Schedule{
+		Tiers: []fees.Tier{{MakerRate: 0.001, TakerRate: 0.002}},
+	}))
+
+	accounts.Deposit("maker", "BTC", 10)
+	accounts.Deposit("taker", "USD", 1000)
+	engine.ProcessOrder(Order{ID: "sell-1", Trader: "maker", Asset: "BTC", Price: 100, Amount: 5, Type: Limit})
+	result := engine.ProcessOrder(Order{ID: "buy-1", Trader: "taker", Asset: "BTC", Price: 100, Amount: 2, Type: Limit, IsBuyOrder: true})
+
+	tb := journal.TrialBalance()
+	if !tb.Balanced {
+		t.Errorf("Expected balanced trial balance")
+	}
+	for trader, assets := range tb.Accounts {
+		if trader == ledger.ExternalAccount {
+			continue
+		}
+		for asset, total := range assets {
+			if balance := accounts.Balance(trader, asset); balance.Total() != total {
+				t.Errorf("Ledger has %s %s at %f, accounts at %f", trader, asset, total, balance.Total())
+			}
+		}
+	}
+
+	// Trade and fee entries link back to the trade and both orders
+	types := make(map[ledger.EntryType]bool)
+	for _, entry := range journal.Entries(0) {
+		if entry.Ref.TradeID != result.Fills[0].TradeID {
+			continue
+		}
+		types[entry.Type] = true
+		if len(entry.Ref.OrderIDs) != 2 {
+			t.Errorf("Expected taker and maker order IDs, got %v", entry.Ref.OrderIDs)
+		}
+	}
+	if !types[ledger.EntryTrade] || !types[ledger.EntryFee] {
+		t.Errorf("Expected trade and fee entries, got %v", types)
+	}
+}
+
+func TestMarginSettlementMovesPositions(t *testing.T) {
+	mockLP := &MockLiquidityPool{shouldFail: true}
+	engine := NewMatchingEngine(mockLP)
+	accounts := account.NewManager()
+	engine.SetAccountManager(accounts, "USD")
+	manager := margin.NewManager(accounts, engine, margin.Config{
+		QuoteAsset: "USD",
+		Haircuts:   map[string]float64{"USD": 0},
+	})
+	if err := engine.SetMarginManager(manager); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, trader := range []string{"alice", "bob", "carol"} {
+		accounts.Deposit(trader, "USD", 1000)
+	}
+
+	// Bob opens an isolated short against Alice's cross long
+	engine.ProcessOrder(Order{ID: "sell-1", Trader: "bob", Asset: "BTC", Price: 100, Amount: 5, Type: Limit, Leverage: 5, MarginType: Isolated})
+	engine.ProcessOrder(Order{ID: "buy-1", Trader: "alice", Asset: "BTC", Price: 100, Amount: 5, Type: Limit, IsBuyOrder: true, Leverage: 10, MarginType: Cross})
+
+	if p, ok := manager.Positions().Position("alice", "BTC", false); !ok || p.Size != 5 {
+		t.Fatalf("Expected alice long 5, got %+v", p)
+	}
+	if hold, _ := accounts.HoldFor(margin.IsolatedHoldKey("bob", "BTC")); hold.Amount != 100 {
+		t.Errorf("Expected 100 isolated margin for bob, got %f", hold.Amount)
+	}
+	if balance := accounts.Balance("bob", "USD"); balance.Available != 900 {
+		t.Errorf("Expected bob to have 900 available, got %+v", balance)
+	}
+
+	// Bob closes at 110: the 50 loss comes out of the isolated margin
+	engine.ProcessOrder(Order{ID: "sell-2", Trader: "carol", Asset: "BTC", Price: 110, Amount: 5, Type: Limit, Leverage: 5, MarginType: Cross})
+	engine.ProcessOrder(Order{ID: "buy-2", Trader: "bob", Asset: "BTC", Price: 110, Amount: 5, Type: Limit, IsBuyOrder: true, Leverage: 5, MarginType: Isolated})
+
+	if _, ok := manager.Positions().Position("bob", "BTC", true); ok {
+		t.Errorf("Expected bob's position to be closed")
+	}
+	if balance := accounts.Balance("bob", "USD"); balance.Available != 950 || balance.Held != 0 {
+		t.Errorf("Expected bob to end with 950 and no hold, got %+v", balance)
+	}
+	if balance := accounts.Balance("carol", "USD"); balance.Held != 0 {
+		t.Errorf("Expected carol's order margin to be released, got %+v", balance)
+	}
+}
+
+func TestMarginFillThatCannotPostMarginChangesNothing(t *testing.T) {
+	engine := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	accounts := account.NewManager()
+	engine.SetAccountManager(accounts, "USD")
+	engine.SetFeeCalculator(fees.NewCalculator(fees.Schedule{
+		Tiers: []fees.Tier{{TakerRate: 0.002}},
+	}))
+	manager := margin.NewManager(accounts, engine, margin.Config{
+		QuoteAsset: "USD",
+		Haircuts:   map[string]float64{"USD": 0},
+	})
+	if err := engine.SetMarginManager(manager); err != nil {
+		t.Fatal(err)
+	}
+	accounts.Deposit("alice", "USD", 1000)
+	accounts.Deposit("bob", "USD", 100)
+
+	// Bob has exactly the isolated margin of his short, so once the taker
+	// fee of 1 is paid he cannot post it
+	engine.ProcessOrder(Order{ID: "buy-1", Trader: "alice", Asset: "BTC", Price: 100, Amount: 5, Type: Limit, IsBuyOrder: true, Leverage: 10, MarginType: Cross})
+	result := engine.ProcessOrder(Order{ID: "sell-1", Trader: "bob", Asset: "BTC", Price: 100, Amount: 5, Type: Limit, Leverage: 5, MarginType: Isolated})
+
+	if result.FilledAmount != 0 || len(result.Fills) != 0 {
+		t.Fatalf("Expected no fill, got %+v", result)
+	}
+	if positions := manager.Positions().All(); len(positions) != 0 {
+		t.Errorf("Expected no positions, got %+v", positions)
+	}
+	if len(engine.orderBook.BuyOrders) != 1 || engine.orderBook.BuyOrders[0].FilledAmount != 0 {
+		t.Errorf("Expected alice's order untouched, got %+v", engine.orderBook.BuyOrders)
+	}
+	if balance := accounts.Balance("alice", "USD"); balance.Available != 950 || balance.Held != 50 {
+		t.Errorf("Expected alice's order margin kept, got %+v", balance)
+	}
+	if balance := accounts.Balance("bob", "USD"); balance.Available != 100 || balance.Held != 0 {
+		t.Errorf("Expected bob's balance untouched, got %+v", balance)
+	}
+	if balance := accounts.Balance(FeeAccount, "USD"); balance.Available != 0 {
+		t.Errorf("Expected no fee charged, got %+v", balance)
+	}
+}
+
+func TestUnsettleableFillLeavesBookAlone(t *testing.T) {
+	engine := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	accounts := account.NewManager()
+	engine.SetAccountManager(accounts, "USD")
+
+	accounts.Deposit("seller", "BTC", 2)
+	accounts.Deposit("buyer", "USD", 200)
+	engine.ProcessOrder(Order{ID: "cheap", Trader: "seller", Asset: "BTC", Price: 100, Amount: 1, Type: Limit})
+	engine.ProcessOrder(Order{ID: "dear", Trader: "seller", Asset: "BTC", Price: 150, Amount: 1, Type: Limit})
+
+	// Sized at the pool price of 100, the order cannot pay for the second
+	// level, so matching stops after the first
+	result := engine.ProcessOrder(Order{ID: "sweep", Trader: "buyer", Asset: "BTC", Amount: 2, Type: Market, IsBuyOrder: true})
+	if result.FilledAmount != 1 || result.RemainingAmount != 1 || len(result.Fills) != 1 || result.Fills[0].MakerOrderID != "cheap" {
+		t.Fatalf("Expected only the first level filled, got %+v", result)
+	}
+	if len(engine.orderBook.SellOrders) != 1 || engine.orderBook.SellOrders[0].ID != "dear" || engine.orderBook.SellOrders[0].FilledAmount != 0 {
+		t.Errorf("Expected the dear order untouched, got %+v", engine.orderBook.SellOrders)
+	}
+	if balance := accounts.Balance("seller", "BTC"); balance.Held != 1 {
+		t.Errorf("Expected the dear order's hold kept, got %+v", balance)
+	}
+	if balance := accounts.Balance("buyer", "USD"); balance.Available != 100 {
+		t.Errorf("Expected the buyer to have paid for one fill, got %+v", balance)
+	}
+	if status, ok := engine.OrderStatus("sweep"); !ok || status.State != StateCancelled || status.Filled != 1 || status.Reason == "" {
+		t.Errorf("Expected the remainder cancelled with a reason, got %+v", status)
+	}
+}
+
+// countingPool counts price lookups
+type countingPool struct {
+	MockLiquidityPool
+	lookups atomic.Int32
+}
+
+func (p *countingPool) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	p.lookups.Add(1)
+	return 100.0, nil
+}
+
+func TestMarginPricesComeFromTheCache(t *testing.T) {
+	pool := &countingPool{}
+	engine := NewMatchingEngine(pool)
+	engine.SetAccountManager(account.NewManager(), "USD")
+
+	if price := engine.Price("BTC"); price != 0 || pool.lookups.Load() != 0 {
+		t.Fatalf("Expected no price and no lookup before any refresh, got %f after %d lookups", price, pool.lookups.Load())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.RefreshPrices(ctx, []string{"BTC", "USD"}, time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for engine.Price("BTC") != 100 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the price refresh")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	lookups := pool.lookups.Load()
+	if engine.Price("BTC") != 100 || engine.Price("USD") != 1 || pool.lookups.Load() != lookups {
+		t.Errorf("Expected cached prices without further lookups, got %d lookups after %d", pool.lookups.Load(), lookups)
+	}
+}
+
+func TestInsuranceFundAndRestoreReconcileWithTheLedger(t *testing.T) {
+	engine := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	accounts := account.NewManager()
+	accounts.SetLedger(ledger.New())
+	engine.SetAccountManager(accounts, "USD")
+
+	if err := engine.Deposit("alice", "USD", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.InsuranceFund().Deposit("USD", 40, "seed"); err != nil {
+		t.Fatal(err)
+	}
+	if balance := accounts.Balance(InsuranceAccount, "USD"); balance.Available != 40 {
+		t.Errorf("Expected the fund's deposit in %s, got %+v", InsuranceAccount, balance)
+	}
+	if tb, _ := accounts.Reconcile(); !tb.Balanced {
+		t.Errorf("Expected the ledger to reconcile, got %+v", tb)
+	}
+
+	// A restored engine carries on the snapshot's ledger
+	restoredAccounts := account.NewManager()
+	restoredAccounts.SetLedger(ledger.New())
+	restored := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	restored.SetAccountManager(restoredAccounts, "USD")
+	restored.Restore(engine.Snapshot())
+	if tb, _ := restoredAccounts.Reconcile(); !tb.Balanced || tb.Accounts["alice"]["USD"] != 100 {
+		t.Errorf("Expected opening balances to reconcile, got %+v", tb)
+	}
+	if a, b := len(restoredAccounts.Ledger().Entries(0)), len(accounts.Ledger().Entries(0)); a != b {
+		t.Errorf("Expected the %d recorded entries and no opening entry, got %d", b, a)
+	}
+	engine.Deposit("bob", "USD", 10)
+	restored.Deposit("bob", "USD", 10)
+	recordedEntries, restoredEntries := accounts.Ledger().Entries(0), restoredAccounts.Ledger().Entries(0)
+	if a, b := restoredEntries[len(restoredEntries)-1].ID, recordedEntries[len(recordedEntries)-1].ID; a != b {
+		t.Errorf("Expected the next entry to be %s on both engines, got %s", b, a)
+	}
+
+	// Without a ledger in the snapshot, the balances get an opening entry
+	snapshot := engine.Snapshot()
+	snapshot.Ledger = nil
+	openedAccounts := account.NewManager()
+	openedAccounts.SetLedger(ledger.New())
+	opened := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	opened.SetAccountManager(openedAccounts, "USD")
+	opened.Restore(snapshot)
+	if tb, _ := openedAccounts.Reconcile(); !tb.Balanced || tb.Accounts["bob"]["USD"] != 10 {
+		t.Errorf("Expected opening balances to reconcile, got %+v", tb)
+	}
+}
+
+// slippingPool quotes at its price but fills one unit worse
+type slippingPool struct {
+	price float64
+}
+
+func (p *slippingPool) GetAvailableLiquidity(ctx context.Context, asset string, isBuyOrder bool, limitPrice float64) (float64, error) {
+	return 10, nil
+}
+
+func (p *slippingPool) RequestQuote(ctx context.Context, asset string, isBuy bool, amount float64, limitPrice float64) (liquiditypool.Quote, error) {
+	return liquiditypool.Quote{ID: "q-1", Asset: asset, IsBuy: isBuy, Amount: amount, Price: p.price}, nil
+}
+
+func (p *slippingPool) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (liquiditypool.Execution, error) {
+	return liquiditypool.Execution{OrderID: orderId, QuoteID: quoteID, Status: liquiditypool.ExecutionFilled, FilledAmount: 2, Price: p.price + 1}, nil
+}
+
+func (p *slippingPool) TradeStatus(ctx context.Context, orderId string) (liquiditypool.Execution, error) {
+	return liquiditypool.Execution{OrderID: orderId, Status: liquiditypool.ExecutionNotFound}, nil
+}
+
+func (p *slippingPool) GetCurrentPrice(ctx context.Context, asset string) (float64, error) {
+	return p.price, nil
+}
+
+func TestPoolFillBeyondLimitChargesTheLimit(t *testing.T) {
+	engine := NewMatchingEngine(&slippingPool{price: 100})
+	accounts := account.NewManager()
+	engine.SetAccountManager(accounts, "USD")
+	hedger := hedge.NewHedger(&MockLiquidityPool{shouldFail: true}, engine, hedge.Config{})
+	engine.SetHedger(hedger)
+	accounts.Deposit("buyer", "USD", 1000)
+
+	result := engine.ProcessOrder(Order{ID: "buy-1", Trader: "buyer", Asset: "BTC", Price: 100, Amount: 2, Type: Limit, IsBuyOrder: true})
+	if result.FilledAmount != 2 || result.Fills[0].Price != 100 {
+		t.Fatalf("Expected 2 filled at the limit, got %+v", result)
+	}
+	if balance := accounts.Balance("buyer", "USD"); balance.Available != 800 {
+		t.Errorf("Expected the buyer to pay no more than the limit, got %+v", balance)
+	}
+
+	// The house took the fill at the venue's price
+	if report := hedger.Report(); len(report.Assets) != 1 || report.Assets[0].EntryPrice != 101 {
+		t.Errorf("Expected the house short at 101, got %+v", report.Assets)
+	}
+	unreconciled := engine.UnreconciledPoolTrades()
+	if len(unreconciled) != 1 || unreconciled[0].OrderID != "buy-1" || unreconciled[0].Reason == "" {
+		t.Errorf("Expected the fill to be flagged for review, got %+v", unreconciled)
+	}
+}
+
+// drainingPool runs onExecute as the venue executes, before the fill reaches
+// the engine
+type drainingPool struct {
+	slippingPool
+	onExecute func()
+}
+
+func (p *drainingPool) ExecuteQuote(ctx context.Context, quoteID string, orderId string) (liquiditypool.Execution, error) {
+	p.onExecute()
+	return p.slippingPool.ExecuteQuote(ctx, quoteID, orderId)
+}
+
+func TestUnsettledPoolFillIsFlaggedAndDoesNotRest(t *testing.T) {
+	accounts := account.NewManager()
+	pool := &drainingPool{slippingPool: slippingPool{price: 99}}
+	// The buyer's funds leave behind the engine's back, so the venue's fill
+	// of 2 at 100 cannot be paid for
+	pool.onExecute = func() { accounts.Withdraw("buyer", "USD", 150) }
+	engine := NewMatchingEngine(pool)
+	engine.SetAccountManager(accounts, "USD")
+	accounts.Deposit("buyer", "USD", 300)
+
+	result := engine.ProcessOrder(Order{ID: "buy-1", Trader: "buyer", Asset: "BTC", Price: 100, Amount: 3, Type: Limit, IsBuyOrder: true})
+	if result.Success || result.FilledAmount != 0 || result.RemainingAmount != 1 {
+		t.Fatalf("Expected nothing filled and 1 left, got %+v", result)
+	}
+	if len(engine.orderBook.BuyOrders) != 1 || engine.orderBook.BuyOrders[0].Amount != 1 {
+		t.Errorf("Expected only the unexecuted 1 to rest, got %+v", engine.orderBook.BuyOrders)
+	}
+	if balance := accounts.Balance("buyer", "USD"); balance.Held != 100 || balance.Available != 50 {
+		t.Errorf("Expected 100 held for the resting order, got %+v", balance)
+	}
+	unreconciled := engine.UnreconciledPoolTrades()
+	if len(unreconciled) != 1 || unreconciled[0].OrderID != "buy-1" || unreconciled[0].Reason == "" {
+		t.Errorf("Expected the unsettled fill to be flagged for review, got %+v", unreconciled)
+	}
+}
@@ -0,0 +1,303 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"matching-engine/internal/account"
+	"matching-engine/internal/engine/liquiditypool/amm"
+	"matching-engine/internal/events"
+	"matching-engine/internal/fees"
+	"matching-engine/internal/hedge"
+	"matching-engine/internal/journal"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/margin"
+	"matching-engine/internal/snapshot"
+	"matching-engine/pkg/utils"
+	"sort"
+	"time"
+)
+
+// BookEntry is a resting order and its position on its side of the book
+type BookEntry struct {
+	Index int
+	Order Order
+}
+
+// MarketSnapshot is the book of one asset. Orders keep their position on the
+// engine's book sides so a restore reproduces the exact queue order.
+type MarketSnapshot struct {
+	Asset      string
+	LastPrice  float64
+	Checksum   uint32
+	BuyOrders  []BookEntry
+	SellOrders []BookEntry
+}
+
+// Snapshot is the engine state after applying the journal up to Seq. The
+// insurance audit trail is not part of it.
+type Snapshot struct {
+	Seq         uint64
+	Time        time.Time
+	Markets     []MarketSnapshot
+	Balances    map[string]map[string]account.Balance
+	Holds       []account.Hold
+	Positions   []margin.Position
+	Insurance   map[string]float64
+	FeeVolumes  map[string]map[int64]float64
+	LastOrderID string
+	LastTradeID string
+	// LastEventSeq lets the event stream carry on from the snapshot
+	LastEventSeq uint64
+	// Orders are the statuses OrderStatus reports
+	Orders []OrderStatus
+	// Unpublished are events the publisher refused, still to be retried
+	Unpublished []events.Event
+	// Hedge is the hedger's book, if a hedger is set
+	Hedge *hedge.State
+	// Pools are the reserves of in-process venues by venue and asset
+	Pools map[string]map[string]amm.Reserves
+	// FeeSchedules are the instrument specific fee schedules
+	FeeSchedules map[string]fees.Schedule
+	// Ledger is the ledger history, so entries posted after a restore keep
+	// the IDs the engine that took the snapshot would have given them
+	Ledger []ledger.Entry
+}
+
+// Snapshot copies the engine state. Matching is only paused for the copy;
+// encoding and writing it are left to the caller.
+func (e *MatchingEngine) Snapshot() Snapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s := Snapshot{
+		Time:      e.now(),
+		Balances:  make(map[string]map[string]account.Balance),
+		Holds:     []account.Hold{},
+		Positions: []margin.Position{},
+		Insurance: e.insurance.Balances(),
+	}
+	if e.journal != nil {
+		s.Seq = e.journal.NextSeq() - 1
+	}
+	s.LastOrderID, s.LastTradeID = e.ids.Last()
+	s.LastEventSeq = e.eventSeq
+	s.Unpublished = append([]events.Event(nil), e.unpublished...)
+	s.Orders = e.snapshotStatuses()
+	s.FeeVolumes = e.fees.Volumes()
+	s.FeeSchedules, _ = e.fees.Schedules()
+	if e.accounts != nil {
+		for _, trader := range e.accounts.Traders() {
+			s.Balances[trader] = e.accounts.Balances(trader)
+		}
+		s.Holds = e.accounts.Holds()
+		if l := e.accounts.Ledger(); l != nil {
+			s.Ledger = l.Entries(0)
+		}
+	}
+	if e.margin != nil {
+		s.Positions = e.margin.Positions().All()
+	}
+	if e.hedger != nil {
+		hedgeState := e.hedger.State()
+		s.Hedge = &hedgeState
+	}
+	s.Pools = e.poolReserves()
+
+	markets := make(map[string]*MarketSnapshot)
+	market := func(asset string) *MarketSnapshot {
+		m, ok := markets[asset]
+		if !ok {
+			m = &MarketSnapshot{Asset: asset, BuyOrders: []BookEntry{}, SellOrders: []BookEntry{}}
+			markets[asset] = m
+		}
+		return m
+	}
+	for i, order := range e.orderBook.BuyOrders {
+		m := market(order.Asset)
+		m.BuyOrders = append(m.BuyOrders, BookEntry{Index: i, Order: order})
+	}
+	for i, order := range e.orderBook.SellOrders {
+		m := market(order.Asset)
+		m.SellOrders = append(m.SellOrders, BookEntry{Index: i, Order: order})
+	}
+	e.priceMu.Lock()
+	for asset, price := range e.lastPrices {
+		market(asset).LastPrice = price
+	}
+	e.priceMu.Unlock()
+	for asset, checksum := range e.checksums {
+		market(asset).Checksum = checksum
+	}
+
+	s.Markets = make([]MarketSnapshot, 0, len(markets))
+	for _, m := range markets {
+		s.Markets = append(s.Markets, *m)
+	}
+	sort.Slice(s.Markets, func(i, k int) bool { return s.Markets[i].Asset < s.Markets[k].Asset })
+	return s
+}
+
+// Restore replaces the engine state with a snapshot
+func (e *MatchingEngine) Restore(s Snapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	buys, sells := make([]BookEntry, 0), make([]BookEntry, 0)
+	e.priceMu.Lock()
+	e.lastPrices = make(map[string]float64, len(s.Markets))
+	e.checksums = make(map[string]uint32, len(s.Markets))
+	for _, m := range s.Markets {
+		buys = append(buys, m.BuyOrders...)
+		sells = append(sells, m.SellOrders...)
+		if m.LastPrice > 0 {
+			e.lastPrices[m.Asset] = m.LastPrice
+		}
+		if m.Checksum != 0 {
+			e.checksums[m.Asset] = m.Checksum
+		}
+	}
+	e.priceMu.Unlock()
+	e.orderBook = OrderBook{BuyOrders: fromEntries(buys), SellOrders: fromEntries(sells)}
+
+	if e.accounts != nil {
+		if l := e.accounts.Ledger(); l != nil && s.Ledger != nil {
+			l.Restore(s.Ledger)
+		}
+		// Balances the restored ledger does not account for, as with a
+		// snapshot taken without one, get an opening entry
+		if err := e.accounts.Restore(s.Balances, s.Holds); err != nil {
+			utils.LogError(fmt.Errorf("posting opening balances: %w", err))
+		}
+	}
+	if e.margin != nil {
+		e.margin.Positions().Restore(s.Positions)
+	}
+	e.insurance.Restore(s.Insurance)
+	e.fees.RestoreVolumes(s.FeeVolumes)
+	if s.FeeSchedules != nil {
+		// Snapshots taken before schedules were journaled leave the
+		// configured ones in place
+		e.fees.RestoreSchedules(s.FeeSchedules)
+	}
+	e.ids.Observe(s.LastOrderID)
+	e.ids.Observe(s.LastTradeID)
+	e.eventSeq = s.LastEventSeq
+	e.unpublished = append([]events.Event(nil), s.Unpublished...)
+	e.restoreStatuses(s.Orders)
+	if e.hedger != nil && s.Hedge != nil {
+		e.hedger.Restore(*s.Hedge)
+	}
+	pools := e.localPools()
+	for name, reserves := range s.Pools {
+		if pool, ok := pools[name]; ok {
+			pool.RestorePools(reserves)
+		}
+	}
+}
+
+// poolReserves returns the reserves of every in-process venue
+func (e *MatchingEngine) poolReserves() map[string]map[string]amm.Reserves {
+	reserves := make(map[string]map[string]amm.Reserves)
+	for name, pool := range e.localPools() {
+		reserves[name] = pool.Pools()
+	}
+	return reserves
+}
+
+func fromEntries(entries []BookEntry) []Order {
+	sort.Slice(entries, func(i, k int) bool { return entries[i].Index < entries[k].Index })
+	orders := make([]Order, 0, len(entries))
+	for _, entry := range entries {
+		orders = append(orders, entry.Order)
+	}
+	return orders
+}
+
+// SaveSnapshot writes a snapshot to dir and returns the journal sequence
+// number it covers
+func (e *MatchingEngine) SaveSnapshot(dir string) (uint64, error) {
+	s := e.Snapshot()
+	if _, err := snapshot.Save(dir, s.Seq, s); err != nil {
+		return 0, err
+	}
+	return s.Seq, nil
+}
+
+// Recover restores the newest readable snapshot in snapshotDir and replays
+// the journal after it. It returns the last journal sequence number applied.
+func (e *MatchingEngine) Recover(snapshotDir string, journalDir string) (uint64, error) {
+	replayer := e.NewReplayer()
+	last, err := replayer.Recover(snapshotDir, journalDir)
+	if err != nil {
+		return 0, err
+	}
+	return last, replayer.Flush()
+}
+
+// Recover is MatchingEngine.Recover for a replayer that carries on with
+// records from elsewhere: a new order at the end of the journal stays held
+// back, as its pool result may be the next record.
+func (r *Replayer) Recover(snapshotDir string, journalDir string) (uint64, error) {
+	e := r.e
+	snapshots, err := snapshot.List(snapshotDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var seq uint64
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		var s Snapshot
+		if _, err := snapshot.Load(snapshots[i].Path, &s); err != nil {
+			// An older snapshot plus a longer replay still recovers
+			utils.LogError(fmt.Errorf("skipping snapshot %s: %w", snapshots[i].Path, err))
+			continue
+		}
+		e.Restore(s)
+		seq = s.Seq
+		break
+	}
+
+	if err := journal.Read(journalDir, seq+1, r.Apply); err != nil {
+		return 0, err
+	}
+	last := r.Last()
+	if last == 0 {
+		last = seq
+	}
+	return last, nil
+}
+
+// RunSnapshots writes a snapshot to dir every interval until ctx is done,
+// skipping intervals without new journal records, and keeps the newest keep
+// snapshots
+func (e *MatchingEngine) RunSnapshots(ctx context.Context, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var taken uint64
+	if snapshots, err := snapshot.List(dir); err == nil && len(snapshots) > 0 {
+		taken = snapshots[len(snapshots)-1].Seq
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		wal := e.Journal()
+		if wal == nil || wal.NextSeq()-1 == taken {
+			continue
+		}
+		seq, err := e.SaveSnapshot(dir)
+		if err != nil {
+			utils.LogError(fmt.Errorf("writing snapshot: %w", err))
+			continue
+		}
+		taken = seq
+		if keep > 0 {
+			if err := snapshot.Prune(dir, keep); err != nil {
+				utils.LogError(fmt.Errorf("pruning snapshots: %w", err))
+			}
+		}
+	}
+}
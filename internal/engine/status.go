@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"matching-engine/internal/events"
+	"sort"
+	"time"
+)
+
+// Order states reported by OrderStatus
+const (
+	StateNew             = "new"
+	StatePartiallyFilled = "partially_filled"
+	StateFilled          = "filled"
+	StateCancelled       = "cancelled"
+	StateExpired         = "expired"
+	StateRejected        = "rejected"
+)
+
+// DefaultOrderRetention is how many finished orders the engine keeps the
+// status of. Open orders are always kept.
+const DefaultOrderRetention = 10000
+
+// OrderFill is one execution of an order
+type OrderFill struct {
+	TradeID string
+	Price   float64
+	Amount  float64
+	// Maker is set when the order was resting on the book
+	Maker bool
+	Fee   float64
+	Venue string
+	Time  time.Time
+}
+
+// OrderStatus is what the engine knows about an order it accepted or
+// rejected. Amount is the original amount, including any amendment.
+type OrderStatus struct {
+	OrderID      string
+	Trader       string
+	Asset        string
+	IsBuy        bool
+	Type         string
+	Price        float64
+	State        string
+	Amount       float64
+	Filled       float64
+	Remaining    float64
+	AveragePrice float64
+	Reason       string
+	Fills        []OrderFill
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// finished reports whether an order can no longer change
+func (s *OrderStatus) finished() bool {
+	switch s.State {
+	case StateFilled, StateCancelled, StateExpired, StateRejected:
+		return true
+	}
+	return false
+}
+
+func (s OrderStatus) copy() OrderStatus {
+	s.Fills = append([]OrderFill{}, s.Fills...)
+	return s
+}
+
+// OrderStatus returns the status of an order, if the engine still has it
+func (e *MatchingEngine) OrderStatus(orderID string) (OrderStatus, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status, ok := e.statuses[orderID]
+	if !ok {
+		return OrderStatus{}, false
+	}
+	return status.copy(), true
+}
+
+// OpenOrders returns the resting orders of a trader, bids first, each side
+// in book order
+func (e *MatchingEngine) OpenOrders(trader string) []OrderStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	open := make([]OrderStatus, 0)
+	for _, orders := range [][]Order{e.orderBook.BuyOrders, e.orderBook.SellOrders} {
+		for _, order := range orders {
+			if order.Trader != trader {
+				continue
+			}
+			if status, ok := e.statuses[order.ID]; ok {
+				open = append(open, status.copy())
+			}
+		}
+	}
+	return open
+}
+
+// trackStatus updates the order statuses from an event; it must be called
+// with e.mu held
+func (e *MatchingEngine) trackStatus(event events.Event) {
+	if event.Type == events.TradeExecuted {
+		trade := event.Trade
+		e.addOrderFill(trade.TakerOrderID, OrderFill{
+			TradeID: trade.TradeID, Price: trade.Price, Amount: trade.Amount,
+			Fee: trade.TakerFee, Venue: trade.Venue, Time: event.Time,
+		})
+		e.addOrderFill(trade.MakerOrderID, OrderFill{
+			TradeID: trade.TradeID, Price: trade.Price, Amount: trade.Amount,
+			Maker: true, Fee: trade.MakerFee, Time: event.Time,
+		})
+		return
+	}
+	if event.OrderID == "" {
+		return
+	}
+
+	status, ok := e.statuses[event.OrderID]
+	if !ok {
+		status = &OrderStatus{
+			OrderID:   event.OrderID,
+			Trader:    event.Trader,
+			Asset:     event.Asset,
+			IsBuy:     event.IsBuy,
+			Type:      event.OrderType,
+			CreatedAt: event.Time,
+			Fills:     []OrderFill{},
+		}
+		e.statuses[event.OrderID] = status
+	}
+	wasFinished := status.finished()
+	status.Price = event.Price
+	status.Amount = event.Amount
+	status.Filled = event.Filled
+	status.Remaining = event.Remaining
+	status.UpdatedAt = event.Time
+	if event.Reason != "" {
+		status.Reason = event.Reason
+	}
+	switch event.Type {
+	case events.OrderFilled:
+		status.State = StateFilled
+	case events.OrderCancelled:
+		status.State = StateCancelled
+	case events.OrderExpired:
+		status.State = StateExpired
+	case events.OrderRejected:
+		status.State = StateRejected
+	default:
+		status.State = StateNew
+		if status.Filled > 0 {
+			status.State = StatePartiallyFilled
+		}
+	}
+	if !wasFinished && status.finished() {
+		e.retire(status.OrderID)
+	}
+}
+
+func (e *MatchingEngine) addOrderFill(orderID string, fill OrderFill) {
+	status, ok := e.statuses[orderID]
+	if !ok {
+		return
+	}
+	notional := status.AveragePrice * filledAmount(status.Fills)
+	status.Fills = append(status.Fills, fill)
+	status.AveragePrice = (notional + fill.Price*fill.Amount) / filledAmount(status.Fills)
+	status.UpdatedAt = fill.Time
+}
+
+func filledAmount(fills []OrderFill) float64 {
+	total := 0.0
+	for _, fill := range fills {
+		total += fill.Amount
+	}
+	return total
+}
+
+// retire queues a finished order for removal once more than the retention
+// have finished after it
+func (e *MatchingEngine) retire(orderID string) {
+	e.finishedOrders = append(e.finishedOrders, orderID)
+	for len(e.finishedOrders) > DefaultOrderRetention {
+		delete(e.statuses, e.finishedOrders[0])
+		e.finishedOrders = e.finishedOrders[1:]
+	}
+}
+
+// snapshotStatuses lists the open orders by ID, then the finished ones in
+// the order they finished; it must be called with e.mu held
+func (e *MatchingEngine) snapshotStatuses() []OrderStatus {
+	statuses := make([]OrderStatus, 0, len(e.statuses))
+	for _, status := range e.statuses {
+		if !status.finished() {
+			statuses = append(statuses, status.copy())
+		}
+	}
+	sort.Slice(statuses, func(i, k int) bool { return statuses[i].OrderID < statuses[k].OrderID })
+	for _, orderID := range e.finishedOrders {
+		statuses = append(statuses, e.statuses[orderID].copy())
+	}
+	return statuses
+}
+
+// restoreStatuses replaces the order statuses with those of a snapshot; it
+// must be called with e.mu held
+func (e *MatchingEngine) restoreStatuses(statuses []OrderStatus) {
+	e.statuses = make(map[string]*OrderStatus, len(statuses))
+	e.finishedOrders = make([]string, 0)
+	for _, status := range statuses {
+		status := status.copy()
+		e.statuses[status.OrderID] = &status
+		if status.finished() {
+			e.finishedOrders = append(e.finishedOrders, status.OrderID)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"matching-engine/internal/account"
+	"testing"
+)
+
+func TestOrderStatusFollowsLifecycle(t *testing.T) {
+	engine, _, published := runEventSession(t)
+
+	sell, _ := engine.OrderStatus(published[0].OrderID)
+	if sell.State != StateCancelled || sell.Filled != 1 || len(sell.Fills) != 1 || !sell.Fills[0].Maker {
+		t.Errorf("Expected bob's sell to be cancelled after one maker fill, got %+v", sell)
+	}
+	if sell.Price != 102 || sell.Remaining != 1.5 {
+		t.Errorf("Expected the amended price and remainder, got %+v", sell)
+	}
+
+	buy, _ := engine.OrderStatus(published[3].OrderID)
+	if buy.State != StateFilled || buy.Amount != 1 || buy.Remaining != 0 || buy.AveragePrice != 101 {
+		t.Errorf("Expected alice's buy to be filled at 101, got %+v", buy)
+	}
+	if len(buy.Fills) != 1 || buy.Fills[0].Maker || buy.Fills[0].TradeID != published[4].Trade.TradeID {
+		t.Errorf("Expected one taker fill, got %+v", buy.Fills)
+	}
+
+	for i, state := range map[int]string{8: StateRejected, 12: StateExpired, 19: StateCancelled} {
+		status, ok := engine.OrderStatus(published[i].OrderID)
+		if !ok || status.State != state {
+			t.Errorf("Expected order %s to be %s, got %+v", published[i].OrderID, state, status)
+		}
+		if state != StateExpired && status.Reason == "" {
+			t.Errorf("Expected a reason for order %s", status.OrderID)
+		}
+	}
+	if _, ok := engine.OrderStatus("no-such-order"); ok {
+		t.Errorf("Expected an unknown order to have no status")
+	}
+}
+
+func TestOpenOrdersSurviveSnapshots(t *testing.T) {
+	engine := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	engine.SetAccountManager(account.NewManager(), "USD")
+	engine.Deposit("bob", "BTC", 5)
+	engine.Deposit("alice", "USD", 1000)
+	first := engine.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 110, Amount: 2, Type: Limit})
+	engine.ProcessOrder(Order{Trader: "alice", Asset: "BTC", Price: 110, Amount: 0.5, Type: Limit, IsBuyOrder: true})
+	second := engine.ProcessOrder(Order{Trader: "bob", Asset: "BTC", Price: 111, Amount: 1, Type: Limit})
+
+	open := engine.OpenOrders("bob")
+	if len(open) != 2 || open[0].OrderID != first.OrderID || open[1].OrderID != second.OrderID {
+		t.Fatalf("Expected bob's two resting orders, got %+v", open)
+	}
+	if open[0].State != StatePartiallyFilled || open[0].Remaining != 1.5 || open[1].State != StateNew {
+		t.Errorf("Unexpected open orders %+v", open)
+	}
+	if len(engine.OpenOrders("carol")) != 0 {
+		t.Errorf("Expected carol to have no open orders")
+	}
+
+	restored := NewMatchingEngine(&MockLiquidityPool{shouldFail: true})
+	restored.Restore(engine.Snapshot())
+	if got := restored.OpenOrders("bob"); len(got) != 2 || len(got[0].Fills) != 1 {
+		t.Errorf("Expected the open orders and their fills to be restored, got %+v", got)
+	}
+	for _, status := range engine.Snapshot().Orders {
+		got, ok := restored.OrderStatus(status.OrderID)
+		if !ok || got.State != status.State || got.Filled != status.Filled {
+			t.Errorf("Order %s: expected %+v, got %+v", status.OrderID, status, got)
+		}
+	}
+}
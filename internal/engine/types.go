@@ -31,13 +31,40 @@ type Order struct {
 	TakeProfitPrice float64
 }
 
+// Fill is a single execution of an order against a resting order or the liquidity pool
+type Fill struct {
+	TradeID      string
+	TakerOrderID string
+	MakerOrderID string // empty for liquidity pool fills
+	Taker        string
+	Maker        string
+	Asset        string
+	Price        float64
+	Amount       float64
+	TakerIsBuy   bool
+	FromPool     bool
+	Venue        string // liquidity venue of a pool fill
+	// Fees are charged in the asset each side receives; negative values are rebates
+	TakerFee         float64
+	TakerFeeCurrency string
+	MakerFee         float64
+	MakerFeeCurrency string
+}
+
 // MatchResult represents the result of order matching
 type MatchResult struct {
+	OrderID         string
 	Success         bool
 	FilledAmount    float64
 	RemainingAmount float64
-	ExecutedPrice   float64
+	ExecutedPrice   float64 // average over orderbook and pool fills
 	Message         string
+	Fills           []Fill
+	// Split of the fills between the orderbook and the liquidity pool
+	OrderbookFilled float64
+	OrderbookPrice  float64
+	PoolFilled      float64
+	PoolPrice       float64
 }
 
 // OrderBook maintains the buy and sell orders
@@ -0,0 +1,89 @@
+// Package events is the engine's output stream: everything that happened to
+// orders and the book, in the order it happened, each event numbered by a
+// global sequence number without gaps.
+//
+// The stream is derived from the journal, so replaying the journal produces
+// the same events with the same numbers again. Publishers remember the last
+// number they hold, which lets the engine skip events a publisher already
+// has when it replays after a restart.
+package events
+
+import (
+	"time"
+)
+
+// Type names what an event reports
+type Type string
+
+const (
+	OrderAccepted        Type = "order_accepted"
+	OrderRejected        Type = "order_rejected"
+	OrderRested          Type = "order_rested"
+	OrderFilled          Type = "order_filled"
+	OrderPartiallyFilled Type = "order_partially_filled"
+	OrderAmended         Type = "order_amended"
+	OrderCancelled       Type = "order_cancelled"
+	OrderExpired         Type = "order_expired"
+	TradeExecuted        Type = "trade"
+	BookDelta            Type = "book_delta"
+)
+
+// Event is one entry of the stream. Order events describe the order after
+// the change; Filled and Remaining are its totals so far.
+type Event struct {
+	Seq  uint64    `json:"seq"`
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// JournalSeq is the journal record of the command that caused the event
+	JournalSeq uint64 `json:"journal_seq,omitempty"`
+
+	Asset     string  `json:"asset"`
+	OrderID   string  `json:"order_id,omitempty"`
+	Trader    string  `json:"trader,omitempty"`
+	IsBuy     bool    `json:"is_buy"`
+	OrderType string  `json:"order_type,omitempty"`
+	Price     float64 `json:"price,omitempty"`
+	Amount    float64 `json:"amount,omitempty"`
+	Filled    float64 `json:"filled,omitempty"`
+	Remaining float64 `json:"remaining,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+
+	Trade *Trade `json:"trade,omitempty"`
+	// Delta is the change to one price level of the book; Price and IsBuy
+	// name the level
+	Delta *Delta `json:"delta,omitempty"`
+}
+
+// Trade is an execution between a taker and a resting order or a venue
+type Trade struct {
+	TradeID      string  `json:"trade_id"`
+	TakerOrderID string  `json:"taker_order_id"`
+	MakerOrderID string  `json:"maker_order_id,omitempty"`
+	Taker        string  `json:"taker"`
+	Maker        string  `json:"maker"`
+	Price        float64 `json:"price"`
+	Amount       float64 `json:"amount"`
+	TakerIsBuy   bool    `json:"taker_is_buy"`
+	Venue        string  `json:"venue,omitempty"`
+	TakerFee     float64 `json:"taker_fee"`
+	MakerFee     float64 `json:"maker_fee"`
+}
+
+// Delta is the change in resting amount at a price level
+type Delta struct {
+	Change float64 `json:"change"`
+	// Size is the amount resting at the level afterwards
+	Size float64 `json:"size"`
+	// Checksum is the market's book checksum after the change
+	Checksum uint32 `json:"checksum"`
+}
+
+// Publisher delivers events to consumers. Publish is called with the events
+// of one command, in sequence order, while the engine is locked, so it
+// should not block for long.
+type Publisher interface {
+	Publish(events []Event) error
+	// LastSeq returns the sequence number of the last event published
+	LastSeq() uint64
+	Close() error
+}
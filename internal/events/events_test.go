@@ -0,0 +1,163 @@
+package events
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func numbered(from uint64, n int) []Event {
+	events := make([]Event, 0, n)
+	for i := 0; i < n; i++ {
+		events = append(events, Event{Seq: from + uint64(i), Type: OrderAccepted, Time: time.Unix(int64(i), 0).UTC(), OrderID: "ord"})
+	}
+	return events
+}
+
+func readAll(t *testing.T, path string, after uint64) []Event {
+	t.Helper()
+	events := make([]Event, 0)
+	if err := ReadFile(path, after, func(event Event) error {
+		events = append(events, event)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	return events
+}
+
+func TestFileAppendsAndResumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "events.jsonl")
+	file, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Publish(numbered(1, 3)); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// A crash mid-line leaves a torn tail, which reopening cuts off
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString(`{"seq":4,"type":"ord`)
+	f.Close()
+
+	file, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.LastSeq() != 3 {
+		t.Errorf("Expected to resume after event 3, got %d", file.LastSeq())
+	}
+	if err := file.Publish(numbered(4, 2)); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	events := readAll(t, path, 0)
+	if len(events) != 5 {
+		t.Fatalf("Expected 5 events, got %d", len(events))
+	}
+	for i, event := range events {
+		if event.Seq != uint64(i+1) || event.Type != OrderAccepted {
+			t.Errorf("Event %d: unexpected %+v", i+1, event)
+		}
+	}
+	if after := readAll(t, path, 3); len(after) != 2 || after[0].Seq != 4 {
+		t.Errorf("Expected events 4 and 5, got %+v", after)
+	}
+}
+
+// failingWriter writes part of what it is given to the file and then fails
+type failingWriter struct {
+	file *os.File
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	n, _ := w.file.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestFailedPublishCanBeRetried(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	file, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := file.Publish(numbered(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	file.writer = bufio.NewWriterSize(failingWriter{file.file}, 64)
+	if err := file.Publish(numbered(3, 3)); err == nil {
+		t.Fatal("Expected the publish to fail")
+	}
+	if file.LastSeq() != 2 {
+		t.Errorf("Expected the failed batch not to count as published, last seq %d", file.LastSeq())
+	}
+	if events := readAll(t, path, 0); len(events) != 2 {
+		t.Errorf("Expected the partial batch cut off, got %d events", len(events))
+	}
+
+	if err := file.Publish(numbered(3, 3)); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	events := readAll(t, path, 0)
+	if len(events) != 5 {
+		t.Fatalf("Expected 5 events, got %d", len(events))
+	}
+	for i, event := range events {
+		if event.Seq != uint64(i+1) {
+			t.Errorf("Event %d has seq %d", i+1, event.Seq)
+		}
+	}
+}
+
+func TestFileRejectsDamageBeforeTheEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	os.WriteFile(path, []byte("{\"seq\":1}\nnot json\n{\"seq\":3}\n"), 0o644)
+
+	if _, err := OpenFile(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestMemoryKeepsNewestEvents(t *testing.T) {
+	memory := NewMemory(3)
+	memory.Publish(numbered(1, 2))
+	memory.Publish(numbered(3, 3))
+
+	if memory.LastSeq() != 5 {
+		t.Errorf("Expected last seq 5, got %d", memory.LastSeq())
+	}
+	kept := memory.Events(0, 0)
+	if len(kept) != 3 || kept[0].Seq != 3 {
+		t.Fatalf("Expected events 3 to 5, got %+v", kept)
+	}
+	if page := memory.Events(3, 1); len(page) != 1 || page[0].Seq != 4 {
+		t.Errorf("Expected event 4, got %+v", page)
+	}
+}
+
+func TestFanoutOnlyDeliversNewEvents(t *testing.T) {
+	ahead, behind := NewMemory(0), NewMemory(0)
+	ahead.Publish(numbered(1, 3))
+	fanout := NewFanout(ahead, behind)
+
+	if fanout.LastSeq() != 0 {
+		t.Errorf("Expected the fanout to start from the publisher behind, got %d", fanout.LastSeq())
+	}
+	if err := fanout.Publish(numbered(1, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if got := ahead.Events(0, 0); len(got) != 4 {
+		t.Errorf("Expected the publisher ahead to get event 4 only, holding 4 events, got %d", len(got))
+	}
+	if got := behind.Events(0, 0); len(got) != 4 {
+		t.Errorf("Expected the publisher behind to get all 4 events, got %d", len(got))
+	}
+}
@@ -0,0 +1,57 @@
+package events
+
+import (
+	"errors"
+)
+
+// Fanout publishes to several publishers. Each only gets the events above
+// its own LastSeq, so publishers that fell behind catch up on a replay
+// without the others seeing events twice.
+type Fanout struct {
+	publishers []Publisher
+}
+
+// NewFanout returns a Publisher delivering to every one of publishers
+func NewFanout(publishers ...Publisher) *Fanout {
+	return &Fanout{publishers: publishers}
+}
+
+func (f *Fanout) Publish(events []Event) error {
+	var errs []error
+	for _, p := range f.publishers {
+		last := p.LastSeq()
+		i := 0
+		for i < len(events) && events[i].Seq <= last {
+			i++
+		}
+		if i == len(events) {
+			continue
+		}
+		if err := p.Publish(events[i:]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LastSeq is the lowest LastSeq of the publishers, so a replay starts where
+// the one furthest behind needs it
+func (f *Fanout) LastSeq() uint64 {
+	var lowest uint64
+	for i, p := range f.publishers {
+		if last := p.LastSeq(); i == 0 || last < lowest {
+			lowest = last
+		}
+	}
+	return lowest
+}
+
+func (f *Fanout) Close() error {
+	var errs []error
+	for _, p := range f.publishers {
+		if err := p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,185 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrCorrupt means a line other than the last could not be decoded
+var ErrCorrupt = errors.New("event file corrupt")
+
+// File appends events to a file, one JSON object per line. Lines are
+// flushed after every Publish but not synced: a crash can lose the tail,
+// which the engine publishes again when it replays the journal.
+type File struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	lastSeq uint64
+	// failed is set when a failed write could not be cut back off
+	failed error
+}
+
+// OpenFile opens or creates the event file at path. A torn last line left
+// by a crash is cut off.
+func OpenFile(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastSeq uint64
+	end, err := scan(file, func(event Event) error {
+		lastSeq = event.Seq
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &File{file: file, writer: bufio.NewWriter(file), size: end, lastSeq: lastSeq}, nil
+}
+
+// Publish appends the events and flushes them. Either all of them are
+// written or, with an error, none are and the batch can be published again.
+func (f *File) Publish(events []Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	if f.failed != nil {
+		return f.failed
+	}
+	var written int64
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			f.writer.Reset(f.file)
+			return fmt.Errorf("encoding event %d: %w", event.Seq, err)
+		}
+		n, err := f.writer.Write(append(line, '\n'))
+		written += int64(n)
+		if err != nil {
+			return f.rollback(err)
+		}
+	}
+	if err := f.writer.Flush(); err != nil {
+		return f.rollback(err)
+	}
+	f.size += written
+	if len(events) > 0 {
+		f.lastSeq = events[len(events)-1].Seq
+	}
+	return nil
+}
+
+// rollback cuts off whatever part of a failed batch reached the file and
+// drops the rest, so the writer is clean for the batch to be published
+// again. If the file cannot be cut back, every later Publish fails.
+func (f *File) rollback(err error) error {
+	f.writer.Reset(f.file)
+	if truncErr := f.file.Truncate(f.size); truncErr != nil {
+		f.failed = fmt.Errorf("event file left with a partial batch: %w", truncErr)
+		return err
+	}
+	if _, seekErr := f.file.Seek(f.size, io.SeekStart); seekErr != nil {
+		f.failed = fmt.Errorf("event file left with a partial batch: %w", seekErr)
+	}
+	return err
+}
+
+func (f *File) LastSeq() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastSeq
+}
+
+// Close syncs and closes the file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.writer.Flush()
+	if syncErr := f.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file = nil
+	return err
+}
+
+// ReadFile calls fn for every event in the file at path with a sequence
+// number above after, stopping at the first error fn returns
+func ReadFile(path string, after uint64, fn func(Event) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = scan(file, func(event Event) error {
+		if event.Seq <= after {
+			return nil
+		}
+		return fn(event)
+	})
+	return err
+}
+
+// scan reads complete lines from the start of file and returns the offset
+// after the last one. An undecodable line is only accepted as the end of the
+// file.
+func scan(file *os.File, fn func(Event) error) (int64, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A line without its newline was cut short
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		var event Event
+		if jsonErr := json.Unmarshal(bytes.TrimSpace(line), &event); jsonErr != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("%w at offset %d: %v", ErrCorrupt, offset, jsonErr)
+		}
+		if err := fn(event); err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+	}
+}
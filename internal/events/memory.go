@@ -0,0 +1,58 @@
+package events
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory keeps the newest events in memory for consumers to poll
+type Memory struct {
+	mu       sync.Mutex
+	capacity int
+	events   []Event
+	lastSeq  uint64
+}
+
+// NewMemory returns a Memory keeping up to capacity events, or all events if
+// capacity is 0
+func NewMemory(capacity int) *Memory {
+	return &Memory{capacity: capacity, events: make([]Event, 0)}
+}
+
+func (m *Memory) Publish(events []Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, event := range events {
+		m.events = append(m.events, event)
+		m.lastSeq = event.Seq
+	}
+	if m.capacity > 0 && len(m.events) > m.capacity {
+		m.events = append([]Event{}, m.events[len(m.events)-m.capacity:]...)
+	}
+	return nil
+}
+
+func (m *Memory) LastSeq() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastSeq
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Events returns up to limit kept events with a sequence number above after,
+// oldest first; limit 0 returns all of them
+func (m *Memory) Events(after uint64, limit int) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := sort.Search(len(m.events), func(i int) bool { return m.events[i].Seq > after })
+	events := m.events[i:]
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]Event{}, events...)
+}
@@ -0,0 +1,293 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// A columnar file is
+//
+//	[6 magic "MECOLS"][2 schema version][4 crc32c][8 length][body]
+//
+// where the checksum covers the body. The body holds the number of columns,
+// each column's name and kind, the number of rows and then the values one
+// column at a time:
+//
+//	string  a dictionary of the distinct values, then an index per row
+//	float   8 bytes per row
+//	int     the zigzag varint difference from the previous row
+//	time    as int, of unix nanoseconds
+//	bool    a bitmap, one bit per row
+//
+// Counts, lengths and indexes are uvarints and fixed-width integers are big
+// endian.
+const (
+	columnarMagic      = "MECOLS"
+	columnarHeaderSize = 6 + 2 + 4 + 8
+)
+
+var (
+	ErrCorrupt = errors.New("columnar file corrupt")
+	ErrVersion = errors.New("unsupported schema version")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var kindCodes = map[Kind]byte{KindString: 1, KindFloat: 2, KindInt: 3, KindBool: 4, KindTime: 5}
+
+func encodeColumnar(table Table, rows [][]interface{}) ([]byte, error) {
+	var body bytes.Buffer
+	putUvarint(&body, uint64(len(table.Columns)))
+	for _, column := range table.Columns {
+		code, ok := kindCodes[column.Kind]
+		if !ok {
+			return nil, fmt.Errorf("column %s has unknown kind %q", column.Name, column.Kind)
+		}
+		putString(&body, column.Name)
+		body.WriteByte(code)
+	}
+	putUvarint(&body, uint64(len(rows)))
+
+	for i, column := range table.Columns {
+		values := make([]interface{}, len(rows))
+		for n, row := range rows {
+			if len(row) != len(table.Columns) {
+				return nil, fmt.Errorf("row %d has %d values, expected %d", n, len(row), len(table.Columns))
+			}
+			if err := checkValue(column.Kind, row[i]); err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %w", n, column.Name, err)
+			}
+			values[n] = row[i]
+		}
+		encodeColumn(&body, column.Kind, values)
+	}
+
+	header := make([]byte, columnarHeaderSize)
+	copy(header, columnarMagic)
+	binary.BigEndian.PutUint16(header[6:8], uint16(table.Version))
+	binary.BigEndian.PutUint32(header[8:12], crc32.Checksum(body.Bytes(), crcTable))
+	binary.BigEndian.PutUint64(header[12:20], uint64(body.Len()))
+	return append(header, body.Bytes()...), nil
+}
+
+func encodeColumn(buf *bytes.Buffer, kind Kind, values []interface{}) {
+	switch kind {
+	case KindString:
+		dictionary := make([]string, 0)
+		indexes := make(map[string]int)
+		for _, v := range values {
+			if _, ok := indexes[v.(string)]; !ok {
+				indexes[v.(string)] = len(dictionary)
+				dictionary = append(dictionary, v.(string))
+			}
+		}
+		putUvarint(buf, uint64(len(dictionary)))
+		for _, s := range dictionary {
+			putString(buf, s)
+		}
+		for _, v := range values {
+			putUvarint(buf, uint64(indexes[v.(string)]))
+		}
+	case KindFloat:
+		for _, v := range values {
+			binary.Write(buf, binary.BigEndian, math.Float64bits(v.(float64)))
+		}
+	case KindInt, KindTime:
+		var previous int64
+		for _, v := range values {
+			var n int64
+			if kind == KindTime {
+				n = v.(time.Time).UnixNano()
+			} else {
+				n = v.(int64)
+			}
+			buf.Write(binary.AppendVarint(nil, n-previous))
+			previous = n
+		}
+	case KindBool:
+		bitmap := make([]byte, (len(values)+7)/8)
+		for n, v := range values {
+			if v.(bool) {
+				bitmap[n/8] |= 1 << (n % 8)
+			}
+		}
+		buf.Write(bitmap)
+	}
+}
+
+// Columnar is the content of a columnar file
+type Columnar struct {
+	Version int
+	Columns []Column
+	Rows    [][]interface{}
+}
+
+// ReadColumnar reads the columnar file at path
+func ReadColumnar(path string) (*Columnar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < columnarHeaderSize || string(data[:6]) != columnarMagic {
+		return nil, fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	version := int(binary.BigEndian.Uint16(data[6:8]))
+	if version != SchemaVersion {
+		return nil, fmt.Errorf("%w %d", ErrVersion, version)
+	}
+	body := data[columnarHeaderSize:]
+	if uint64(len(body)) != binary.BigEndian.Uint64(data[12:20]) {
+		return nil, fmt.Errorf("%w: body is %d bytes, expected %d", ErrCorrupt, len(body), binary.BigEndian.Uint64(data[12:20]))
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(data[8:12]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	c, err := decodeColumnar(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	c.Version = version
+	return c, nil
+}
+
+func decodeColumnar(r *bytes.Reader) (*Columnar, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	c := &Columnar{Columns: make([]Column, 0, count)}
+	for i := uint64(0); i < count; i++ {
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		code, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		kind := Kind("")
+		for k, kc := range kindCodes {
+			if kc == code {
+				kind = k
+			}
+		}
+		if kind == "" {
+			return nil, fmt.Errorf("column %s has unknown kind %d", name, code)
+		}
+		c.Columns = append(c.Columns, Column{Name: name, Kind: kind})
+	}
+	rows, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	// Even a bitmap takes a byte per 8 rows
+	if rows > 8*uint64(r.Len()) {
+		return nil, fmt.Errorf("%d rows in %d bytes", rows, r.Len())
+	}
+
+	c.Rows = make([][]interface{}, rows)
+	for n := range c.Rows {
+		c.Rows[n] = make([]interface{}, len(c.Columns))
+	}
+	for i, column := range c.Columns {
+		if err := decodeColumn(r, column.Kind, c.Rows, i); err != nil {
+			return nil, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after the last column", r.Len())
+	}
+	return c, nil
+}
+
+func decodeColumn(r *bytes.Reader, kind Kind, rows [][]interface{}, i int) error {
+	switch kind {
+	case KindString:
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if size > uint64(r.Len()) {
+			return fmt.Errorf("dictionary of %d in %d bytes", size, r.Len())
+		}
+		dictionary := make([]string, size)
+		for k := range dictionary {
+			if dictionary[k], err = readString(r); err != nil {
+				return err
+			}
+		}
+		for _, row := range rows {
+			index, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			if index >= size {
+				return fmt.Errorf("index %d beyond a dictionary of %d", index, size)
+			}
+			row[i] = dictionary[index]
+		}
+	case KindFloat:
+		for _, row := range rows {
+			var bits uint64
+			if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+				return err
+			}
+			row[i] = math.Float64frombits(bits)
+		}
+	case KindInt, KindTime:
+		var previous int64
+		for _, row := range rows {
+			delta, err := binary.ReadVarint(r)
+			if err != nil {
+				return err
+			}
+			previous += delta
+			if kind == KindTime {
+				row[i] = time.Unix(0, previous).UTC()
+			} else {
+				row[i] = previous
+			}
+		}
+	case KindBool:
+		bitmap := make([]byte, (len(rows)+7)/8)
+		if _, err := io.ReadFull(r, bitmap); err != nil {
+			return err
+		}
+		for n, row := range rows {
+			row[i] = bitmap[n/8]&(1<<(n%8)) != 0
+		}
+	}
+	return nil
+}
+
+func putUvarint(buf *bytes.Buffer, n uint64) {
+	buf.Write(binary.AppendUvarint(nil, n))
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if length > uint64(r.Len()) {
+		return "", fmt.Errorf("string of %d bytes in %d", length, r.Len())
+	}
+	s := make([]byte, length)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
@@ -0,0 +1,56 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// encodeCSV writes a header of the column names and one line per row.
+// Times are RFC 3339 with nanoseconds in UTC.
+func encodeCSV(table Table, rows [][]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	record := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		record[i] = column.Name
+	}
+	if err := w.Write(record); err != nil {
+		return nil, err
+	}
+	for n, row := range rows {
+		if len(row) != len(table.Columns) {
+			return nil, fmt.Errorf("row %d has %d values, expected %d", n, len(row), len(table.Columns))
+		}
+		for i, column := range table.Columns {
+			if err := checkValue(column.Kind, row[i]); err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %w", n, column.Name, err)
+			}
+			record[i] = formatValue(row[i])
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
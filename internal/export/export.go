@@ -0,0 +1,208 @@
+// Package export writes trades, orders and positions out for analysis, as
+// CSV and as a compact columnar format. Rows are partitioned by UTC day and
+// asset, and every file carries the schema version it was written with:
+//
+//	<dir>/v<version>/<table>/schema.json
+//	<dir>/v<version>/<table>/day=<YYYY-MM-DD>/asset=<asset>/part.csv
+//	<dir>/v<version>/<table>/day=<YYYY-MM-DD>/asset=<asset>/part.col
+//
+// Asset names are escaped as URL path segments, with dots escaped too.
+//
+// Columns are only ever added at the end of a table within a version; any
+// other change to a schema bumps SchemaVersion, so readers never mistake one
+// layout for another.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the tables written by this package
+const SchemaVersion = 1
+
+// Kind is the type of a column. Values are string, float64, int64, bool and
+// time.Time respectively.
+type Kind string
+
+const (
+	KindString Kind = "string"
+	KindFloat  Kind = "float"
+	KindInt    Kind = "int"
+	KindBool   Kind = "bool"
+	KindTime   Kind = "time"
+)
+
+type Column struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+}
+
+// Table is the schema of a dataset
+type Table struct {
+	Name    string   `json:"name"`
+	Version int      `json:"version"`
+	Columns []Column `json:"columns"`
+}
+
+// Formats the files of a partition can be written in
+const (
+	FormatCSV      = "csv"
+	FormatColumnar = "columnar"
+)
+
+// Partition is one day of one asset
+type Partition struct {
+	Day   string
+	Asset string
+}
+
+// Dataset holds the rows of a table by partition
+type Dataset struct {
+	Table      Table
+	partitions map[Partition][][]interface{}
+}
+
+func NewDataset(table Table) *Dataset {
+	return &Dataset{Table: table, partitions: make(map[Partition][][]interface{})}
+}
+
+// Add adds a row to the partition of at's UTC day and asset
+func (d *Dataset) Add(at time.Time, asset string, row []interface{}) {
+	p := Partition{Day: at.UTC().Format("2006-01-02"), Asset: asset}
+	d.partitions[p] = append(d.partitions[p], row)
+}
+
+// Partitions returns the partitions holding rows, by day then asset
+func (d *Dataset) Partitions() []Partition {
+	partitions := make([]Partition, 0, len(d.partitions))
+	for p := range d.partitions {
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, k int) bool {
+		if partitions[i].Day != partitions[k].Day {
+			return partitions[i].Day < partitions[k].Day
+		}
+		return partitions[i].Asset < partitions[k].Asset
+	})
+	return partitions
+}
+
+// Rows returns the rows of a partition, in the order they were added
+func (d *Dataset) Rows(p Partition) [][]interface{} {
+	return d.partitions[p]
+}
+
+// Dir is the directory of a table's files under dir
+func (t Table) Dir(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("v%d", t.Version), t.Name)
+}
+
+// PartitionDir is the directory of a partition's files under dir. The asset
+// is escaped, so whatever it holds names one directory below the day's.
+func (t Table) PartitionDir(dir string, p Partition) string {
+	return filepath.Join(t.Dir(dir), "day="+url.PathEscape(p.Day), "asset="+escapeAsset(p.Asset))
+}
+
+// escapeAsset escapes an asset name for use as a path element. PathEscape
+// takes care of separators; backslashes and dots are escaped too, so no
+// name reads as a separator or a parent directory on any platform.
+func escapeAsset(asset string) string {
+	escaped := url.PathEscape(asset)
+	escaped = strings.ReplaceAll(escaped, `\`, "%5C")
+	return strings.ReplaceAll(escaped, ".", "%2E")
+}
+
+// Write writes the schema and every partition of d under dir in each of
+// formats and returns the paths of the files written. Existing files of the
+// same partitions are replaced.
+func (d *Dataset) Write(dir string, formats []string) ([]string, error) {
+	written := make([]string, 0)
+	schema, err := json.MarshalIndent(d.Table, "", "  ")
+	if err != nil {
+		return written, err
+	}
+	path := filepath.Join(d.Table.Dir(dir), "schema.json")
+	if err := writeFile(path, append(schema, '\n')); err != nil {
+		return written, err
+	}
+	written = append(written, path)
+
+	for _, p := range d.Partitions() {
+		for _, format := range formats {
+			var data []byte
+			var err error
+			switch format {
+			case FormatCSV:
+				path = filepath.Join(d.Table.PartitionDir(dir, p), "part.csv")
+				data, err = encodeCSV(d.Table, d.partitions[p])
+			case FormatColumnar:
+				path = filepath.Join(d.Table.PartitionDir(dir, p), "part.col")
+				data, err = encodeColumnar(d.Table, d.partitions[p])
+			default:
+				return written, fmt.Errorf("unknown export format %q", format)
+			}
+			if err != nil {
+				return written, fmt.Errorf("%s %s %s: %w", d.Table.Name, p.Day, p.Asset, err)
+			}
+			if err := writeFile(path, data); err != nil {
+				return written, err
+			}
+			written = append(written, path)
+		}
+	}
+	return written, nil
+}
+
+// writeFile writes data to a temporary file renamed into place, so readers
+// never see a partial file
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// checkValue reports whether v has the type of kind
+func checkValue(kind Kind, v interface{}) error {
+	ok := false
+	switch kind {
+	case KindString:
+		_, ok = v.(string)
+	case KindFloat:
+		_, ok = v.(float64)
+	case KindInt:
+		_, ok = v.(int64)
+	case KindBool:
+		_, ok = v.(bool)
+	case KindTime:
+		_, ok = v.(time.Time)
+	}
+	if !ok {
+		return fmt.Errorf("value %v (%T) is not a %s", v, v, kind)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"matching-engine/internal/events"
+	"matching-engine/internal/history"
+	"matching-engine/internal/margin"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+
+// tradingStore holds a BTC and an ETH trade on one day and a BTC trade on
+// the next, with the orders behind them
+func tradingStore(t *testing.T) *history.Store {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	seq := uint64(0)
+	trade := func(at time.Time, asset string, id string, price float64) {
+		orders := []events.Event{
+			{Type: events.OrderRested, OrderID: id + "-maker", Trader: "bob", Asset: asset, OrderType: "limit", Price: price, Amount: 1, Remaining: 1},
+			{Type: events.TradeExecuted, Asset: asset, Trade: &events.Trade{
+				TradeID: id, TakerOrderID: id + "-taker", MakerOrderID: id + "-maker",
+				Taker: "alice", Maker: "bob", Price: price, Amount: 1, TakerIsBuy: true, TakerFee: 0.5,
+			}},
+			{Type: events.OrderFilled, OrderID: id + "-maker", Trader: "bob", Asset: asset, OrderType: "limit", Price: price, Amount: 1, Filled: 1},
+			{Type: events.OrderFilled, OrderID: id + "-taker", Trader: "alice", Asset: asset, OrderType: "market", IsBuy: true, Amount: 1, Filled: 1},
+		}
+		for i := range orders {
+			seq++
+			orders[i].Seq = seq
+			orders[i].Time = at
+		}
+		if err := store.Publish(orders); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trade(day.Add(time.Hour), "BTC", "t1", 30000)
+	trade(day.Add(2*time.Hour), "ETH", "t2", 2000)
+	trade(day.Add(25*time.Hour), "BTC", "t3", 30100)
+	return store
+}
+
+func TestExportPartitionsByDayAndAsset(t *testing.T) {
+	store := tradingStore(t)
+	out := t.TempDir()
+
+	trades := TradeDataset(store, day, day.Add(24*time.Hour))
+	want := []Partition{{Day: "2026-05-04", Asset: "BTC"}, {Day: "2026-05-04", Asset: "ETH"}}
+	if got := trades.Partitions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected partitions %+v, got %+v", want, got)
+	}
+	if _, err := trades.Write(out, []string{FormatCSV, FormatColumnar}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := Trades.PartitionDir(out, want[0])
+	if dir != filepath.Join(out, "v1", "trades", "day=2026-05-04", "asset=BTC") {
+		t.Errorf("Unexpected partition directory %s", dir)
+	}
+	file, err := os.Open(filepath.Join(dir, "part.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0][1] != "trade_id" || records[1][1] != "t1" || records[1][2] != "2026-05-04T01:00:00Z" || records[1][10] != "true" {
+		t.Errorf("Unexpected CSV %v", records)
+	}
+
+	columnar, err := ReadColumnar(filepath.Join(dir, "part.col"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columnar.Version != SchemaVersion || !reflect.DeepEqual(columnar.Columns, Trades.Columns) {
+		t.Errorf("Unexpected schema %d %+v", columnar.Version, columnar.Columns)
+	}
+	if !reflect.DeepEqual(columnar.Rows, trades.Rows(want[0])) {
+		t.Errorf("Columnar rows differ:\nwrote %v\nread  %v", trades.Rows(want[0]), columnar.Rows)
+	}
+
+	// Orders fall on the day they were created
+	orders := OrderDataset(store, day.Add(24*time.Hour), time.Time{})
+	if got := orders.Partitions(); len(got) != 1 || got[0].Day != "2026-05-05" || len(orders.Rows(got[0])) != 2 {
+		t.Errorf("Expected the two orders of the second day, got %+v", got)
+	}
+}
+
+func TestColumnarRoundTripsEveryKind(t *testing.T) {
+	at := time.Date(2026, 5, 4, 12, 30, 0, 123, time.UTC)
+	positions := PositionDataset(at, []margin.Position{
+		{Trader: "alice", Asset: "BTC", Size: 1.5, EntryPrice: 30000, Leverage: 5},
+		{Trader: "bob", Asset: "BTC", Isolated: true, Size: -1.5, EntryPrice: 30010, Leverage: 2, RealizedPnL: -12.25},
+	})
+	out := t.TempDir()
+	if _, err := positions.Write(out, []string{FormatColumnar}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(Positions.PartitionDir(out, Partition{Day: "2026-05-04", Asset: "BTC"}), "part.col")
+	columnar, err := ReadColumnar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(columnar.Rows, positions.Rows(positions.Partitions()[0])) {
+		t.Errorf("Columnar rows differ: %v", columnar.Rows)
+	}
+
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	if _, err := ReadColumnar(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestPartitionDirStaysInsideTable(t *testing.T) {
+	out := t.TempDir()
+	for _, asset := range []string{"../../../etc", "..", ".", "a/b", `a\b`, "/abs", "BTC-USD"} {
+		dir := Trades.PartitionDir(out, Partition{Day: "2026-05-04", Asset: asset})
+		parent := filepath.Join(Trades.Dir(out), "day=2026-05-04")
+		if filepath.Dir(dir) != parent {
+			t.Errorf("Asset %q escaped its day's directory: %s", asset, dir)
+		}
+	}
+
+	positions := PositionDataset(day, []margin.Position{{Trader: "alice", Asset: "../../escape", Size: 1, EntryPrice: 30000, Leverage: 1}})
+	written, err := positions.Write(out, []string{FormatCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range written {
+		if rel, err := filepath.Rel(Positions.Dir(out), path); err != nil || strings.HasPrefix(rel, "..") {
+			t.Errorf("Wrote %s outside the table", path)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package export
+
+import (
+	"matching-engine/internal/history"
+	"matching-engine/internal/margin"
+	"time"
+)
+
+// Trades, Orders and Positions are the tables an export writes
+var (
+	Trades = Table{Name: "trades", Version: SchemaVersion, Columns: []Column{
+		{"seq", KindInt},
+		{"trade_id", KindString},
+		{"time", KindTime},
+		{"asset", KindString},
+		{"price", KindFloat},
+		{"amount", KindFloat},
+		{"taker", KindString},
+		{"maker", KindString},
+		{"taker_order_id", KindString},
+		{"maker_order_id", KindString},
+		{"taker_is_buy", KindBool},
+		{"venue", KindString},
+		{"taker_fee", KindFloat},
+		{"maker_fee", KindFloat},
+	}}
+	Orders = Table{Name: "orders", Version: SchemaVersion, Columns: []Column{
+		{"seq", KindInt},
+		{"order_id", KindString},
+		{"created_at", KindTime},
+		{"updated_at", KindTime},
+		{"trader", KindString},
+		{"asset", KindString},
+		{"is_buy", KindBool},
+		{"type", KindString},
+		{"price", KindFloat},
+		{"amount", KindFloat},
+		{"filled", KindFloat},
+		{"remaining", KindFloat},
+		{"status", KindString},
+		{"reason", KindString},
+	}}
+	Positions = Table{Name: "positions", Version: SchemaVersion, Columns: []Column{
+		{"time", KindTime},
+		{"trader", KindString},
+		{"asset", KindString},
+		{"isolated", KindBool},
+		{"size", KindFloat},
+		{"entry_price", KindFloat},
+		{"leverage", KindInt},
+		{"realized_pnl", KindFloat},
+	}}
+)
+
+// TradeDataset reads the trades executed in [from, to) from store,
+// partitioned by the day they executed. Zero times leave that end open.
+func TradeDataset(store *history.Store, from time.Time, to time.Time) *Dataset {
+	d := NewDataset(Trades)
+	q := history.Query{From: from, To: to, Limit: history.MaxLimit}
+	for {
+		page := store.Trades(q)
+		for _, trade := range page.Trades {
+			d.Add(trade.Time, trade.Asset, []interface{}{
+				int64(trade.Seq), trade.TradeID, trade.Time, trade.Asset, trade.Price, trade.Amount,
+				trade.Taker, trade.Maker, trade.TakerOrderID, trade.MakerOrderID, trade.TakerIsBuy,
+				trade.Venue, trade.TakerFee, trade.MakerFee,
+			})
+		}
+		if page.Next == 0 {
+			return d
+		}
+		q.After = page.Next
+	}
+}
+
+// OrderDataset reads the latest state of the orders created in [from, to)
+// from store, partitioned by the day they were created
+func OrderDataset(store *history.Store, from time.Time, to time.Time) *Dataset {
+	d := NewDataset(Orders)
+	q := history.Query{From: from, To: to, Limit: history.MaxLimit}
+	for {
+		page := store.Orders(q)
+		for _, order := range page.Orders {
+			d.Add(order.CreatedAt, order.Asset, []interface{}{
+				int64(order.Seq), order.OrderID, order.CreatedAt, order.UpdatedAt, order.Trader, order.Asset,
+				order.IsBuy, order.Type, order.Price, order.Amount, order.Filled, order.Remaining,
+				order.Status, order.Reason,
+			})
+		}
+		if page.Next == 0 {
+			return d
+		}
+		q.After = page.Next
+	}
+}
+
+// PositionDataset holds the positions as they were at a point in time,
+// partitioned by that day
+func PositionDataset(at time.Time, positions []margin.Position) *Dataset {
+	d := NewDataset(Positions)
+	for _, p := range positions {
+		d.Add(at, p.Asset, []interface{}{
+			at, p.Trader, p.Asset, p.Isolated, p.Size, p.EntryPrice, p.Leverage, p.RealizedPnL,
+		})
+	}
+	return d
+}
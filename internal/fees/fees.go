@@ -0,0 +1,222 @@
+package fees
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Liquidity tells which side of a fill a fee is charged for
+type Liquidity string
+
+const (
+	Maker Liquidity = "maker"
+	Taker Liquidity = "taker"
+	Pool  Liquidity = "pool"
+)
+
+// VolumeWindow is the trailing period used to place traders in a tier
+const VolumeWindow = 30 * 24 * time.Hour
+
+// Tier applies its rates to traders whose trailing volume is at least MinVolume.
+// A negative MakerRate is a rebate.
+type Tier struct {
+	MinVolume float64 `json:"min_volume"`
+	MakerRate float64 `json:"maker_rate"`
+	TakerRate float64 `json:"taker_rate"`
+}
+
+// Schedule holds the fee tiers of one instrument. PoolRate is charged to the
+// taker instead of the tier taker rate when the fill comes from the liquidity pool.
+type Schedule struct {
+	Instrument string  `json:"instrument"`
+	Tiers      []Tier  `json:"tiers"`
+	PoolRate   float64 `json:"pool_rate"`
+}
+
+// tierFor returns the highest tier the volume qualifies for
+func (s Schedule) tierFor(volume float64) Tier {
+	tier := Tier{}
+	for _, t := range s.Tiers {
+		if volume >= t.MinVolume {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// Calculator resolves fee rates from schedules and trailing trader volume
+type Calculator struct {
+	mu              sync.Mutex
+	schedules       map[string]Schedule
+	defaultSchedule Schedule
+	volumes         map[string]map[int64]float64 // trader -> day -> notional
+	now             func() time.Time
+}
+
+func NewCalculator(defaultSchedule Schedule) *Calculator {
+	c := &Calculator{
+		schedules: make(map[string]Schedule),
+		volumes:   make(map[string]map[int64]float64),
+		now:       time.Now,
+	}
+	c.defaultSchedule = normalize(defaultSchedule)
+	return c
+}
+
+// SetClock replaces the clock volume is bucketed by
+func (c *Calculator) SetClock(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// SetSchedule sets the schedule of the instrument named in s
+func (c *Calculator) SetSchedule(s Schedule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedules[s.Instrument] = normalize(s)
+}
+
+// Schedule returns the schedule applied to an instrument
+func (c *Calculator) Schedule(instrument string) Schedule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.schedule(instrument)
+}
+
+// Schedules returns the instrument specific schedules and the default one
+func (c *Calculator) Schedules() (map[string]Schedule, Schedule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	schedules := make(map[string]Schedule, len(c.schedules))
+	for instrument, s := range c.schedules {
+		schedules[instrument] = s
+	}
+	return schedules, c.defaultSchedule
+}
+
+// RecordVolume adds traded notional to a trader's trailing volume
+func (c *Calculator) RecordVolume(trader string, notional float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	days, ok := c.volumes[trader]
+	if !ok {
+		days = make(map[int64]float64)
+		c.volumes[trader] = days
+	}
+	today := c.day(c.now())
+	days[today] += notional
+
+	// Drop buckets that fell out of the window
+	for day := range days {
+		if day <= today-int64(VolumeWindow/(24*time.Hour)) {
+			delete(days, day)
+		}
+	}
+}
+
+// Volumes returns the daily notional of every trader, keyed by trader and
+// day number
+func (c *Calculator) Volumes() map[string]map[int64]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	volumes := make(map[string]map[int64]float64, len(c.volumes))
+	for trader, days := range c.volumes {
+		volumes[trader] = make(map[int64]float64, len(days))
+		for day, notional := range days {
+			volumes[trader][day] = notional
+		}
+	}
+	return volumes
+}
+
+// RestoreSchedules replaces the instrument specific schedules with schedules
+// as returned by Schedules. The default schedule is left alone.
+func (c *Calculator) RestoreSchedules(schedules map[string]Schedule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.schedules = make(map[string]Schedule, len(schedules))
+	for instrument, s := range schedules {
+		c.schedules[instrument] = normalize(s)
+	}
+}
+
+// RestoreVolumes replaces the trailing volume of every trader with volumes
+// as returned by Volumes
+func (c *Calculator) RestoreVolumes(volumes map[string]map[int64]float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.volumes = make(map[string]map[int64]float64, len(volumes))
+	for trader, days := range volumes {
+		c.volumes[trader] = make(map[int64]float64, len(days))
+		for day, notional := range days {
+			c.volumes[trader][day] = notional
+		}
+	}
+}
+
+// Volume returns a trader's notional traded over the trailing window
+func (c *Calculator) Volume(trader string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.volume(trader)
+}
+
+// Rate returns the fee rate charged to a trader for one side of a fill
+func (c *Calculator) Rate(trader string, instrument string, liquidity Liquidity) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.schedule(instrument)
+	switch liquidity {
+	case Pool:
+		return s.PoolRate
+	case Maker:
+		return s.tierFor(c.volume(trader)).MakerRate
+	default:
+		return s.tierFor(c.volume(trader)).TakerRate
+	}
+}
+
+// must be called with c.mu held
+func (c *Calculator) schedule(instrument string) Schedule {
+	if s, ok := c.schedules[instrument]; ok {
+		return s
+	}
+	s := c.defaultSchedule
+	s.Instrument = instrument
+	return s
+}
+
+// must be called with c.mu held
+func (c *Calculator) volume(trader string) float64 {
+	oldest := c.day(c.now().Add(-VolumeWindow))
+	total := 0.0
+	for day, notional := range c.volumes[trader] {
+		if day > oldest {
+			total += notional
+		}
+	}
+	return total
+}
+
+func (c *Calculator) day(t time.Time) int64 {
+	return t.UTC().Unix() / int64((24 * time.Hour).Seconds())
+}
+
+// normalize sorts tiers by volume so tierFor can pick the last match
+func normalize(s Schedule) Schedule {
+	tiers := make([]Tier, len(s.Tiers))
+	copy(tiers, s.Tiers)
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].MinVolume < tiers[j].MinVolume
+	})
+	s.Tiers = tiers
+	return s
+}
@@ -0,0 +1,51 @@
+package fees
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateFollowsVolumeTiers(t *testing.T) {
+	c := NewCalculator(Schedule{
+		Tiers: []Tier{
+			{MinVolume: 1000, MakerRate: -0.0001, TakerRate: 0.0003},
+			{MinVolume: 0, MakerRate: 0.0002, TakerRate: 0.0005},
+		},
+		PoolRate: 0.001,
+	})
+
+	if rate := c.Rate("alice", "BTC", Taker); rate != 0.0005 {
+		t.Errorf("Expected base taker rate, got %f", rate)
+	}
+	if rate := c.Rate("alice", "BTC", Pool); rate != 0.001 {
+		t.Errorf("Expected pool rate, got %f", rate)
+	}
+
+	c.RecordVolume("alice", 1500)
+	if rate := c.Rate("alice", "BTC", Maker); rate != -0.0001 {
+		t.Errorf("Expected maker rebate after reaching tier, got %f", rate)
+	}
+
+	c.SetSchedule(Schedule{Instrument: "ETH", Tiers: []Tier{{TakerRate: 0.002}}})
+	if rate := c.Rate("alice", "ETH", Taker); rate != 0.002 {
+		t.Errorf("Expected instrument schedule to apply, got %f", rate)
+	}
+}
+
+func TestVolumeWindowExpires(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCalculator(Schedule{})
+	c.now = func() time.Time { return now }
+
+	c.RecordVolume("bob", 500)
+	now = now.Add(29 * 24 * time.Hour)
+	c.RecordVolume("bob", 200)
+	if volume := c.Volume("bob"); volume != 700 {
+		t.Errorf("Expected 700 within window, got %f", volume)
+	}
+
+	now = now.Add(2 * 24 * time.Hour)
+	if volume := c.Volume("bob"); volume != 200 {
+		t.Errorf("Expected old volume to expire, got %f", volume)
+	}
+}
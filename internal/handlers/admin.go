@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"matching-engine/internal/account"
+	"matching-engine/internal/fees"
+	"matching-engine/internal/ledger"
+	"matching-engine/internal/risk"
+	"matching-engine/pkg/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (h *Handler) getInsuranceFund(w http.ResponseWriter, r *http.Request) {
+	var afterSeq uint64
+	if after := r.URL.Query().Get("after"); after != "" {
+		parsed, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return
+		}
+		afterSeq = parsed
+	}
+
+	fund := h.engine.InsuranceFund()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"balances": fund.Balances(),
+		"events":   fund.Events(afterSeq),
+	})
+}
+
+func (h *Handler) getADLQueue(w http.ResponseWriter, r *http.Request) {
+	asset := r.URL.Query().Get("asset")
+	if asset == "" {
+		http.Error(w, "Missing asset parameter", http.StatusBadRequest)
+		return
+	}
+
+	var isLong bool
+	switch r.URL.Query().Get("side") {
+	case "long":
+		isLong = true
+	case "short":
+		isLong = false
+	default:
+		http.Error(w, "Side must be long or short", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"asset": asset,
+		"side":  r.URL.Query().Get("side"),
+		"queue": h.engine.ADLQueue(asset, isLong),
+	})
+}
+
+// liquidate settles a filled liquidation against the insurance fund and the
+// ADL queue
+func (h *Handler) liquidate(w http.ResponseWriter, r *http.Request) {
+	var liq risk.Liquidation
+	if err := json.NewDecoder(r.Body).Decode(&liq); err != nil {
+		utils.Logger.Error("Failed to decode request", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	outcome, err := h.engine.Liquidate(liq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outcome)
+}
+
+type balanceRequest struct {
+	Trader string  `json:"trader"`
+	Asset  string  `json:"asset"`
+	Amount float64 `json:"amount"`
+}
+
+func (h *Handler) getBalances(w http.ResponseWriter, r *http.Request) {
+	accounts := h.engine.Accounts()
+	if accounts == nil {
+		http.Error(w, "Accounts are not enabled", http.StatusNotFound)
+		return
+	}
+	trader := r.URL.Query().Get("trader")
+	if trader == "" {
+		http.Error(w, "Missing trader parameter", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"trader":   trader,
+		"balances": accounts.Balances(trader),
+	})
+}
+
+func (h *Handler) deposit(w http.ResponseWriter, r *http.Request) {
+	h.moveFunds(w, r, func(req balanceRequest) error {
+		return h.engine.Deposit(req.Trader, req.Asset, req.Amount)
+	})
+}
+
+func (h *Handler) withdraw(w http.ResponseWriter, r *http.Request) {
+	h.moveFunds(w, r, func(req balanceRequest) error {
+		return h.engine.Withdraw(req.Trader, req.Asset, req.Amount)
+	})
+}
+
+func (h *Handler) moveFunds(w http.ResponseWriter, r *http.Request, move func(balanceRequest) error) {
+	accounts := h.engine.Accounts()
+	if accounts == nil {
+		http.Error(w, "Accounts are not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req balanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.Logger.Error("Failed to decode request", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Trader == "" || req.Asset == "" {
+		http.Error(w, "Trader and asset are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := move(req); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, account.ErrInsufficientFunds) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"trader":  req.Trader,
+		"asset":   req.Asset,
+		"balance": accounts.Balance(req.Trader, req.Asset),
+	})
+}
+
+func (h *Handler) getFeeSchedules(w http.ResponseWriter, r *http.Request) {
+	calculator := h.engine.FeeCalculator()
+	schedules, defaultSchedule := calculator.Schedules()
+
+	response := map[string]interface{}{
+		"default":     defaultSchedule,
+		"instruments": schedules,
+	}
+	if trader := r.URL.Query().Get("trader"); trader != "" {
+		response["trader"] = trader
+		response["volume_30d"] = calculator.Volume(trader)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) setFeeSchedule(w http.ResponseWriter, r *http.Request) {
+	var schedule fees.Schedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		utils.Logger.Error("Failed to decode request", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if schedule.Instrument == "" {
+		http.Error(w, "Instrument is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.engine.SetFeeSchedule(schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.engine.FeeCalculator().Schedule(schedule.Instrument))
+}
+
+func (h *Handler) getTrialBalance(w http.ResponseWriter, r *http.Request) {
+	accounts := h.engine.Accounts()
+	if accounts == nil {
+		http.Error(w, "Ledger is not enabled", http.StatusNotFound)
+		return
+	}
+	tb, ok := accounts.Reconcile()
+	if !ok {
+		http.Error(w, "Ledger is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tb)
+}
+
+func (h *Handler) getStatement(w http.ResponseWriter, r *http.Request) {
+	journal := h.ledger()
+	if journal == nil {
+		http.Error(w, "Ledger is not enabled", http.StatusNotFound)
+		return
+	}
+	name := r.URL.Query().Get("account")
+	if name == "" {
+		http.Error(w, "Missing account parameter", http.StatusBadRequest)
+		return
+	}
+
+	var from, to time.Time
+	for param, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid "+param+" parameter, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+		*target = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(journal.Statement(name, from, to))
+}
+
+// ledger returns the journal behind the engine's accounts, if enabled
+func (h *Handler) ledger() *ledger.Ledger {
+	accounts := h.engine.Accounts()
+	if accounts == nil {
+		return nil
+	}
+	return accounts.Ledger()
+}
+
+func (h *Handler) getPortfolio(w http.ResponseWriter, r *http.Request) {
+	manager := h.engine.Margin()
+	if manager == nil {
+		http.Error(w, "Margin is not enabled", http.StatusNotFound)
+		return
+	}
+	trader := r.URL.Query().Get("trader")
+	if trader == "" {
+		http.Error(w, "Missing trader parameter", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manager.Evaluate(trader))
+}
+
+func (h *Handler) getHedgeBook(w http.ResponseWriter, r *http.Request) {
+	hedger := h.engine.Hedger()
+	if hedger == nil {
+		http.Error(w, "Hedging is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hedger.Report())
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// getBookChecksums returns the book checksum of one asset, or of every book
+// that has changed. Clients keeping a book from the event stream compare it
+// with the checksum of the last delta they applied.
+func (h *Handler) getBookChecksums(w http.ResponseWriter, r *http.Request) {
+	checksums := h.engine.BookChecksums()
+	if asset := r.URL.Query().Get("asset"); asset != "" {
+		checksum, ok := checksums[asset]
+		if !ok {
+			http.Error(w, "No book for asset", http.StatusNotFound)
+			return
+		}
+		checksums = map[string]uint32{asset: checksum}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checksums)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"matching-engine/internal/history"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetHistory serves past orders and trades from store
+func (h *Handler) SetHistory(store *history.Store) {
+	h.history = store
+}
+
+func (h *Handler) getOrders(w http.ResponseWriter, r *http.Request) {
+	if h.history == nil {
+		http.Error(w, "History is not enabled", http.StatusNotFound)
+		return
+	}
+	q, ok := historyQuery(w, r)
+	if !ok {
+		return
+	}
+	q.Status = r.URL.Query().Get("status")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.history.Orders(q))
+}
+
+func (h *Handler) getTrades(w http.ResponseWriter, r *http.Request) {
+	if h.history == nil {
+		http.Error(w, "History is not enabled", http.StatusNotFound)
+		return
+	}
+	q, ok := historyQuery(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.history.Trades(q))
+}
+
+// historyQuery reads the filters and pagination shared by the history
+// endpoints, answering the request itself if they are invalid
+func historyQuery(w http.ResponseWriter, r *http.Request) (history.Query, bool) {
+	params := r.URL.Query()
+	q := history.Query{
+		Trader:  params.Get("trader"),
+		Asset:   params.Get("asset"),
+		OrderID: params.Get("order_id"),
+	}
+
+	for param, target := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid "+param+" parameter, expected RFC3339", http.StatusBadRequest)
+			return q, false
+		}
+		*target = parsed
+	}
+	if after := params.Get("after"); after != "" {
+		parsed, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return q, false
+		}
+		q.After = parsed
+	}
+	if limit := params.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 || parsed > history.MaxLimit {
+			http.Error(w, "Limit must be between 1 and "+strconv.Itoa(history.MaxLimit), http.StatusBadRequest)
+			return q, false
+		}
+		q.Limit = parsed
+	}
+	return q, true
+}
@@ -2,14 +2,20 @@ package handlers
 
 import (
     "encoding/json"
+    "errors"
     "github.com/gorilla/mux"
     "matching-engine/internal/engine"
+    "matching-engine/internal/engine/liquiditypool"
+    "matching-engine/internal/history"
+    "matching-engine/internal/replication"
     "matching-engine/pkg/utils"
     "net/http"
 )
 
 type Handler struct {
-    engine *engine.MatchingEngine
+    engine      *engine.MatchingEngine
+    replication replication.Node
+    history     *history.Store
 }
 
 func NewHandler(e *engine.MatchingEngine) *Handler {
@@ -19,10 +25,64 @@ func NewHandler(e *engine.MatchingEngine) *Handler {
 func (h *Handler) SetupRoutes(r *mux.Router) {
     r.HandleFunc("/api/health", h.healthCheck).Methods("GET")
     r.HandleFunc("/api/order", h.createOrder).Methods("POST")
+    r.HandleFunc("/api/order/{id}", h.getOrder).Methods("GET")
+    r.HandleFunc("/api/order/{id}", h.cancelOrder).Methods("DELETE")
+    r.HandleFunc("/api/order/{id}", h.amendOrder).Methods("PATCH")
+    r.HandleFunc("/api/orders", h.getOrders).Methods("GET")
+    r.HandleFunc("/api/orders/open", h.getOpenOrders).Methods("GET")
+    r.HandleFunc("/api/trades", h.getTrades).Methods("GET")
+    r.HandleFunc("/api/book/checksums", h.getBookChecksums).Methods("GET")
+    r.HandleFunc("/api/admin/insurance", h.getInsuranceFund).Methods("GET")
+    r.HandleFunc("/api/admin/adl", h.getADLQueue).Methods("GET")
+    r.HandleFunc("/api/admin/liquidations", h.liquidate).Methods("POST")
+    r.HandleFunc("/api/admin/balances", h.getBalances).Methods("GET")
+    r.HandleFunc("/api/admin/deposit", h.deposit).Methods("POST")
+    r.HandleFunc("/api/admin/withdraw", h.withdraw).Methods("POST")
+    r.HandleFunc("/api/admin/fees", h.getFeeSchedules).Methods("GET")
+    r.HandleFunc("/api/admin/fees", h.setFeeSchedule).Methods("PUT")
+    r.HandleFunc("/api/admin/ledger/trial-balance", h.getTrialBalance).Methods("GET")
+    r.HandleFunc("/api/admin/ledger/statement", h.getStatement).Methods("GET")
+    r.HandleFunc("/api/admin/margin", h.getPortfolio).Methods("GET")
+    r.HandleFunc("/api/admin/hedge", h.getHedgeBook).Methods("GET")
+    r.HandleFunc("/api/admin/replication", h.getReplication).Methods("GET")
+    r.HandleFunc("/api/admin/promote", h.promote).Methods("POST")
 }
 
+// breakerStats is implemented by liquidity pool clients wrapped in a breaker
+type breakerStats interface {
+    Stats() liquiditypool.BreakerStats
+}
+
+// healthCheck reports the breaker of every venue the router can send pool
+// fills to; the default venue is also kept under liquidity_pool
 func (h *Handler) healthCheck(w http.ResponseWriter, r *http.Request) {
-    json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+    health := map[string]interface{}{"status": "ok"}
+    venues := make(map[string]liquiditypool.BreakerStats)
+    for _, venue := range h.engine.Router().Venues() {
+        breaker, ok := venue.Client.(breakerStats)
+        if !ok {
+            continue
+        }
+        stats := breaker.Stats()
+        venues[venue.Name] = stats
+        if venue.Name == engine.DefaultVenue {
+            health["liquidity_pool"] = stats
+        }
+        if stats.State != liquiditypool.BreakerClosed {
+            health["status"] = "degraded"
+        }
+    }
+    if len(venues) > 0 {
+        health["venues"] = venues
+    }
+    if h.replication != nil {
+        status := h.replication.Status()
+        health["role"] = status.Role
+        if status.Fenced {
+            health["status"] = "fenced"
+        }
+    }
+    json.NewEncoder(w).Encode(health)
 }
 
 func (h *Handler) createOrder(w http.ResponseWriter, r *http.Request) {
@@ -66,4 +126,76 @@ func (h *Handler) createOrder(w http.ResponseWriter, r *http.Request) {
     if !result.Success {
         utils.LogMatchResult(order.ID, result.Message)
     }
-}
\ No newline at end of file
+}
+
+func (h *Handler) getOrder(w http.ResponseWriter, r *http.Request) {
+    status, ok := h.engine.OrderStatus(mux.Vars(r)["id"])
+    if !ok {
+        http.Error(w, "Order not found", http.StatusNotFound)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(status)
+}
+
+func (h *Handler) getOpenOrders(w http.ResponseWriter, r *http.Request) {
+    trader := r.URL.Query().Get("trader")
+    if trader == "" {
+        http.Error(w, "Missing trader parameter", http.StatusBadRequest)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(h.engine.OpenOrders(trader))
+}
+
+func (h *Handler) cancelOrder(w http.ResponseWriter, r *http.Request) {
+    orderID := mux.Vars(r)["id"]
+
+    order, err := h.engine.CancelOrder(orderID)
+    if errors.Is(err, engine.ErrOrderNotFound) {
+        http.Error(w, "Order not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        utils.LogError(err)
+        http.Error(w, "Order could not be cancelled", http.StatusServiceUnavailable)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "order_id":         order.ID,
+        "cancelled_amount": order.Amount - order.FilledAmount,
+    })
+}
+
+func (h *Handler) amendOrder(w http.ResponseWriter, r *http.Request) {
+    orderID := mux.Vars(r)["id"]
+
+    var amendReq struct {
+        Price  float64 `json:"price"`
+        Amount float64 `json:"amount"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&amendReq); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    order, err := h.engine.AmendOrder(orderID, amendReq.Price, amendReq.Amount)
+    switch {
+    case errors.Is(err, engine.ErrOrderNotFound):
+        http.Error(w, "Order not found", http.StatusNotFound)
+        return
+    case errors.Is(err, engine.ErrInvalidAmend), errors.Is(err, engine.ErrWouldCross):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    case err != nil:
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(order)
+}
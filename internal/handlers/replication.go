@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"matching-engine/internal/replication"
+	"matching-engine/pkg/utils"
+	"net/http"
+)
+
+// SetReplication reports on and promotes node through the admin API
+func (h *Handler) SetReplication(node replication.Node) {
+	h.replication = node
+}
+
+func (h *Handler) getReplication(w http.ResponseWriter, r *http.Request) {
+	if h.replication == nil {
+		http.Error(w, "Replication is not configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.replication.Status())
+}
+
+func (h *Handler) promote(w http.ResponseWriter, r *http.Request) {
+	if h.replication == nil {
+		http.Error(w, "Replication is not configured", http.StatusNotFound)
+		return
+	}
+	if err := h.replication.Promote(); err != nil {
+		utils.LogError(err)
+		http.Error(w, "Promotion failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.replication.Status())
+}
@@ -0,0 +1,343 @@
+// Package hedge offsets the exposure the house takes on when users trade
+// against the liquidity pool. Pool fills and hedge trades share one position
+// per asset, so the P&L of the hedge book is the P&L of that position.
+package hedge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"matching-engine/internal/engine/liquiditypool"
+	"matching-engine/internal/margin"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HouseAccount is the position holder of the hedge book
+const HouseAccount = "house"
+
+// DefaultInterval is how often Run checks exposure without new pool fills
+const DefaultInterval = time.Second
+
+// Config sets when exposure is hedged
+type Config struct {
+	// Thresholds maps an asset to the absolute net exposure, in base units,
+	// above which it is hedged back to flat. Assets not listed use
+	// DefaultThreshold; a threshold of 0 never hedges.
+	Thresholds       map[string]float64
+	DefaultThreshold float64
+	// Timeout bounds each call to the hedge venue
+	Timeout time.Duration
+}
+
+// Trade is an order the hedger placed with the hedge venue
+type Trade struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Asset   string    `json:"asset"`
+	IsBuy   bool      `json:"is_buy"`
+	Amount  float64   `json:"amount"`
+	Price   float64   `json:"price"`
+	QuoteID string    `json:"quote_id"`
+}
+
+// AssetReport is the hedge book of one asset
+type AssetReport struct {
+	Asset         string  `json:"asset"`
+	NetExposure   float64 `json:"net_exposure"`
+	EntryPrice    float64 `json:"entry_price"`
+	MarkPrice     float64 `json:"mark_price"`
+	Threshold     float64 `json:"threshold"`
+	PoolVolume    float64 `json:"pool_volume"`
+	HedgedVolume  float64 `json:"hedged_volume"`
+	RealizedPnL   float64 `json:"realized_pnl"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+}
+
+// Report is the state of the hedge book
+type Report struct {
+	Assets        []AssetReport `json:"assets"`
+	Trades        []Trade       `json:"trades"`
+	RealizedPnL   float64       `json:"realized_pnl"`
+	UnrealizedPnL float64       `json:"unrealized_pnl"`
+}
+
+// Hedger tracks house exposure from pool fills and trades it away at the
+// hedge venue once it crosses the asset's threshold
+type Hedger struct {
+	venue  liquiditypool.LiquidityPoolClient
+	prices margin.PriceSource
+	config Config
+	book   *margin.PositionBook
+
+	mu           sync.Mutex
+	poolVolume   map[string]float64
+	hedgedVolume map[string]float64
+	realizedPnL  map[string]float64
+	trades       []Trade
+	seq          uint64
+	trigger      chan struct{}
+	// record books a hedge trade in place of ApplyTrade, see SetRecorder
+	record func(Trade)
+	// hedging serializes Rebalance so an asset is never hedged twice at once
+	hedging sync.Mutex
+	now     func() time.Time
+}
+
+func NewHedger(venue liquiditypool.LiquidityPoolClient, prices margin.PriceSource, config Config) *Hedger {
+	if config.Timeout <= 0 {
+		config.Timeout = liquiditypool.DefaultTimeout
+	}
+	return &Hedger{
+		venue:        venue,
+		prices:       prices,
+		config:       config,
+		book:         margin.NewPositionBook(),
+		poolVolume:   make(map[string]float64),
+		hedgedVolume: make(map[string]float64),
+		realizedPnL:  make(map[string]float64),
+		trades:       make([]Trade, 0),
+		trigger:      make(chan struct{}, 1),
+		now:          time.Now,
+	}
+}
+
+// RecordPoolFill books the house side of a user fill against the pool: the
+// house sells what the user buys and buys what the user sells. It never calls
+// the venue, so it is safe to call while matching.
+func (h *Hedger) RecordPoolFill(asset string, userIsBuy bool, amount float64, price float64) {
+	if amount <= 0 {
+		return
+	}
+	quantity := amount
+	if userIsBuy {
+		quantity = -amount
+	}
+	change := h.book.Apply(HouseAccount, asset, false, quantity, price, 1)
+
+	h.mu.Lock()
+	h.poolVolume[asset] += amount
+	h.realizedPnL[asset] += change.Realized
+	h.mu.Unlock()
+
+	select {
+	case h.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Exposure returns the house's net position in asset; positive is long
+func (h *Hedger) Exposure(asset string) float64 {
+	p, _ := h.book.Position(HouseAccount, asset, false)
+	return p.Size
+}
+
+// Run rebalances after pool fills and every interval until ctx is done
+func (h *Hedger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.trigger:
+		case <-ticker.C:
+		}
+		h.Rebalance()
+	}
+}
+
+// Rebalance hedges every asset whose exposure exceeds its threshold and
+// returns the trades placed. Failed hedges are retried on the next call.
+func (h *Hedger) Rebalance() []Trade {
+	h.hedging.Lock()
+	defer h.hedging.Unlock()
+
+	placed := make([]Trade, 0)
+	for _, p := range h.book.TraderPositions(HouseAccount) {
+		threshold := h.threshold(p.Asset)
+		if threshold <= 0 || math.Abs(p.Size) <= threshold {
+			continue
+		}
+		trade, err := h.hedge(p.Asset, p.Size < 0, math.Abs(p.Size))
+		if err != nil {
+			continue
+		}
+		placed = append(placed, trade)
+	}
+	return placed
+}
+
+// hedge trades amount of asset at the venue and books the execution
+func (h *Hedger) hedge(asset string, isBuy bool, amount float64) (Trade, error) {
+	h.mu.Lock()
+	h.seq++
+	orderID := fmt.Sprintf("hedge-%d", h.seq)
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	quote, err := h.venue.RequestQuote(ctx, asset, isBuy, amount, 0)
+	cancel()
+	if err != nil {
+		return Trade{}, fmt.Errorf("quoting hedge for %s: %w", asset, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), h.config.Timeout)
+	execution, err := h.venue.ExecuteQuote(ctx, quote.ID, orderID)
+	cancel()
+	if err != nil && !errors.Is(err, liquiditypool.ErrRejected) {
+		// The hedge may have traded; ask before trying again
+		ctx, cancel = context.WithTimeout(context.Background(), h.config.Timeout)
+		execution, err = h.venue.TradeStatus(ctx, orderID)
+		cancel()
+	}
+	if err != nil {
+		return Trade{}, fmt.Errorf("executing hedge %s: %w", orderID, err)
+	}
+	if execution.Status != liquiditypool.ExecutionFilled || execution.FilledAmount <= 0 {
+		return Trade{}, fmt.Errorf("hedge %s not filled: %s", orderID, execution.Status)
+	}
+
+	trade := Trade{
+		ID:      orderID,
+		Time:    h.now(),
+		Asset:   asset,
+		IsBuy:   isBuy,
+		Amount:  execution.FilledAmount,
+		Price:   execution.Price,
+		QuoteID: quote.ID,
+	}
+	h.mu.Lock()
+	record := h.record
+	h.mu.Unlock()
+	if record != nil {
+		record(trade)
+	} else {
+		h.ApplyTrade(trade)
+	}
+	return trade, nil
+}
+
+// SetRecorder makes the hedger pass each hedge trade to record instead of
+// booking it itself. record must book the trade with ApplyTrade; the engine
+// uses it to journal the trade and book it in one step.
+func (h *Hedger) SetRecorder(record func(Trade)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.record = record
+}
+
+// ApplyTrade books a hedge trade that has already executed at the venue, as
+// hedge does and as replay does with journaled trades
+func (h *Hedger) ApplyTrade(trade Trade) {
+	quantity := trade.Amount
+	if !trade.IsBuy {
+		quantity = -quantity
+	}
+	change := h.book.Apply(HouseAccount, trade.Asset, false, quantity, trade.Price, 1)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hedgedVolume[trade.Asset] += trade.Amount
+	h.realizedPnL[trade.Asset] += change.Realized
+	h.trades = append(h.trades, trade)
+	var seq uint64
+	if _, err := fmt.Sscanf(trade.ID, "hedge-%d", &seq); err == nil && seq > h.seq {
+		h.seq = seq
+	}
+}
+
+// State is what a snapshot keeps of the hedger
+type State struct {
+	Positions    []margin.Position
+	PoolVolume   map[string]float64
+	HedgedVolume map[string]float64
+	RealizedPnL  map[string]float64
+	Trades       []Trade
+	Seq          uint64
+}
+
+// State copies the hedge book
+func (h *Hedger) State() State {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return State{
+		Positions:    h.book.All(),
+		PoolVolume:   copyTotals(h.poolVolume),
+		HedgedVolume: copyTotals(h.hedgedVolume),
+		RealizedPnL:  copyTotals(h.realizedPnL),
+		Trades:       append([]Trade(nil), h.trades...),
+		Seq:          h.seq,
+	}
+}
+
+// Restore replaces the hedge book with a copy taken by State
+func (h *Hedger) Restore(s State) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.book.Restore(s.Positions)
+	h.poolVolume = copyTotals(s.PoolVolume)
+	h.hedgedVolume = copyTotals(s.HedgedVolume)
+	h.realizedPnL = copyTotals(s.RealizedPnL)
+	h.trades = append(make([]Trade, 0, len(s.Trades)), s.Trades...)
+	h.seq = s.Seq
+}
+
+func copyTotals(volumes map[string]float64) map[string]float64 {
+	copied := make(map[string]float64, len(volumes))
+	for asset, volume := range volumes {
+		copied[asset] = volume
+	}
+	return copied
+}
+
+// Report returns exposure and P&L per asset and every hedge trade. Open
+// exposure is marked at the current price.
+func (h *Hedger) Report() Report {
+	h.mu.Lock()
+	names := make([]string, 0, len(h.poolVolume))
+	for asset := range h.poolVolume {
+		names = append(names, asset)
+	}
+	sort.Strings(names)
+
+	report := Report{
+		Assets: make([]AssetReport, 0, len(names)),
+		Trades: append([]Trade(nil), h.trades...),
+	}
+	for _, asset := range names {
+		report.Assets = append(report.Assets, AssetReport{
+			Asset:        asset,
+			Threshold:    h.threshold(asset),
+			PoolVolume:   h.poolVolume[asset],
+			HedgedVolume: h.hedgedVolume[asset],
+			RealizedPnL:  h.realizedPnL[asset],
+		})
+	}
+	h.mu.Unlock()
+
+	// Prices may come from the network, so mark outside the lock
+	for i := range report.Assets {
+		line := &report.Assets[i]
+		if p, ok := h.book.Position(HouseAccount, line.Asset, false); ok {
+			line.NetExposure = p.Size
+			line.EntryPrice = p.EntryPrice
+			if h.prices != nil {
+				line.MarkPrice = h.prices.Price(line.Asset)
+				line.UnrealizedPnL = p.UnrealizedPnL(line.MarkPrice)
+			}
+		}
+		report.RealizedPnL += line.RealizedPnL
+		report.UnrealizedPnL += line.UnrealizedPnL
+	}
+	return report
+}
+
+func (h *Hedger) threshold(asset string) float64 {
+	if threshold, ok := h.config.Thresholds[asset]; ok {
+		return threshold
+	}
+	return h.config.DefaultThreshold
+}
@@ -0,0 +1,64 @@
+package hedge
+
+import (
+	"matching-engine/internal/engine/liquiditypool/amm"
+	"math"
+	"testing"
+)
+
+type fixedPrices map[string]float64
+
+func (p fixedPrices) Price(asset string) float64 {
+	return p[asset]
+}
+
+func TestHedgesExposureAboveThreshold(t *testing.T) {
+	venue := amm.New(0)
+	venue.AddPool("BTC", 100, 10000)
+	hedger := NewHedger(venue, fixedPrices{"BTC": 100}, Config{Thresholds: map[string]float64{"BTC": 2}})
+
+	// Users buy 1.5 from the pool: the house is short, but within threshold
+	hedger.RecordPoolFill("BTC", true, 1.5, 100)
+	if trades := hedger.Rebalance(); len(trades) != 0 {
+		t.Fatalf("Expected no hedge within threshold, got %+v", trades)
+	}
+
+	hedger.RecordPoolFill("BTC", true, 1.5, 100)
+	if exposure := hedger.Exposure("BTC"); exposure != -3 {
+		t.Fatalf("Expected house short 3, got %f", exposure)
+	}
+
+	trades := hedger.Rebalance()
+	if len(trades) != 1 || !trades[0].IsBuy || trades[0].Amount != 3 {
+		t.Fatalf("Expected a buy of 3 to hedge, got %+v", trades)
+	}
+	if exposure := hedger.Exposure("BTC"); exposure != 0 {
+		t.Errorf("Expected flat exposure after hedging, got %f", exposure)
+	}
+
+	// Sold at 100, bought back at 10000/97
+	report := hedger.Report()
+	expected := 3 * (100 - 10000.0/97)
+	if len(report.Assets) != 1 || math.Abs(report.RealizedPnL-expected) > 1e-9 {
+		t.Errorf("Expected realized P&L %f, got %+v", expected, report)
+	}
+	if line := report.Assets[0]; line.PoolVolume != 3 || line.HedgedVolume != 3 || line.NetExposure != 0 {
+		t.Errorf("Unexpected hedge book: %+v", line)
+	}
+}
+
+func TestUnhedgedExposureIsMarked(t *testing.T) {
+	venue := amm.New(0)
+	hedger := NewHedger(venue, fixedPrices{"ETH": 2100}, Config{})
+
+	// Users sell 2 to the pool: the house is long
+	hedger.RecordPoolFill("ETH", false, 2, 2000)
+	if trades := hedger.Rebalance(); len(trades) != 0 {
+		t.Fatalf("Expected no hedge without a threshold, got %+v", trades)
+	}
+
+	report := hedger.Report()
+	if report.UnrealizedPnL != 200 || report.Assets[0].MarkPrice != 2100 {
+		t.Errorf("Expected 200 unrealized at 2100, got %+v", report)
+	}
+}
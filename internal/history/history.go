@@ -0,0 +1,339 @@
+// Package history keeps past orders and trades queryable without an
+// external database. It is fed from the engine's event stream: the order and
+// trade events are appended to a local file, and indexes by trader, asset,
+// order ID and time are rebuilt from it in memory when the store opens.
+//
+// Queries assume events arrive in time order, as the engine stamps them.
+package history
+
+import (
+	"matching-engine/internal/events"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Order statuses
+const (
+	StatusOpen            = "open"
+	StatusPartiallyFilled = "partially_filled"
+	StatusFilled          = "filled"
+	StatusCancelled       = "cancelled"
+	StatusExpired         = "expired"
+	StatusRejected        = "rejected"
+)
+
+// Order is the latest known state of an order
+type Order struct {
+	// Seq is the event that created the order; orders are listed by it
+	Seq       uint64    `json:"seq"`
+	OrderID   string    `json:"order_id"`
+	Trader    string    `json:"trader"`
+	Asset     string    `json:"asset"`
+	IsBuy     bool      `json:"is_buy"`
+	Type      string    `json:"type"`
+	Price     float64   `json:"price"`
+	Amount    float64   `json:"amount"`
+	Filled    float64   `json:"filled"`
+	Remaining float64   `json:"remaining"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Trade is an execution with its asset and time
+type Trade struct {
+	Seq   uint64    `json:"seq"`
+	Asset string    `json:"asset"`
+	Time  time.Time `json:"time"`
+	events.Trade
+}
+
+// Query selects orders or trades. Empty fields match everything; From is
+// inclusive and To exclusive. Results are in event order, starting after the
+// cursor After, at most Limit of them.
+type Query struct {
+	Trader  string
+	Asset   string
+	OrderID string
+	// Status only applies to orders
+	Status string
+	From   time.Time
+	To     time.Time
+	After  uint64
+	Limit  int
+}
+
+// DefaultLimit and MaxLimit bound the page size of a query
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// OrderPage is one page of orders. Next is the cursor for the following
+// page, 0 when there is none.
+type OrderPage struct {
+	Orders []Order `json:"orders"`
+	Next   uint64  `json:"next,omitempty"`
+}
+
+// TradePage is one page of trades
+type TradePage struct {
+	Trades []Trade `json:"trades"`
+	Next   uint64  `json:"next,omitempty"`
+}
+
+// index is a list of positions into the orders or trades, in event order
+type index map[string][]int
+
+func (ix index) add(key string, i int) {
+	if key == "" {
+		return
+	}
+	list := ix[key]
+	// A trade between two orders of the same trader is indexed once
+	if len(list) > 0 && list[len(list)-1] == i {
+		return
+	}
+	ix[key] = append(list, i)
+}
+
+// memory holds the records and their indexes
+type memory struct {
+	mu      sync.RWMutex
+	orders  []Order
+	trades  []Trade
+	lastSeq uint64
+
+	orderByID      map[string]int
+	ordersByTrader index
+	ordersByAsset  index
+	tradesByTrader index
+	tradesByAsset  index
+	tradesByOrder  index
+}
+
+func newMemory() *memory {
+	return &memory{
+		orders:         make([]Order, 0),
+		trades:         make([]Trade, 0),
+		orderByID:      make(map[string]int),
+		ordersByTrader: make(index),
+		ordersByAsset:  make(index),
+		tradesByTrader: make(index),
+		tradesByAsset:  make(index),
+		tradesByOrder:  make(index),
+	}
+}
+
+// relevant reports whether the store keeps an event
+func relevant(event events.Event) bool {
+	switch event.Type {
+	case events.BookDelta:
+		return false
+	case events.TradeExecuted:
+		return event.Trade != nil
+	default:
+		return event.OrderID != ""
+	}
+}
+
+// apply adds an event to the records; it must be called with m.mu held
+func (m *memory) apply(event events.Event) {
+	m.lastSeq = event.Seq
+	if event.Type == events.TradeExecuted {
+		i := len(m.trades)
+		m.trades = append(m.trades, Trade{Seq: event.Seq, Asset: event.Asset, Time: event.Time, Trade: *event.Trade})
+		m.tradesByTrader.add(event.Trade.Taker, i)
+		m.tradesByTrader.add(event.Trade.Maker, i)
+		m.tradesByAsset.add(event.Asset, i)
+		m.tradesByOrder.add(event.Trade.TakerOrderID, i)
+		m.tradesByOrder.add(event.Trade.MakerOrderID, i)
+		return
+	}
+
+	i, ok := m.orderByID[event.OrderID]
+	if !ok {
+		i = len(m.orders)
+		m.orders = append(m.orders, Order{
+			Seq:       event.Seq,
+			OrderID:   event.OrderID,
+			Trader:    event.Trader,
+			Asset:     event.Asset,
+			IsBuy:     event.IsBuy,
+			Type:      event.OrderType,
+			CreatedAt: event.Time,
+		})
+		m.orderByID[event.OrderID] = i
+		m.ordersByTrader.add(event.Trader, i)
+		m.ordersByAsset.add(event.Asset, i)
+	}
+	order := &m.orders[i]
+	order.Price = event.Price
+	order.Amount = event.Amount
+	order.Filled = event.Filled
+	order.Remaining = event.Remaining
+	order.UpdatedAt = event.Time
+	if event.Reason != "" {
+		order.Reason = event.Reason
+	}
+	order.Status = status(event.Type, event.Filled)
+}
+
+func status(eventType events.Type, filled float64) string {
+	switch eventType {
+	case events.OrderFilled:
+		return StatusFilled
+	case events.OrderCancelled:
+		return StatusCancelled
+	case events.OrderExpired:
+		return StatusExpired
+	case events.OrderRejected:
+		return StatusRejected
+	}
+	if filled > 0 {
+		return StatusPartiallyFilled
+	}
+	return StatusOpen
+}
+
+// order returns the latest state of an order
+func (m *memory) order(orderID string) (Order, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i, ok := m.orderByID[orderID]
+	if !ok {
+		return Order{}, false
+	}
+	return m.orders[i], true
+}
+
+func (m *memory) queryOrders(q Query) OrderPage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	candidates, all := []int(nil), true
+	narrow := func(list []int) {
+		if all || len(list) < len(candidates) {
+			candidates, all = list, false
+		}
+	}
+	if q.OrderID != "" {
+		i, ok := m.orderByID[q.OrderID]
+		if !ok {
+			return OrderPage{Orders: []Order{}}
+		}
+		narrow([]int{i})
+	}
+	if q.Trader != "" {
+		narrow(m.ordersByTrader[q.Trader])
+	}
+	if q.Asset != "" {
+		narrow(m.ordersByAsset[q.Asset])
+	}
+
+	positions, next := collect(q, candidates, all, len(m.orders), func(i int) (uint64, time.Time) {
+		return m.orders[i].Seq, m.orders[i].CreatedAt
+	}, func(i int) bool {
+		order := m.orders[i]
+		return (q.Trader == "" || order.Trader == q.Trader) &&
+			(q.Asset == "" || order.Asset == q.Asset) &&
+			(q.OrderID == "" || order.OrderID == q.OrderID) &&
+			(q.Status == "" || order.Status == q.Status)
+	})
+	page := OrderPage{Orders: make([]Order, 0, len(positions)), Next: next}
+	for _, i := range positions {
+		page.Orders = append(page.Orders, m.orders[i])
+	}
+	return page
+}
+
+func (m *memory) queryTrades(q Query) TradePage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	candidates, all := []int(nil), true
+	narrow := func(list []int) {
+		if all || len(list) < len(candidates) {
+			candidates, all = list, false
+		}
+	}
+	if q.OrderID != "" {
+		narrow(m.tradesByOrder[q.OrderID])
+	}
+	if q.Trader != "" {
+		narrow(m.tradesByTrader[q.Trader])
+	}
+	if q.Asset != "" {
+		narrow(m.tradesByAsset[q.Asset])
+	}
+
+	positions, next := collect(q, candidates, all, len(m.trades), func(i int) (uint64, time.Time) {
+		return m.trades[i].Seq, m.trades[i].Time
+	}, func(i int) bool {
+		trade := m.trades[i]
+		return (q.Trader == "" || trade.Taker == q.Trader || trade.Maker == q.Trader) &&
+			(q.Asset == "" || trade.Asset == q.Asset) &&
+			(q.OrderID == "" || trade.TakerOrderID == q.OrderID || trade.MakerOrderID == q.OrderID)
+	})
+	page := TradePage{Trades: make([]Trade, 0, len(positions)), Next: next}
+	for _, i := range positions {
+		page.Trades = append(page.Trades, m.trades[i])
+	}
+	return page
+}
+
+// collect pages through candidates, or all n records when all is set, and
+// returns the positions of the matching records and the next cursor. Both
+// are in event order, so the cursor and the start time are found by binary
+// search.
+func collect(q Query, candidates []int, all bool, n int, at func(int) (uint64, time.Time), match func(int) bool) ([]int, uint64) {
+	size := len(candidates)
+	position := func(k int) int { return candidates[k] }
+	if all {
+		size = n
+		position = func(k int) int { return k }
+	}
+
+	start := sort.Search(size, func(k int) bool {
+		seq, _ := at(position(k))
+		return seq > q.After
+	})
+	if !q.From.IsZero() {
+		fromStart := sort.Search(size, func(k int) bool {
+			_, t := at(position(k))
+			return !t.Before(q.From)
+		})
+		if fromStart > start {
+			start = fromStart
+		}
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	positions := make([]int, 0)
+	var last uint64
+	for k := start; k < size; k++ {
+		i := position(k)
+		seq, t := at(i)
+		if !q.To.IsZero() && !t.Before(q.To) {
+			break
+		}
+		if !match(i) {
+			continue
+		}
+		if len(positions) == limit {
+			return positions, last
+		}
+		positions = append(positions, i)
+		last = seq
+	}
+	return positions, 0
+}
@@ -0,0 +1,113 @@
+package history
+
+import (
+	"matching-engine/internal/events"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+
+// session is two days of orders and trades: alice buys ETH from bob on the
+// first day and BTC on the second, and carol has an order rejected
+func session() []events.Event {
+	evs := make([]events.Event, 0)
+	add := func(event events.Event) {
+		event.Seq = uint64(len(evs) + 1)
+		evs = append(evs, event)
+	}
+	order := func(t events.Type, at time.Time, id string, trader string, asset string, filled float64, remaining float64) {
+		add(events.Event{Type: t, Time: at, OrderID: id, Trader: trader, Asset: asset, OrderType: "limit",
+			Price: 100, Amount: filled + remaining, Filled: filled, Remaining: remaining})
+	}
+	trade := func(at time.Time, id string, asset string, taker string, maker string) {
+		add(events.Event{Type: events.TradeExecuted, Time: at, Asset: asset, Trade: &events.Trade{
+			TradeID: id, TakerOrderID: taker + "-" + asset, MakerOrderID: maker + "-" + asset,
+			Taker: taker, Maker: maker, Price: 100, Amount: 1,
+		}})
+		add(events.Event{Type: events.BookDelta, Time: at, Asset: asset, Delta: &events.Delta{Change: -1}})
+	}
+
+	for d, asset := range []string{"ETH", "BTC"} {
+		at := day.Add(time.Duration(d) * 24 * time.Hour)
+		order(events.OrderAccepted, at, "bob-"+asset, "bob", asset, 0, 3)
+		order(events.OrderRested, at, "bob-"+asset, "bob", asset, 0, 3)
+		order(events.OrderAccepted, at.Add(time.Hour), "alice-"+asset, "alice", asset, 0, 3)
+		for i := 0; i < 3; i++ {
+			trade(at.Add(time.Hour+time.Duration(i)*time.Minute), asset+"-t"+string(rune('1'+i)), asset, "alice", "bob")
+		}
+		order(events.OrderFilled, at.Add(time.Hour), "bob-"+asset, "bob", asset, 3, 0)
+		order(events.OrderFilled, at.Add(time.Hour), "alice-"+asset, "alice", asset, 3, 0)
+	}
+	add(events.Event{Type: events.OrderRejected, Time: day.Add(30 * time.Hour), OrderID: "carol-1", Trader: "carol", Asset: "ETH", Reason: "insufficient funds"})
+	return evs
+}
+
+func TestQueriesFilterAndPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evs := session()
+	store.Publish(evs[:10])
+	store.Publish(evs[10:])
+
+	// All fills for alice on ETH on the first day
+	q := Query{Trader: "alice", Asset: "ETH", From: day, To: day.Add(24 * time.Hour)}
+	trades := store.Trades(q)
+	if len(trades.Trades) != 3 || trades.Next != 0 {
+		t.Fatalf("Expected 3 ETH trades on one page, got %+v", trades)
+	}
+	if len(store.Trades(Query{Trader: "alice", Asset: "ETH", From: day.Add(24 * time.Hour)}).Trades) != 0 {
+		t.Errorf("Expected no ETH trades on the second day")
+	}
+
+	// Paging through all of bob's trades two at a time
+	seen := 0
+	q = Query{Trader: "bob", Limit: 2}
+	for {
+		page := store.Trades(q)
+		seen += len(page.Trades)
+		if page.Next == 0 {
+			break
+		}
+		q.After = page.Next
+	}
+	if seen != 6 {
+		t.Errorf("Expected 6 trades for bob across pages, got %d", seen)
+	}
+
+	if trades := store.Trades(Query{OrderID: "bob-BTC"}); len(trades.Trades) != 3 || trades.Trades[0].Asset != "BTC" {
+		t.Errorf("Expected the 3 trades of bob-BTC, got %+v", trades)
+	}
+
+	order, ok := store.Order("alice-ETH")
+	if !ok || order.Status != StatusFilled || order.Filled != 3 || !order.CreatedAt.Equal(day.Add(time.Hour)) {
+		t.Errorf("Unexpected order %+v", order)
+	}
+	if rejected := store.Orders(Query{Status: StatusRejected}); len(rejected.Orders) != 1 || rejected.Orders[0].Reason == "" {
+		t.Errorf("Expected carol's rejected order, got %+v", rejected)
+	}
+	if orders := store.Orders(Query{Trader: "bob", Asset: "BTC"}); len(orders.Orders) != 1 || orders.Orders[0].OrderID != "bob-BTC" {
+		t.Errorf("Expected bob-BTC, got %+v", orders)
+	}
+	if store.LastSeq() != evs[len(evs)-1].Seq {
+		t.Errorf("Expected the store to be at event %d, got %d", evs[len(evs)-1].Seq, store.LastSeq())
+	}
+	store.Close()
+
+	// Reopening rebuilds the indexes from the log
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.Trades(Query{Trader: "alice"}); len(got.Trades) != 6 {
+		t.Errorf("Expected 6 trades after reopening, got %d", len(got.Trades))
+	}
+	if got := reopened.Orders(Query{}); len(got.Orders) != 5 {
+		t.Errorf("Expected 5 orders after reopening, got %d", len(got.Orders))
+	}
+}
@@ -0,0 +1,106 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"matching-engine/internal/events"
+)
+
+// ErrReadOnly is returned by Publish on a store opened with Load
+var ErrReadOnly = errors.New("history store is read only")
+
+// Store is the history store. It is an events.Publisher, so the engine can
+// feed it directly or through an events.Fanout.
+type Store struct {
+	memory *memory
+	file   *events.File
+}
+
+// Open opens the store whose log is the file at path, creating it if needed,
+// and rebuilds the indexes from it
+func Open(path string) (*Store, error) {
+	file, err := events.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Load(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.file = file
+	return s, nil
+}
+
+// Load reads the log at path into a store that can be queried but not
+// published to. It leaves the file alone, so it can read the log of a
+// running engine.
+func Load(path string) (*Store, error) {
+	s := &Store{memory: newMemory()}
+	err := events.ReadFile(path, 0, func(event events.Event) error {
+		if relevant(event) {
+			s.memory.apply(event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading history: %w", err)
+	}
+	return s, nil
+}
+
+// Publish appends the order and trade events to the log and indexes them
+func (s *Store) Publish(batch []events.Event) error {
+	if s.file == nil {
+		return ErrReadOnly
+	}
+	kept := make([]events.Event, 0, len(batch))
+	for _, event := range batch {
+		if relevant(event) {
+			kept = append(kept, event)
+		}
+	}
+	if len(kept) > 0 {
+		if err := s.file.Publish(kept); err != nil {
+			return err
+		}
+	}
+
+	s.memory.mu.Lock()
+	defer s.memory.mu.Unlock()
+	for _, event := range kept {
+		s.memory.apply(event)
+	}
+	if n := len(batch); n > 0 && batch[n-1].Seq > s.memory.lastSeq {
+		s.memory.lastSeq = batch[n-1].Seq
+	}
+	return nil
+}
+
+func (s *Store) LastSeq() uint64 {
+	s.memory.mu.RLock()
+	defer s.memory.mu.RUnlock()
+	return s.memory.lastSeq
+}
+
+func (s *Store) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// Order returns the latest state of an order
+func (s *Store) Order(orderID string) (Order, bool) {
+	return s.memory.order(orderID)
+}
+
+// Orders returns a page of orders matching q, by creation
+func (s *Store) Orders(q Query) OrderPage {
+	return s.memory.queryOrders(q)
+}
+
+// Trades returns a page of trades matching q. Trader matches either side.
+func (s *Store) Trades(q Query) TradePage {
+	return s.memory.queryTrades(q)
+}
@@ -0,0 +1,123 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SegmentReport describes a segment file as found on disk
+type SegmentReport struct {
+	Segment
+	// Size is the size of the file and End the offset just past its last
+	// valid record
+	Size int64
+	End  int64
+	// Records counts the valid records, numbered First to Last
+	Records int
+	First   uint64
+	Last    uint64
+	// Gap is the first record that does not follow the one before it, 0 if
+	// all do
+	Gap uint64
+	// Err wraps ErrCorrupt when the file does not end on a record boundary,
+	// and ErrTornTail when its last frame is cut off by the end of the file
+	Err error
+}
+
+// Inspect reads every segment in dir without changing any of them
+func Inspect(dir string) ([]SegmentReport, error) {
+	segments, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]SegmentReport, 0, len(segments))
+	for _, segment := range segments {
+		info, err := os.Stat(segment.Path)
+		if err != nil {
+			return nil, err
+		}
+		report := SegmentReport{Segment: segment, Size: info.Size()}
+		report.End, report.Err = ReadSegment(segment.Path, func(record Record) error {
+			if report.Records == 0 {
+				report.First = record.Seq
+			} else if record.Seq != report.Last+1 && report.Gap == 0 {
+				report.Gap = record.Seq
+			}
+			report.Records++
+			report.Last = record.Seq
+			return nil
+		})
+		if report.Err != nil && !errors.Is(report.Err, ErrCorrupt) {
+			return nil, report.Err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Verify checks every record's checksum and that the sequence numbers run on
+// without gaps from one segment to the next. It returns the problems found:
+// ErrTornTail for a frame cut off at the end of the last segment, which
+// loses nothing that was acknowledged, ErrCorrupt for any other damage and
+// ErrSequence for gaps.
+func Verify(dir string) ([]error, error) {
+	reports, err := Inspect(dir)
+	if err != nil {
+		return nil, err
+	}
+	problems := make([]error, 0)
+	var previous uint64
+	for i, report := range reports {
+		name := filepath.Base(report.Path)
+		switch {
+		case report.Err == nil:
+		case errors.Is(report.Err, ErrTornTail) && i == len(reports)-1:
+			problems = append(problems, fmt.Errorf("%d bytes after the last record: %w",
+				report.Size-report.End, report.Err))
+		case errors.Is(report.Err, ErrTornTail):
+			// Only the segment being written when a crash hit can be torn
+			problems = append(problems, fmt.Errorf("%w: %s ends part way through a record at offset %d",
+				ErrCorrupt, name, report.End))
+		default:
+			problems = append(problems, report.Err)
+		}
+		if report.Records == 0 {
+			continue
+		}
+		if report.First != report.FirstSeq {
+			problems = append(problems, fmt.Errorf("%w: %s starts at record %d", ErrSequence, name, report.First))
+		}
+		if report.Gap != 0 {
+			problems = append(problems, fmt.Errorf("%w: %s jumps to record %d", ErrSequence, name, report.Gap))
+		}
+		if previous > 0 && report.First != previous+1 {
+			problems = append(problems, fmt.Errorf("%w: %s starts at record %d after record %d",
+				ErrSequence, name, report.First, previous))
+		}
+		previous = report.Last
+	}
+	return problems, nil
+}
+
+// TruncateTail cuts a torn record off the end of the last segment in dir and
+// returns the number of bytes removed. Any other damage to the last segment
+// is refused with ErrCorrupt. The journal must not be open.
+func TruncateTail(dir string) (int64, error) {
+	reports, err := Inspect(dir)
+	if err != nil || len(reports) == 0 {
+		return 0, err
+	}
+	last := reports[len(reports)-1]
+	if last.Err == nil {
+		return 0, nil
+	}
+	if !errors.Is(last.Err, ErrTornTail) {
+		return 0, fmt.Errorf("not a torn tail, refusing to truncate: %w", last.Err)
+	}
+	if err := os.Truncate(last.Path, last.End); err != nil {
+		return 0, err
+	}
+	return last.Size - last.End, nil
+}
@@ -0,0 +1,74 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestVerifyFindsDamageAndGaps(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir, Options{Sync: SyncNone, SegmentSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, j, 10)
+	j.Close()
+
+	problems, err := Verify(dir)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("Expected a clean journal, got %v %v", problems, err)
+	}
+	reports, _ := Inspect(dir)
+	if len(reports) < 3 || reports[0].First != 1 || reports[len(reports)-1].Last != 10 {
+		t.Fatalf("Unexpected segments %+v", reports)
+	}
+
+	// A torn tail is reported, then cut off
+	last := reports[len(reports)-1]
+	frame := encode(Record{Seq: 11, Type: RecordCancel, Payload: []byte(`{}`)})
+	file, _ := os.OpenFile(last.Path, os.O_APPEND|os.O_WRONLY, 0o644)
+	file.Write(frame[:5])
+	file.Close()
+	if problems, _ := Verify(dir); len(problems) != 1 || !errors.Is(problems[0], ErrTornTail) {
+		t.Errorf("Expected a torn tail, got %v", problems)
+	}
+	if removed, err := TruncateTail(dir); err != nil || removed != 5 {
+		t.Errorf("Expected 5 bytes truncated, got %d %v", removed, err)
+	}
+	if problems, _ := Verify(dir); len(problems) != 0 {
+		t.Errorf("Expected a clean journal after truncating, got %v", problems)
+	}
+
+	// A whole frame failing its checksum at the end is corruption, which
+	// truncate refuses to cut off
+	data, _ := os.ReadFile(last.Path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(last.Path, data, 0o644)
+	if problems, _ := Verify(dir); len(problems) != 1 || !errors.Is(problems[0], ErrCorrupt) || errors.Is(problems[0], ErrTornTail) {
+		t.Errorf("Expected corruption rather than a torn tail, got %v", problems)
+	}
+	if removed, err := TruncateTail(dir); !errors.Is(err, ErrCorrupt) || removed != 0 {
+		t.Errorf("Expected truncate to refuse, got %d %v", removed, err)
+	}
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(last.Path, data, 0o644)
+
+	// A missing segment leaves a gap, and damage before the end is corrupt
+	os.Remove(reports[1].Path)
+	data, _ = os.ReadFile(reports[0].Path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(reports[0].Path, data, 0o644)
+	problems, _ = Verify(dir)
+	corrupt, gap := false, false
+	for _, problem := range problems {
+		corrupt = corrupt || errors.Is(problem, ErrCorrupt)
+		gap = gap || errors.Is(problem, ErrSequence)
+	}
+	if !corrupt || !gap {
+		t.Errorf("Expected corruption and a gap, got %v", problems)
+	}
+	if removed, _ := TruncateTail(dir); removed != 0 {
+		t.Errorf("Expected nothing to truncate at the end, got %d bytes", removed)
+	}
+}
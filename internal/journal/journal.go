@@ -0,0 +1,648 @@
+// Package journal is a write-ahead log of engine commands. Records are
+// sequence numbered, checksummed and appended to segment files that rotate
+// at a configurable size.
+//
+// Each record is framed as
+//
+//	[4 length][4 crc32c][8 seq][1 type][8 unix nanos][payload]
+//
+// where length counts everything after the checksum and the checksum covers
+// the same bytes. Integers are big endian.
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCorrupt means a record failed its checksum or framing before the
+	// end of the journal, so later records cannot be trusted
+	ErrCorrupt = errors.New("journal corrupt")
+	ErrClosed  = errors.New("journal closed")
+	// ErrFailed means a write or sync failed, leaving it unknown what
+	// reached the disk, so the journal refuses further appends until it
+	// is reopened
+	ErrFailed = errors.New("journal failed")
+	// ErrSequence means a record copied from another journal does not
+	// follow on from this one
+	ErrSequence = errors.New("journal sequence gap")
+	// ErrTornTail means a frame stops short at the end of the file, as a
+	// crash mid-write leaves the last segment. It wraps ErrCorrupt; only a
+	// torn end of the last segment is cut off rather than refused.
+	ErrTornTail = fmt.Errorf("%w: torn tail", ErrCorrupt)
+)
+
+// RecordType identifies the command a record holds
+type RecordType uint8
+
+const (
+	RecordNewOrder RecordType = iota + 1
+	RecordCancel
+	RecordAmend
+	RecordExpiry
+	RecordPoolResult
+	RecordDeposit
+	RecordWithdrawal
+	RecordRejection
+	RecordHedge
+	RecordLiquidation
+	RecordFeeSchedule
+
+	lastRecordType = RecordFeeSchedule
+)
+
+func (t RecordType) String() string {
+	switch t {
+	case RecordNewOrder:
+		return "new_order"
+	case RecordCancel:
+		return "cancel"
+	case RecordAmend:
+		return "amend"
+	case RecordExpiry:
+		return "expiry"
+	case RecordPoolResult:
+		return "pool_result"
+	case RecordDeposit:
+		return "deposit"
+	case RecordWithdrawal:
+		return "withdrawal"
+	case RecordRejection:
+		return "rejection"
+	case RecordHedge:
+		return "hedge"
+	case RecordLiquidation:
+		return "liquidation"
+	case RecordFeeSchedule:
+		return "fee_schedule"
+	default:
+		return "type_" + strconv.Itoa(int(t))
+	}
+}
+
+// ParseRecordType is the inverse of RecordType.String
+func ParseRecordType(s string) (RecordType, bool) {
+	for t := RecordNewOrder; t <= lastRecordType; t++ {
+		if t.String() == s {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// Record is one journaled command
+type Record struct {
+	Seq     uint64
+	Type    RecordType
+	Time    time.Time
+	Payload []byte
+}
+
+// SyncPolicy decides when appended records are flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs before Append returns
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs in the background every Options.SyncInterval
+	SyncInterval
+	// SyncNone leaves flushing to the operating system
+	SyncNone
+)
+
+// ParseSyncPolicy reads "always", "interval" or "none"
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "none":
+		return SyncNone, nil
+	}
+	return 0, fmt.Errorf("unknown sync policy %q", s)
+}
+
+const (
+	DefaultSegmentSize  = 64 << 20
+	DefaultSyncInterval = 100 * time.Millisecond
+
+	segmentExt = ".wal"
+	headerSize = 4 + 4
+	fixedSize  = 8 + 1 + 8
+	// maxRecordSize rejects absurd lengths read from a damaged frame
+	maxRecordSize = 16 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configure a journal
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// SegmentSize is the size after which a new segment is started
+	SegmentSize int64
+}
+
+// Journal appends records to segment files in a directory
+type Journal struct {
+	mu      sync.Mutex
+	dir     string
+	options Options
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	nextSeq uint64
+	dirty   bool
+	closed  bool
+	failed  error
+	done    chan struct{}
+	now     func() time.Time
+	subs    map[chan Record]struct{}
+}
+
+// Open opens or creates the journal in dir. A torn record at the end of the
+// last segment, left by a crash mid-write, is truncated away; any other
+// damage to it fails with ErrCorrupt.
+func Open(dir string, options Options) (*Journal, error) {
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		dir:     dir,
+		options: options,
+		nextSeq: 1,
+		done:    make(chan struct{}),
+		now:     time.Now,
+		subs:    make(map[chan Record]struct{}),
+	}
+
+	segments, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		end, lastSeq, err := scanSegment(last.Path)
+		if err != nil && !errors.Is(err, ErrTornTail) {
+			return nil, err
+		}
+		if err != nil {
+			if err := os.Truncate(last.Path, end); err != nil {
+				return nil, err
+			}
+		}
+		if lastSeq > 0 {
+			j.nextSeq = lastSeq + 1
+		} else {
+			j.nextSeq = last.FirstSeq
+		}
+		if err := j.openSegment(last.Path, end); err != nil {
+			return nil, err
+		}
+	} else if err := j.rotate(); err != nil {
+		return nil, err
+	}
+
+	if options.Sync == SyncInterval {
+		go j.syncLoop()
+	}
+	return j, nil
+}
+
+// SetClock replaces the clock records are stamped with
+func (j *Journal) SetClock(now func() time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.now = now
+}
+
+// NextSeq returns the sequence number the next record will get
+func (j *Journal) NextSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.nextSeq
+}
+
+// Append writes a record and, under SyncAlways, makes it durable before
+// returning its sequence number
+func (j *Journal) Append(recordType RecordType, payload []byte) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	record := Record{Seq: j.nextSeq, Type: recordType, Time: j.now(), Payload: payload}
+	if err := j.write(record); err != nil {
+		return 0, err
+	}
+	return record.Seq, nil
+}
+
+// AppendRecord copies a record from another journal, keeping its sequence
+// number and time. It must be the next record of this journal.
+func (j *Journal) AppendRecord(record Record) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if record.Seq != j.nextSeq {
+		return fmt.Errorf("%w: expected record %d, got %d", ErrSequence, j.nextSeq, record.Seq)
+	}
+	return j.write(record)
+}
+
+// Subscribe returns a channel receiving every record appended from now on.
+// A subscriber that lets buffer records pile up is dropped and its channel
+// closed, as are all channels when the journal closes; it then has to catch
+// up by reading the journal. Call the returned func to unsubscribe.
+func (j *Journal) Subscribe(buffer int) (<-chan Record, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ch := make(chan Record, buffer)
+	if j.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	j.subs[ch] = struct{}{}
+	return ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if _, ok := j.subs[ch]; ok {
+			delete(j.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// write must be called with j.mu held
+func (j *Journal) write(record Record) error {
+	if j.closed {
+		return ErrClosed
+	}
+	if j.failed != nil {
+		return j.failed
+	}
+	if len(record.Payload)+fixedSize > maxRecordSize {
+		return fmt.Errorf("journal record of %d bytes is too large", len(record.Payload))
+	}
+	if j.size >= j.options.SegmentSize {
+		if err := j.rotate(); err != nil {
+			return j.fail(err)
+		}
+	}
+
+	frame := encode(record)
+	if _, err := j.writer.Write(frame); err != nil {
+		return j.fail(err)
+	}
+	if err := j.writer.Flush(); err != nil {
+		return j.fail(err)
+	}
+	if j.options.Sync == SyncAlways {
+		if err := j.file.Sync(); err != nil {
+			return j.fail(err)
+		}
+	} else {
+		j.dirty = true
+	}
+
+	j.size += int64(len(frame))
+	j.nextSeq++
+	for ch := range j.subs {
+		select {
+		case ch <- record:
+		default:
+			delete(j.subs, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// fail stops appends after a write or sync error, which may have left part
+// of a frame in the segment. The segment is cut back to the end of the last
+// whole record where possible, so a reopened journal carries on from there
+// rather than from a damaged frame. It must be called with j.mu held.
+func (j *Journal) fail(err error) error {
+	j.failed = fmt.Errorf("%w: %v", ErrFailed, err)
+	if j.file != nil {
+		j.file.Truncate(j.size)
+	}
+	return j.failed
+}
+
+// Sync flushes appended records to stable storage
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.sync()
+}
+
+// Close syncs and closes the journal
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+	close(j.done)
+	for ch := range j.subs {
+		delete(j.subs, ch)
+		close(ch)
+	}
+	if err := j.sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+// Dir returns the directory of the journal
+func (j *Journal) Dir() string {
+	return j.dir
+}
+
+func (j *Journal) syncLoop() {
+	ticker := time.NewTicker(j.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			j.Sync()
+		}
+	}
+}
+
+// sync must be called with j.mu held
+func (j *Journal) sync() error {
+	if j.failed != nil {
+		return j.failed
+	}
+	if !j.dirty || j.file == nil {
+		return nil
+	}
+	if err := j.file.Sync(); err != nil {
+		// The records are already acknowledged, so they are not cut off,
+		// but nothing may follow them while their durability is unknown
+		j.failed = fmt.Errorf("%w: %v", ErrFailed, err)
+		return j.failed
+	}
+	j.dirty = false
+	return nil
+}
+
+// rotate closes the current segment and starts one named after the next
+// sequence number; it must be called with j.mu held
+func (j *Journal) rotate() error {
+	if j.file != nil {
+		if err := j.writer.Flush(); err != nil {
+			return err
+		}
+		if err := j.file.Sync(); err != nil {
+			return err
+		}
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+		j.dirty = false
+	}
+	return j.openSegment(filepath.Join(j.dir, SegmentName(j.nextSeq)), 0)
+}
+
+func (j *Journal) openSegment(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	j.file = file
+	j.writer = bufio.NewWriter(file)
+	j.size = size
+	return syncDir(j.dir)
+}
+
+// Segment is one journal file
+type Segment struct {
+	Path     string
+	FirstSeq uint64
+}
+
+// SegmentName returns the file name of the segment starting at seq
+func SegmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, segmentExt)
+}
+
+// Segments lists the segments in dir in sequence order
+func Segments(dir string) ([]Segment, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]Segment, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, Segment{Path: filepath.Join(dir, name), FirstSeq: seq})
+	}
+	sort.Slice(segments, func(i, k int) bool { return segments[i].FirstSeq < segments[k].FirstSeq })
+	return segments, nil
+}
+
+// Read calls fn for every record with a sequence number of at least from, in
+// order. It stops at the first error returned by fn. A torn record at the end
+// of the last segment ends the journal; damage anywhere else is ErrCorrupt.
+func Read(dir string, from uint64, fn func(Record) error) error {
+	segments, err := Segments(dir)
+	if err != nil {
+		return err
+	}
+	for i, segment := range segments {
+		// Skip segments that end before from
+		if i+1 < len(segments) && segments[i+1].FirstSeq <= from {
+			continue
+		}
+		err := readSegment(segment.Path, func(record Record) error {
+			if record.Seq < from {
+				return nil
+			}
+			return fn(record)
+		})
+		if errors.Is(err, ErrTornTail) && i == len(segments)-1 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSegment calls fn for every record of one segment file and returns the
+// offset just past the last valid record. The error wraps ErrCorrupt if the
+// segment does not end on a record boundary, and ErrTornTail too if the
+// damaged frame runs on past the end of the file.
+func ReadSegment(path string, fn func(Record) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		record, n, err := decode(reader)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, fmt.Errorf("%s at offset %d: %w", filepath.Base(path), offset, err)
+		}
+		if err := fn(record); err != nil {
+			return offset, err
+		}
+		offset += n
+	}
+}
+
+func readSegment(path string, fn func(Record) error) error {
+	_, err := ReadSegment(path, fn)
+	return err
+}
+
+// scanSegment returns the end of the valid records of a segment and the last
+// sequence number in it
+func scanSegment(path string) (int64, uint64, error) {
+	var lastSeq uint64
+	end, err := ReadSegment(path, func(record Record) error {
+		lastSeq = record.Seq
+		return nil
+	})
+	return end, lastSeq, err
+}
+
+// errFound stops Find once it has its record
+var errFound = errors.New("found")
+
+// Find returns the record with sequence number seq in dir, if the journal
+// has it
+func Find(dir string, seq uint64) (Record, bool, error) {
+	var found Record
+	err := Read(dir, seq, func(record Record) error {
+		found = record
+		return errFound
+	})
+	if errors.Is(err, errFound) {
+		return found, found.Seq == seq, nil
+	}
+	return Record{}, false, err
+}
+
+// Checksum returns the checksum the record is framed with
+func (r Record) Checksum() uint32 {
+	return binary.BigEndian.Uint32(encode(r)[4:8])
+}
+
+// WriteRecord writes one record in the journal's framing, e.g. to stream it
+// to another process
+func WriteRecord(w io.Writer, record Record) error {
+	_, err := w.Write(encode(record))
+	return err
+}
+
+// ReadRecord reads one record written by WriteRecord. The error is io.EOF
+// at a clean end and wraps ErrCorrupt for a damaged or cut off frame.
+func ReadRecord(r io.Reader) (Record, error) {
+	record, _, err := decode(r)
+	return record, err
+}
+
+func encode(record Record) []byte {
+	frame := make([]byte, headerSize+fixedSize+len(record.Payload))
+	body := frame[headerSize:]
+	binary.BigEndian.PutUint64(body[0:8], record.Seq)
+	body[8] = byte(record.Type)
+	binary.BigEndian.PutUint64(body[9:17], uint64(record.Time.UnixNano()))
+	copy(body[fixedSize:], record.Payload)
+
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(body, crcTable))
+	return frame
+}
+
+// decode reads one frame. io.EOF means a clean end; a frame cut off by the
+// end of the input is ErrTornTail, and any other invalid frame ErrCorrupt.
+func decode(reader io.Reader) (Record, int64, error) {
+	var header [headerSize]byte
+	if n, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.EOF && n == 0 {
+			return Record{}, 0, io.EOF
+		}
+		return Record{}, 0, fmt.Errorf("%w: short header", ErrTornTail)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length < fixedSize || length > maxRecordSize {
+		return Record{}, 0, fmt.Errorf("%w: bad length %d", ErrCorrupt, length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return Record{}, 0, fmt.Errorf("%w: short record", ErrTornTail)
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return Record{}, 0, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	record := Record{
+		Seq:     binary.BigEndian.Uint64(body[0:8]),
+		Type:    RecordType(body[8]),
+		Time:    time.Unix(0, int64(binary.BigEndian.Uint64(body[9:17]))),
+		Payload: body[fixedSize:],
+	}
+	return record, int64(headerSize + length), nil
+}
+
+// syncDir makes a new segment's directory entry durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	d.Sync()
+	return nil
+}
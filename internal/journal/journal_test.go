@@ -0,0 +1,265 @@
+package journal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func appendN(t *testing.T, j *Journal, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := j.Append(RecordNewOrder, []byte(fmt.Sprintf(`{"n":%d}`, i))); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+}
+
+func readAll(t *testing.T, dir string, from uint64) []Record {
+	t.Helper()
+	records := make([]Record, 0)
+	if err := Read(dir, from, func(record Record) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	return records
+}
+
+func TestAppendAndRead(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, j, 3)
+	if _, err := j.Append(RecordCancel, []byte(`{"order_id":"a"}`)); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	records := readAll(t, dir, 1)
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 records, got %d", len(records))
+	}
+	for i, record := range records {
+		if record.Seq != uint64(i+1) {
+			t.Errorf("Expected seq %d, got %d", i+1, record.Seq)
+		}
+	}
+	if records[3].Type != RecordCancel || string(records[3].Payload) != `{"order_id":"a"}` {
+		t.Errorf("Unexpected last record: %+v", records[3])
+	}
+	if _, err := j.Append(RecordCancel, nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+
+	// Reopening continues the sequence
+	j, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if seq, _ := j.Append(RecordExpiry, nil); seq != 5 {
+		t.Errorf("Expected seq 5 after reopening, got %d", seq)
+	}
+}
+
+func TestSegmentsRotate(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir, Options{Sync: SyncNone, SegmentSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, j, 10)
+	j.Close()
+
+	segments, err := Segments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 3 || segments[0].FirstSeq != 1 {
+		t.Fatalf("Expected several segments from seq 1, got %+v", segments)
+	}
+
+	// Reading from the middle skips earlier segments
+	records := readAll(t, dir, 7)
+	if len(records) != 4 || records[0].Seq != 7 {
+		t.Errorf("Expected records 7 to 10, got %+v", records)
+	}
+}
+
+func TestTornTailIsTruncated(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, j, 2)
+	j.Close()
+
+	// Simulate a crash halfway through a third record
+	segments, _ := Segments(dir)
+	path := segments[0].Path
+	info, _ := os.Stat(path)
+	frame := encode(Record{Seq: 3, Type: RecordNewOrder, Payload: []byte(`{"n":2}`)})
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	file.Write(frame[:len(frame)/2])
+	file.Close()
+
+	if records := readAll(t, dir, 1); len(records) != 2 {
+		t.Fatalf("Expected the torn record to end the journal, got %d records", len(records))
+	}
+
+	j, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Errorf("Expected segment truncated to %d bytes, got %d", info.Size(), after.Size())
+	}
+	if seq, _ := j.Append(RecordNewOrder, nil); seq != 3 {
+		t.Errorf("Expected seq 3 after truncation, got %d", seq)
+	}
+}
+
+func TestCorruptRecordIsDetected(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir, Options{SegmentSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, j, 10)
+	j.Close()
+
+	// Flip a payload byte in the first segment
+	segments, _ := Segments(dir)
+	data, _ := os.ReadFile(segments[0].Path)
+	data[headerSize+fixedSize] ^= 0xff
+	os.WriteFile(segments[0].Path, data, 0o644)
+
+	err = Read(dir, 1, func(Record) error { return nil })
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestSubscribeAndAppendRecord(t *testing.T) {
+	j, err := Open(t.TempDir(), Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, unsubscribe := j.Subscribe(2)
+	defer unsubscribe()
+	appendN(t, j, 2)
+
+	replica, err := Open(t.TempDir(), Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		record := <-live
+		if err := replica.AppendRecord(record); err != nil {
+			t.Fatalf("AppendRecord failed: %v", err)
+		}
+	}
+	if err := replica.AppendRecord(Record{Seq: 5, Type: RecordCancel}); !errors.Is(err, ErrSequence) {
+		t.Errorf("Expected a gap to be refused, got %v", err)
+	}
+	replica.Close()
+	copied := readAll(t, replica.Dir(), 1)
+	if len(copied) != 2 || copied[1].Seq != 2 || string(copied[1].Payload) != `{"n":1}` {
+		t.Errorf("Unexpected copied records %+v", copied)
+	}
+
+	// A subscriber that falls behind is dropped
+	appendN(t, j, 3)
+	count := 0
+	for range live {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 buffered records before the drop, got %d", count)
+	}
+	j.Close()
+}
+
+func TestDamagedLastRecordIsNotTorn(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, j, 3)
+	j.Close()
+
+	// A whole frame that fails its checksum was written, so it is damage
+	// rather than a crash mid-write
+	segments, _ := Segments(dir)
+	path := segments[0].Path
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	if _, err := Open(dir, Options{}); !errors.Is(err, ErrCorrupt) || errors.Is(err, ErrTornTail) {
+		t.Errorf("Expected Open to fail with ErrCorrupt, got %v", err)
+	}
+	if after, _ := os.ReadFile(path); len(after) != len(data) {
+		t.Errorf("Expected the segment left alone, it went from %d to %d bytes", len(data), len(after))
+	}
+	if err := Read(dir, 1, func(Record) error { return nil }); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected Read to fail with ErrCorrupt, got %v", err)
+	}
+}
+
+// halfWriter writes the first half of what it is given and then fails, as a
+// full disk does partway through a frame
+type halfWriter struct {
+	file *os.File
+}
+
+func (w halfWriter) Write(p []byte) (int, error) {
+	n, _ := w.file.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestFailedWriteStopsAppends(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, j, 2)
+	segments, _ := Segments(dir)
+	info, _ := os.Stat(segments[0].Path)
+
+	j.mu.Lock()
+	j.writer = bufio.NewWriterSize(halfWriter{j.file}, 16)
+	j.mu.Unlock()
+	if _, err := j.Append(RecordNewOrder, []byte(`{"n":2}`)); !errors.Is(err, ErrFailed) {
+		t.Fatalf("Expected the write to fail the journal, got %v", err)
+	}
+	if _, err := j.Append(RecordNewOrder, []byte(`{"n":3}`)); !errors.Is(err, ErrFailed) {
+		t.Errorf("Expected appends after a failed write to be refused, got %v", err)
+	}
+	if after, _ := os.Stat(segments[0].Path); after.Size() != info.Size() {
+		t.Errorf("Expected the partial frame cut off at %d bytes, segment is %d", info.Size(), after.Size())
+	}
+	j.Close()
+
+	j, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Expected the journal to reopen cleanly, got %v", err)
+	}
+	defer j.Close()
+	if seq, err := j.Append(RecordNewOrder, nil); err != nil || seq != 3 {
+		t.Errorf("Expected seq 3 after reopening, got %d (%v)", seq, err)
+	}
+	if records := readAll(t, dir, 1); len(records) != 3 {
+		t.Errorf("Expected 3 records, got %d", len(records))
+	}
+}
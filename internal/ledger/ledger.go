@@ -0,0 +1,334 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EntryType classifies what caused a journal entry
+type EntryType string
+
+const (
+	EntryTrade       EntryType = "trade_settlement"
+	EntryFee         EntryType = "fee"
+	EntryFunding     EntryType = "funding"
+	EntryLiquidation EntryType = "liquidation"
+	EntryDeposit     EntryType = "deposit"
+	EntryWithdrawal  EntryType = "withdrawal"
+	// EntryOpening brings the ledger in line with balances restored from
+	// outside it, e.g. from a snapshot
+	EntryOpening EntryType = "opening_balance"
+)
+
+// ExternalAccount is the counterparty of deposits, withdrawals and opening
+// balances
+const ExternalAccount = "external"
+
+// tolerance absorbs floating point rounding when checking balances
+const tolerance = 1e-9
+
+var ErrUnbalanced = errors.New("journal entry is not balanced")
+
+// Posting changes one account's balance of one asset. Credits are positive,
+// debits negative; the postings of an entry sum to zero per asset.
+type Posting struct {
+	Account string  `json:"account"`
+	Asset   string  `json:"asset"`
+	Amount  float64 `json:"amount"`
+}
+
+// Reference links an entry back to the trade and orders that caused it
+type Reference struct {
+	TradeID  string   `json:"trade_id,omitempty"`
+	OrderIDs []string `json:"order_ids,omitempty"`
+}
+
+// Entry is an immutable, balanced journal entry
+type Entry struct {
+	ID       string    `json:"id"`
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Type     EntryType `json:"type"`
+	Ref      Reference `json:"ref"`
+	Postings []Posting `json:"postings"`
+}
+
+// Ledger is an append-only double-entry journal
+type Ledger struct {
+	mu      sync.Mutex
+	entries []Entry
+	seq     uint64
+	now     func() time.Time
+}
+
+func New() *Ledger {
+	return &Ledger{
+		entries: make([]Entry, 0),
+		now:     time.Now,
+	}
+}
+
+// SetClock replaces the clock entries are stamped with
+func (l *Ledger) SetClock(now func() time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.now = now
+}
+
+// Post appends an entry after checking that it balances in every asset
+func (l *Ledger) Post(entryType EntryType, ref Reference, postings []Posting) (Entry, error) {
+	if len(postings) < 2 {
+		return Entry{}, fmt.Errorf("%w: need at least two postings", ErrUnbalanced)
+	}
+	sums := make(map[string]float64)
+	for _, p := range postings {
+		sums[p.Asset] += p.Amount
+	}
+	for asset, sum := range sums {
+		if math.Abs(sum) > tolerance {
+			return Entry{}, fmt.Errorf("%w: %s is off by %.12f", ErrUnbalanced, asset, sum)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	entry := Entry{
+		ID:       fmt.Sprintf("je-%d", l.seq),
+		Seq:      l.seq,
+		Time:     l.now(),
+		Type:     entryType,
+		Ref:      copyReference(ref),
+		Postings: append([]Posting(nil), postings...),
+	}
+	l.entries = append(l.entries, entry)
+	return copyEntry(entry), nil
+}
+
+// Entries returns the entries with a sequence number greater than afterSeq
+func (l *Ledger) Entries(afterSeq uint64) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]Entry, 0)
+	for _, entry := range l.entries {
+		if entry.Seq > afterSeq {
+			entries = append(entries, copyEntry(entry))
+		}
+	}
+	return entries
+}
+
+// Restore replaces the journal with entries as returned by Entries, so
+// entries posted afterwards carry on from the last one's sequence number
+func (l *Ledger) Restore(entries []Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = make([]Entry, 0, len(entries))
+	l.seq = 0
+	for _, entry := range entries {
+		l.entries = append(l.entries, copyEntry(entry))
+		if entry.Seq > l.seq {
+			l.seq = entry.Seq
+		}
+	}
+}
+
+// AssetTotals sums the postings of one asset across the journal
+type AssetTotals struct {
+	Debits  float64 `json:"debits"`
+	Credits float64 `json:"credits"`
+}
+
+// Difference is an account whose ledger total disagrees with its balance in
+// the books the ledger journals
+type Difference struct {
+	Account string  `json:"account"`
+	Asset   string  `json:"asset"`
+	Ledger  float64 `json:"ledger"`
+	Books   float64 `json:"books"`
+}
+
+// TrialBalance lists every account balance and checks debits equal credits
+// and, when reconciled, that every account agrees with the books
+type TrialBalance struct {
+	Balanced    bool                          `json:"balanced"`
+	Assets      map[string]AssetTotals        `json:"assets"`
+	Accounts    map[string]map[string]float64 `json:"accounts"`
+	Differences []Difference                  `json:"differences,omitempty"`
+}
+
+// TrialBalance recomputes all balances from the journal
+func (l *Ledger) TrialBalance() TrialBalance {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tb := TrialBalance{
+		Balanced: true,
+		Assets:   make(map[string]AssetTotals),
+		Accounts: make(map[string]map[string]float64),
+	}
+	for _, entry := range l.entries {
+		for _, p := range entry.Postings {
+			totals := tb.Assets[p.Asset]
+			if p.Amount < 0 {
+				totals.Debits -= p.Amount
+			} else {
+				totals.Credits += p.Amount
+			}
+			tb.Assets[p.Asset] = totals
+
+			if _, ok := tb.Accounts[p.Account]; !ok {
+				tb.Accounts[p.Account] = make(map[string]float64)
+			}
+			tb.Accounts[p.Account][p.Asset] += p.Amount
+		}
+	}
+	for _, totals := range tb.Assets {
+		if math.Abs(totals.Debits-totals.Credits) > tolerance*float64(len(l.entries)+1) {
+			tb.Balanced = false
+		}
+	}
+	return tb
+}
+
+// Reconcile is TrialBalance compared with the balances of the books, by
+// account and asset. Every account but ExternalAccount must have the same
+// total in both, or it is listed as a difference and the trial balance is not
+// balanced.
+func (l *Ledger) Reconcile(books map[string]map[string]float64) TrialBalance {
+	tb := l.TrialBalance()
+	l.mu.Lock()
+	slack := tolerance * float64(len(l.entries)+1)
+	l.mu.Unlock()
+
+	compare := func(account string, asset string) {
+		ledgerTotal, booksTotal := tb.Accounts[account][asset], books[account][asset]
+		if math.Abs(ledgerTotal-booksTotal) > slack {
+			tb.Differences = append(tb.Differences, Difference{Account: account, Asset: asset, Ledger: ledgerTotal, Books: booksTotal})
+		}
+	}
+	for account, assets := range books {
+		for asset := range assets {
+			compare(account, asset)
+		}
+	}
+	for account, assets := range tb.Accounts {
+		if account == ExternalAccount {
+			continue
+		}
+		for asset := range assets {
+			if _, ok := books[account][asset]; !ok {
+				compare(account, asset)
+			}
+		}
+	}
+	sort.Slice(tb.Differences, func(i, k int) bool {
+		if tb.Differences[i].Account != tb.Differences[k].Account {
+			return tb.Differences[i].Account < tb.Differences[k].Account
+		}
+		return tb.Differences[i].Asset < tb.Differences[k].Asset
+	})
+	if len(tb.Differences) > 0 {
+		tb.Balanced = false
+	}
+	return tb
+}
+
+// StatementLine is one posting on an account statement
+type StatementLine struct {
+	EntryID string    `json:"entry_id"`
+	Time    time.Time `json:"time"`
+	Type    EntryType `json:"type"`
+	Ref     Reference `json:"ref"`
+	Asset   string    `json:"asset"`
+	Amount  float64   `json:"amount"`
+	Balance float64   `json:"balance"`
+}
+
+// Statement is the activity of one account over a period
+type Statement struct {
+	Account string             `json:"account"`
+	Opening map[string]float64 `json:"opening"`
+	Closing map[string]float64 `json:"closing"`
+	Lines   []StatementLine    `json:"lines"`
+}
+
+// Statement returns the postings of an account between from (inclusive) and
+// to (exclusive). A zero from or to leaves that end open.
+func (l *Ledger) Statement(account string, from time.Time, to time.Time) Statement {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := Statement{
+		Account: account,
+		Opening: make(map[string]float64),
+		Closing: make(map[string]float64),
+		Lines:   make([]StatementLine, 0),
+	}
+	running := make(map[string]float64)
+	for _, entry := range l.entries {
+		if !to.IsZero() && !entry.Time.Before(to) {
+			break
+		}
+		for _, p := range entry.Postings {
+			if p.Account != account {
+				continue
+			}
+			running[p.Asset] += p.Amount
+			if !from.IsZero() && entry.Time.Before(from) {
+				st.Opening[p.Asset] = running[p.Asset]
+				continue
+			}
+			st.Lines = append(st.Lines, StatementLine{
+				EntryID: entry.ID,
+				Time:    entry.Time,
+				Type:    entry.Type,
+				Ref:     copyReference(entry.Ref),
+				Asset:   p.Asset,
+				Amount:  p.Amount,
+				Balance: running[p.Asset],
+			})
+		}
+	}
+	for asset, balance := range running {
+		st.Closing[asset] = balance
+	}
+	return st
+}
+
+// Accounts returns the names of all accounts that have postings
+func (l *Ledger) Accounts() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, entry := range l.entries {
+		for _, p := range entry.Postings {
+			seen[p.Account] = true
+		}
+	}
+	accounts := make([]string, 0, len(seen))
+	for account := range seen {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+func copyEntry(entry Entry) Entry {
+	entry.Ref = copyReference(entry.Ref)
+	entry.Postings = append([]Posting(nil), entry.Postings...)
+	return entry
+}
+
+func copyReference(ref Reference) Reference {
+	ref.OrderIDs = append([]string(nil), ref.OrderIDs...)
+	return ref
+}
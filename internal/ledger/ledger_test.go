@@ -0,0 +1,92 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPostRejectsUnbalancedEntries(t *testing.T) {
+	l := New()
+
+	_, err := l.Post(EntryDeposit, Reference{}, []Posting{
+		{Account: ExternalAccount, Asset: "USD", Amount: -100},
+		{Account: "alice", Asset: "USD", Amount: 90},
+	})
+	if !errors.Is(err, ErrUnbalanced) {
+		t.Errorf("Expected ErrUnbalanced, got %v", err)
+	}
+	if entries := l.Entries(0); len(entries) != 0 {
+		t.Errorf("Expected no entries after rejection, got %d", len(entries))
+	}
+}
+
+func TestTrialBalanceAndStatement(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	l := New()
+	l.now = func() time.Time { return now }
+
+	l.Post(EntryDeposit, Reference{}, []Posting{
+		{Account: ExternalAccount, Asset: "USD", Amount: -1000},
+		{Account: "alice", Asset: "USD", Amount: 1000},
+	})
+	now = now.Add(time.Hour)
+	entry, err := l.Post(EntryTrade, Reference{TradeID: "trd-1", OrderIDs: []string{"ord-1", "ord-2"}}, []Posting{
+		{Account: "alice", Asset: "USD", Amount: -300},
+		{Account: "bob", Asset: "USD", Amount: 300},
+		{Account: "bob", Asset: "BTC", Amount: -3},
+		{Account: "alice", Asset: "BTC", Amount: 3},
+	})
+	if err != nil {
+		t.Fatalf("Expected balanced entry to post, got %v", err)
+	}
+	if entry.ID != "je-2" || entry.Ref.TradeID != "trd-1" {
+		t.Errorf("Unexpected entry identity: %+v", entry)
+	}
+
+	tb := l.TrialBalance()
+	if !tb.Balanced {
+		t.Errorf("Expected trial balance to balance")
+	}
+	if tb.Accounts["alice"]["USD"] != 700 || tb.Accounts["alice"]["BTC"] != 3 {
+		t.Errorf("Unexpected alice balances: %+v", tb.Accounts["alice"])
+	}
+
+	st := l.Statement("alice", now, time.Time{})
+	if st.Opening["USD"] != 1000 || len(st.Lines) != 2 {
+		t.Fatalf("Expected opening 1000 and 2 lines, got %+v", st)
+	}
+	if st.Lines[0].Balance != 700 || st.Closing["BTC"] != 3 {
+		t.Errorf("Unexpected statement balances: %+v", st)
+	}
+}
+
+func TestReconcileFindsAccountsOffTheBooks(t *testing.T) {
+	l := New()
+	l.Post(EntryDeposit, Reference{}, []Posting{
+		{Account: ExternalAccount, Asset: "USD", Amount: -1000},
+		{Account: "alice", Asset: "USD", Amount: 1000},
+	})
+
+	if tb := l.Reconcile(map[string]map[string]float64{"alice": {"USD": 1000}}); !tb.Balanced || len(tb.Differences) != 0 {
+		t.Errorf("Expected the ledger to match the books, got %+v", tb)
+	}
+
+	// A balance moved without an entry, and one the books no longer hold
+	tb := l.Reconcile(map[string]map[string]float64{"bob": {"USD": 50}})
+	if tb.Balanced {
+		t.Error("Expected balances off the ledger to unbalance the trial balance")
+	}
+	expected := []Difference{
+		{Account: "alice", Asset: "USD", Ledger: 1000, Books: 0},
+		{Account: "bob", Asset: "USD", Ledger: 0, Books: 50},
+	}
+	if len(tb.Differences) != len(expected) {
+		t.Fatalf("Expected differences %+v, got %+v", expected, tb.Differences)
+	}
+	for i := range expected {
+		if tb.Differences[i] != expected[i] {
+			t.Errorf("Difference %d: expected %+v, got %+v", i, expected[i], tb.Differences[i])
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package margin
+
+import (
+	"errors"
+	"matching-engine/internal/account"
+	"testing"
+)
+
+type fixedPrices map[string]float64
+
+func (p fixedPrices) Price(asset string) float64 {
+	return p[asset]
+}
+
+func TestPositionApplyRealizesAndFlips(t *testing.T) {
+	book := NewPositionBook()
+
+	book.Apply("alice", "BTC", false, 2, 100, 5)
+	book.Apply("alice", "BTC", false, 2, 110, 5)
+	p, _ := book.Position("alice", "BTC", false)
+	if p.Size != 4 || p.EntryPrice != 105 {
+		t.Fatalf("Expected 4 @ 105, got %+v", p)
+	}
+
+	change := book.Apply("alice", "BTC", false, -6, 120, 5)
+	if change.Closed != 4 || change.Realized != 60 || change.Opened != 2 {
+		t.Errorf("Unexpected change: %+v", change)
+	}
+	p, _ = book.Position("alice", "BTC", false)
+	if p.Size != -2 || p.EntryPrice != 120 {
+		t.Errorf("Expected flipped short 2 @ 120, got %+v", p)
+	}
+}
+
+func TestPortfolioOffsetsCrossPnLAndFencesIsolated(t *testing.T) {
+	accounts := account.NewManager()
+	accounts.Deposit("alice", "USD", 1000)
+	accounts.Deposit("alice", "BTC", 1)
+	prices := fixedPrices{"BTC": 100, "ETH": 50}
+	m := NewManager(accounts, prices, Config{
+		QuoteAsset:      "USD",
+		Haircuts:        map[string]float64{"USD": 0, "BTC": 0.2},
+		MaintenanceRate: 0.01,
+	})
+
+	// Long BTC from 80 (+200) and short ETH from 40 (-100) offset each other
+	m.Positions().Apply("alice", "BTC", false, 10, 80, 10)
+	m.Positions().Apply("alice", "ETH", false, -10, 40, 5)
+	// Isolated loss must not reduce cross equity
+	m.Positions().Apply("alice", "ETH", true, 10, 70, 2)
+	accounts.PlaceHold(IsolatedHoldKey("alice", "ETH"), "alice", "USD", 350)
+
+	portfolio := m.Evaluate("alice")
+	if portfolio.CollateralValue != 650+80 {
+		t.Errorf("Expected collateral 730, got %f", portfolio.CollateralValue)
+	}
+	if portfolio.UnrealizedPnL != 100 {
+		t.Errorf("Expected net cross P&L 100, got %f", portfolio.UnrealizedPnL)
+	}
+	if portfolio.Equity != 830 {
+		t.Errorf("Expected equity 830, got %f", portfolio.Equity)
+	}
+	if portfolio.InitialMargin != 100+100 {
+		t.Errorf("Expected initial margin 200, got %f", portfolio.InitialMargin)
+	}
+	if len(portfolio.Isolated) != 1 || portfolio.Isolated[0].Equity != 150 {
+		t.Errorf("Expected isolated equity 350-200=150, got %+v", portfolio.Isolated)
+	}
+
+	if err := m.CheckOrder("alice", "BTC", false, 100, 100, 10); !errors.Is(err, ErrInsufficientMargin) {
+		t.Errorf("Expected 1000 margin to exceed 630 free, got %v", err)
+	}
+	if err := m.CheckOrder("alice", "BTC", false, -10, 100, 1); err != nil {
+		t.Errorf("Expected reducing order to need no margin, got %v", err)
+	}
+}
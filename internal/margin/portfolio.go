@@ -0,0 +1,222 @@
+package margin
+
+import (
+	"errors"
+	"fmt"
+	"matching-engine/internal/account"
+	"math"
+	"sort"
+)
+
+var ErrInsufficientMargin = errors.New("insufficient margin")
+
+// PriceSource values assets in the quote asset
+type PriceSource interface {
+	Price(asset string) float64
+}
+
+// Config sets which assets count as collateral and the margin rates
+type Config struct {
+	QuoteAsset string
+	// Haircuts maps each accepted collateral asset to the share of its value
+	// that is discounted; assets not listed do not count as collateral
+	Haircuts        map[string]float64
+	MaintenanceRate float64
+}
+
+// CollateralValue is one asset's contribution to portfolio collateral
+type CollateralValue struct {
+	Balance float64 `json:"balance"`
+	Price   float64 `json:"price"`
+	Haircut float64 `json:"haircut"`
+	Value   float64 `json:"value"`
+}
+
+// PositionRisk is a position valued at the current mark price
+type PositionRisk struct {
+	Position
+	MarkPrice         float64 `json:"mark_price"`
+	UnrealizedPnL     float64 `json:"unrealized_pnl"`
+	InitialMargin     float64 `json:"initial_margin"`
+	MaintenanceMargin float64 `json:"maintenance_margin"`
+	// Set for isolated positions, which are margined on their own
+	Margin      float64 `json:"margin,omitempty"`
+	Equity      float64 `json:"equity,omitempty"`
+	MarginRatio float64 `json:"margin_ratio,omitempty"`
+}
+
+// Portfolio is the cross-margin state of a trader. All cross positions share
+// the collateral and each other's P&L; isolated positions are listed but only
+// ever draw on their own margin.
+type Portfolio struct {
+	Trader            string                     `json:"trader"`
+	Collateral        map[string]CollateralValue `json:"collateral"`
+	CollateralValue   float64                    `json:"collateral_value"`
+	UnrealizedPnL     float64                    `json:"unrealized_pnl"`
+	Equity            float64                    `json:"equity"`
+	InitialMargin     float64                    `json:"initial_margin"`
+	MaintenanceMargin float64                    `json:"maintenance_margin"`
+	FreeMargin        float64                    `json:"free_margin"`
+	MarginRatio       float64                    `json:"margin_ratio"`
+	Cross             []PositionRisk             `json:"cross"`
+	Isolated          []PositionRisk             `json:"isolated"`
+}
+
+// Manager evaluates positions against the collateral held in accounts
+type Manager struct {
+	positions *PositionBook
+	accounts  *account.Manager
+	prices    PriceSource
+	config    Config
+}
+
+func NewManager(accounts *account.Manager, prices PriceSource, config Config) *Manager {
+	return &Manager{
+		positions: NewPositionBook(),
+		accounts:  accounts,
+		prices:    prices,
+		config:    config,
+	}
+}
+
+// Positions returns the position book
+func (m *Manager) Positions() *PositionBook {
+	return m.positions
+}
+
+// Config returns the collateral and margin settings
+func (m *Manager) Config() Config {
+	return m.config
+}
+
+// IsolatedHoldKey names the account hold that ring-fences the margin of an
+// isolated position
+func IsolatedHoldKey(trader string, asset string) string {
+	return "isolated:" + trader + ":" + asset
+}
+
+// Evaluate computes the portfolio margin of a trader
+func (m *Manager) Evaluate(trader string) Portfolio {
+	portfolio := Portfolio{
+		Trader:     trader,
+		Collateral: make(map[string]CollateralValue),
+		Cross:      make([]PositionRisk, 0),
+		Isolated:   make([]PositionRisk, 0),
+	}
+
+	// Only available funds count: held funds back resting orders or
+	// isolated positions
+	balances := m.accounts.Balances(trader)
+	assets := make([]string, 0, len(balances))
+	for asset := range balances {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	for _, asset := range assets {
+		haircut, ok := m.config.Haircuts[asset]
+		if !ok || balances[asset].Available == 0 {
+			continue
+		}
+		price := m.price(asset)
+		value := CollateralValue{
+			Balance: balances[asset].Available,
+			Price:   price,
+			Haircut: haircut,
+			Value:   balances[asset].Available * price * (1 - haircut),
+		}
+		portfolio.Collateral[asset] = value
+		portfolio.CollateralValue += value.Value
+	}
+
+	for _, p := range m.positions.TraderPositions(trader) {
+		r := m.valuePosition(p)
+		if p.Isolated {
+			hold, _ := m.accounts.HoldFor(IsolatedHoldKey(trader, p.Asset))
+			r.Margin = hold.Amount
+			r.Equity = hold.Amount + r.UnrealizedPnL
+			r.MarginRatio = ratio(r.MaintenanceMargin, r.Equity)
+			portfolio.Isolated = append(portfolio.Isolated, r)
+			continue
+		}
+		portfolio.UnrealizedPnL += r.UnrealizedPnL
+		portfolio.InitialMargin += r.InitialMargin
+		portfolio.MaintenanceMargin += r.MaintenanceMargin
+		portfolio.Cross = append(portfolio.Cross, r)
+	}
+
+	portfolio.Equity = portfolio.CollateralValue + portfolio.UnrealizedPnL
+	portfolio.FreeMargin = portfolio.Equity - portfolio.InitialMargin
+	portfolio.MarginRatio = ratio(portfolio.MaintenanceMargin, portfolio.Equity)
+	return portfolio
+}
+
+// CheckOrder verifies a trader can margin the part of an order that grows
+// their position. quantity is signed: positive buys, negative sells.
+func (m *Manager) CheckOrder(trader string, asset string, isolated bool, quantity float64, price float64, leverage int64) error {
+	increase := math.Abs(quantity)
+	if p, ok := m.positions.Position(trader, asset, isolated); ok && (p.Size > 0) != (quantity > 0) {
+		increase = math.Max(0, math.Abs(quantity)-math.Abs(p.Size))
+	}
+	required := InitialMargin(increase*price, leverage)
+	if required == 0 {
+		return nil
+	}
+
+	if isolated {
+		// Isolated margin is posted in the quote asset only
+		available := m.accounts.Balance(trader, m.config.QuoteAsset).Available
+		if available < required {
+			return fmt.Errorf("%w: isolated %s needs %.8f %s, %.8f available",
+				ErrInsufficientMargin, asset, required, m.config.QuoteAsset, available)
+		}
+		return nil
+	}
+
+	if free := m.Evaluate(trader).FreeMargin; free < required {
+		return fmt.Errorf("%w: cross %s needs %.8f, %.8f free", ErrInsufficientMargin, asset, required, free)
+	}
+	return nil
+}
+
+func (m *Manager) valuePosition(p Position) PositionRisk {
+	mark := m.price(p.Asset)
+	notional := p.Notional(mark)
+	return PositionRisk{
+		Position:          p,
+		MarkPrice:         mark,
+		UnrealizedPnL:     p.UnrealizedPnL(mark),
+		InitialMargin:     InitialMargin(notional, p.Leverage),
+		MaintenanceMargin: notional * m.config.MaintenanceRate,
+	}
+}
+
+func (m *Manager) price(asset string) float64 {
+	if asset == m.config.QuoteAsset {
+		return 1
+	}
+	return m.prices.Price(asset)
+}
+
+// InitialMargin returns the margin needed to open notional at leverage
+func InitialMargin(notional float64, leverage int64) float64 {
+	return notional / float64(effectiveLeverage(leverage))
+}
+
+func effectiveLeverage(leverage int64) int64 {
+	if leverage < 1 {
+		return 1
+	}
+	return leverage
+}
+
+// ratio returns maintenance over equity. Exhausted equity reports the largest
+// float rather than +Inf so the portfolio still encodes as JSON.
+func ratio(maintenance float64, equity float64) float64 {
+	if maintenance == 0 {
+		return 0
+	}
+	if equity <= 0 {
+		return math.MaxFloat64
+	}
+	return maintenance / equity
+}
@@ -0,0 +1,212 @@
+package margin
+
+import (
+	"matching-engine/internal/risk"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Position is a trader's net exposure to one asset under one margin mode.
+// Size is positive for longs and negative for shorts.
+type Position struct {
+	Trader      string  `json:"trader"`
+	Asset       string  `json:"asset"`
+	Isolated    bool    `json:"isolated"`
+	Size        float64 `json:"size"`
+	EntryPrice  float64 `json:"entry_price"`
+	Leverage    int64   `json:"leverage"`
+	RealizedPnL float64 `json:"realized_pnl"`
+}
+
+// UnrealizedPnL returns the profit or loss of the position at markPrice
+func (p Position) UnrealizedPnL(markPrice float64) float64 {
+	return (markPrice - p.EntryPrice) * p.Size
+}
+
+// Notional returns the absolute value of the position at markPrice
+func (p Position) Notional(markPrice float64) float64 {
+	return math.Abs(p.Size) * markPrice
+}
+
+// Change describes what a fill did to a position
+type Change struct {
+	Opened   float64 // quantity added in the direction of the position
+	Closed   float64 // quantity that reduced the previous position
+	Realized float64 // P&L realized by the closed quantity
+	// SizeBefore is the absolute size before the fill
+	SizeBefore float64
+}
+
+type positionKey struct {
+	trader   string
+	asset    string
+	isolated bool
+}
+
+// PositionBook tracks open positions built up from fills
+type PositionBook struct {
+	mu        sync.Mutex
+	positions map[positionKey]*Position
+}
+
+func NewPositionBook() *PositionBook {
+	return &PositionBook{
+		positions: make(map[positionKey]*Position),
+	}
+}
+
+// Apply adds a signed fill quantity at price to a position. Fills against the
+// position's direction realize P&L; a fill larger than the position flips it
+// and opens the remainder at price.
+func (b *PositionBook) Apply(trader string, asset string, isolated bool, quantity float64, price float64, leverage int64) Change {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	next, change := b.current(trader, asset, isolated).Fill(quantity, price, leverage)
+	b.set(next)
+	return change
+}
+
+// Current returns a position as Apply would start from it, flat if none is
+// open
+func (b *PositionBook) Current(trader string, asset string, isolated bool) Position {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current(trader, asset, isolated)
+}
+
+// Set stores a position worked out with Fill
+func (b *PositionBook) Set(p Position) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.set(p)
+}
+
+// current must be called with b.mu held
+func (b *PositionBook) current(trader string, asset string, isolated bool) Position {
+	if p, ok := b.positions[positionKey{trader: trader, asset: asset, isolated: isolated}]; ok {
+		return *p
+	}
+	return Position{Trader: trader, Asset: asset, Isolated: isolated}
+}
+
+// set must be called with b.mu held
+func (b *PositionBook) set(p Position) {
+	b.positions[positionKey{trader: p.Trader, asset: p.Asset, isolated: p.Isolated}] = &p
+}
+
+// Fill returns the position after adding a signed fill quantity at price, as
+// Apply does, and what the fill did to it. The position itself is unchanged.
+func (p Position) Fill(quantity float64, price float64, leverage int64) (Position, Change) {
+	if leverage > 0 {
+		p.Leverage = leverage
+	} else if p.Leverage == 0 {
+		p.Leverage = 1
+	}
+
+	change := Change{SizeBefore: math.Abs(p.Size)}
+	if p.Size == 0 || (p.Size > 0) == (quantity > 0) {
+		total := math.Abs(p.Size) + math.Abs(quantity)
+		p.EntryPrice = (math.Abs(p.Size)*p.EntryPrice + math.Abs(quantity)*price) / total
+		p.Size += quantity
+		change.Opened = math.Abs(quantity)
+		return p, change
+	}
+
+	change.Closed = math.Min(math.Abs(quantity), math.Abs(p.Size))
+	direction := 1.0
+	if p.Size < 0 {
+		direction = -1.0
+	}
+	change.Realized = change.Closed * (price - p.EntryPrice) * direction
+	p.RealizedPnL += change.Realized
+	p.Size += quantity
+
+	switch {
+	case math.Abs(p.Size) < dust:
+		p.Size = 0
+		p.EntryPrice = 0
+	case (p.Size > 0) != (direction > 0):
+		// Flipped: the rest of the fill opens a new position
+		change.Opened = math.Abs(p.Size)
+		p.EntryPrice = price
+	}
+	return p, change
+}
+
+// Restore replaces every position, e.g. with those of a snapshot
+func (b *PositionBook) Restore(positions []Position) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.positions = make(map[positionKey]*Position, len(positions))
+	for _, p := range positions {
+		p := p
+		b.positions[positionKey{trader: p.Trader, asset: p.Asset, isolated: p.Isolated}] = &p
+	}
+}
+
+// Position returns one position, if open
+func (b *PositionBook) Position(trader string, asset string, isolated bool) (Position, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, ok := b.positions[positionKey{trader: trader, asset: asset, isolated: isolated}]
+	if !ok || p.Size == 0 {
+		return Position{}, false
+	}
+	return *p, true
+}
+
+// TraderPositions returns the open positions of a trader
+func (b *PositionBook) TraderPositions(trader string) []Position {
+	return b.filter(func(p *Position) bool { return p.Trader == trader })
+}
+
+// All returns every open position
+func (b *PositionBook) All() []Position {
+	return b.filter(func(p *Position) bool { return true })
+}
+
+// Positions returns the open positions of an asset for the ADL queue
+func (b *PositionBook) Positions(asset string) []risk.Position {
+	positions := make([]risk.Position, 0)
+	for _, p := range b.filter(func(p *Position) bool { return p.Asset == asset }) {
+		positions = append(positions, risk.Position{
+			Trader:     p.Trader,
+			Asset:      p.Asset,
+			Isolated:   p.Isolated,
+			IsLong:     p.Size > 0,
+			Size:       math.Abs(p.Size),
+			EntryPrice: p.EntryPrice,
+			Leverage:   p.Leverage,
+		})
+	}
+	return positions
+}
+
+func (b *PositionBook) filter(keep func(p *Position) bool) []Position {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	positions := make([]Position, 0)
+	for _, p := range b.positions {
+		if p.Size != 0 && keep(p) {
+			positions = append(positions, *p)
+		}
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Trader != positions[j].Trader {
+			return positions[i].Trader < positions[j].Trader
+		}
+		if positions[i].Asset != positions[j].Asset {
+			return positions[i].Asset < positions[j].Asset
+		}
+		return !positions[i].Isolated && positions[j].Isolated
+	})
+	return positions
+}
+
+// dust treats floating point leftovers of a closed position as flat
+const dust = 1e-12
@@ -0,0 +1,117 @@
+package replication
+
+import (
+	"fmt"
+	"matching-engine/internal/engine"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// EpochFence is an engine.Fence backed by an epoch file shared by every
+// node. A node becomes primary by incrementing the epoch; a primary whose
+// epoch is no longer the file's has been superseded and is fenced for good.
+type EpochFence struct {
+	path string
+
+	mu      sync.Mutex
+	epoch   uint64 // 0 until the node is primary
+	primary bool
+}
+
+// NewStandbyFence returns a fence refusing commands until Acquire is called
+func NewStandbyFence(path string) *EpochFence {
+	return &EpochFence{path: path}
+}
+
+// AcquireFence makes the caller primary, fencing any earlier primary
+func AcquireFence(path string) (*EpochFence, error) {
+	f := NewStandbyFence(path)
+	if err := f.Acquire(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Acquire increments the epoch in the file and takes it as this node's
+func (f *EpochFence) Acquire() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// Two nodes promoting at once must not end up with the same epoch
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("locking %s: %w", f.path, err)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	buf := make([]byte, 64)
+	n, _ := file.ReadAt(buf, 0)
+	current, err := parseEpoch(buf[:n])
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", f.path, err)
+	}
+
+	// Fixed width, so readers never see a partly written number
+	epoch := current + 1
+	if _, err := file.WriteAt([]byte(fmt.Sprintf("%020d\n", epoch)), 0); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	f.epoch, f.primary = epoch, true
+	return nil
+}
+
+// Check implements engine.Fence
+func (f *EpochFence) Check() error {
+	f.mu.Lock()
+	epoch, primary := f.epoch, f.primary
+	f.mu.Unlock()
+
+	if !primary {
+		return engine.ErrNotPrimary
+	}
+	current, err := ReadEpoch(f.path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", engine.ErrFenced, err)
+	}
+	if current != epoch {
+		return fmt.Errorf("%w: epoch %d, file has %d", engine.ErrFenced, epoch, current)
+	}
+	return nil
+}
+
+// Epoch returns the node's epoch, 0 for a standby
+func (f *EpochFence) Epoch() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.epoch
+}
+
+// ReadEpoch returns the epoch in the file at path; a missing file is epoch 0
+func ReadEpoch(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parseEpoch(data)
+}
+
+func parseEpoch(data []byte) (uint64, error) {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(text, 10, 64)
+}
@@ -0,0 +1,285 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"matching-engine/internal/journal"
+	"matching-engine/pkg/utils"
+	"net"
+	"sync"
+	"time"
+)
+
+// Primary serves its journal to standbys
+type Primary struct {
+	journal   *journal.Journal
+	fence     *EpochFence
+	heartbeat time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// NewPrimary returns a Primary streaming j. Streaming stops once fence
+// reports that a standby has been promoted.
+func NewPrimary(j *journal.Journal, fence *EpochFence) *Primary {
+	return &Primary{
+		journal:   j,
+		fence:     fence,
+		heartbeat: DefaultHeartbeat,
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// SetHeartbeat changes how often standbys are told the primary is alive
+func (p *Primary) SetHeartbeat(interval time.Duration) {
+	p.heartbeat = interval
+}
+
+// ListenAndServe accepts standbys on addr until Close is called
+func (p *Primary) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(listener)
+}
+
+// Serve accepts standbys on listener until Close is called
+func (p *Primary) Serve(listener net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+	p.listener = listener
+	p.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		if !p.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer p.untrack(conn)
+			if err := p.serve(conn); err != nil {
+				utils.LogError(fmt.Errorf("replication to %s: %w", conn.RemoteAddr(), err))
+			}
+		}()
+	}
+}
+
+// Close stops accepting standbys and disconnects the connected ones
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	if p.listener != nil {
+		return p.listener.Close()
+	}
+	return nil
+}
+
+// Status implements Node
+func (p *Primary) Status() Status {
+	p.mu.Lock()
+	standbys := len(p.conns)
+	p.mu.Unlock()
+
+	return Status{
+		Role:     RolePrimary,
+		Epoch:    p.fence.Epoch(),
+		Seq:      p.journal.NextSeq() - 1,
+		Standbys: standbys,
+		Fenced:   p.fence.Check() != nil,
+	}
+}
+
+// Promote implements Node; a primary has nothing to do
+func (p *Primary) Promote() error {
+	return nil
+}
+
+func (p *Primary) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *Primary) untrack(conn net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+	conn.Close()
+}
+
+// serve streams the journal to one standby until it disconnects, falls
+// behind the live stream, or this primary is fenced
+func (p *Primary) serve(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(p.heartbeat * 5))
+	var handshake [handshakeSize]byte
+	if _, err := io.ReadFull(conn, handshake[:]); err != nil {
+		return fmt.Errorf("reading handshake: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	from := binary.BigEndian.Uint64(handshake[0:8])
+	if from == 0 {
+		from = 1
+	}
+
+	w := &streamWriter{conn: conn, w: bufio.NewWriter(conn), timeout: p.heartbeat * 5}
+	if err := p.admit(from, binary.BigEndian.Uint64(handshake[8:16]), binary.BigEndian.Uint32(handshake[16:20])); err != nil {
+		w.refuse(err.Error())
+		w.flush()
+		return err
+	}
+
+	// Subscribe before reading the files, so no record falls in between
+	live, unsubscribe := p.journal.Subscribe(4096)
+	defer unsubscribe()
+
+	next := from
+	if err := w.heartbeat(p.fence.Epoch(), p.journal.NextSeq()-1); err != nil {
+		return err
+	}
+	err := journal.Read(p.journal.Dir(), from, func(record journal.Record) error {
+		if record.Seq != next {
+			return fmt.Errorf("journal has record %d where %d was expected", record.Seq, next)
+		}
+		next++
+		return w.record(record)
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(p.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case record, ok := <-live:
+			if !ok {
+				// Dropped for falling behind, or the journal closed; the
+				// standby reconnects and catches up from the files
+				return nil
+			}
+			if record.Seq < next {
+				continue
+			}
+			if record.Seq > next {
+				return fmt.Errorf("live stream skipped from %d to %d", next, record.Seq)
+			}
+			next++
+			if err := w.record(record); err != nil {
+				return err
+			}
+			if len(live) == 0 {
+				if err := w.flush(); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := p.fence.Check(); err != nil {
+				return err
+			}
+			if err := w.heartbeat(p.fence.Epoch(), next-1); err != nil {
+				return err
+			}
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// admit checks that a standby's journal is a prefix of this one: that it
+// never followed a newer primary, does not run past this journal and ends
+// on the same record
+func (p *Primary) admit(from uint64, epoch uint64, checksum uint32) error {
+	if current := p.fence.Epoch(); epoch > current {
+		return fmt.Errorf("standby followed epoch %d, this primary is at %d", epoch, current)
+	}
+	if next := p.journal.NextSeq(); from > next {
+		return fmt.Errorf("%w: standby has records up to %d but the journal ends at %d", ErrDiverged, from-1, next-1)
+	}
+	if from == 1 {
+		return nil
+	}
+	record, ok, err := journal.Find(p.journal.Dir(), from-1)
+	if err != nil {
+		return fmt.Errorf("reading record %d: %w", from-1, err)
+	}
+	if !ok || record.Checksum() != checksum {
+		return fmt.Errorf("%w: standby's record %d differs", ErrDiverged, from-1)
+	}
+	return nil
+}
+
+type streamWriter struct {
+	conn    net.Conn
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+func (s *streamWriter) record(record journal.Record) error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if err := s.w.WriteByte(msgRecord); err != nil {
+		return err
+	}
+	return journal.WriteRecord(s.w, record)
+}
+
+func (s *streamWriter) heartbeat(epoch uint64, seq uint64) error {
+	var msg [17]byte
+	msg[0] = msgHeartbeat
+	binary.BigEndian.PutUint64(msg[1:9], epoch)
+	binary.BigEndian.PutUint64(msg[9:17], seq)
+	_, err := s.w.Write(msg[:])
+	return err
+}
+
+func (s *streamWriter) refuse(reason string) error {
+	if len(reason) > 0xffff {
+		reason = reason[:0xffff]
+	}
+	var msg [3]byte
+	msg[0] = msgRefused
+	binary.BigEndian.PutUint16(msg[1:3], uint16(len(reason)))
+	if _, err := s.w.Write(msg[:]); err != nil {
+		return err
+	}
+	_, err := s.w.WriteString(reason)
+	return err
+}
+
+// flush sends buffered messages; a standby that stops reading is dropped
+func (s *streamWriter) flush() error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	return s.w.Flush()
+}
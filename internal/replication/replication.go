@@ -0,0 +1,82 @@
+// Package replication streams the primary's journal to standbys over TCP.
+//
+// A standby connects and sends a handshake of big endian fields:
+//
+//	[8 first record it is missing][8 epoch it last followed][4 checksum of its last record]
+//
+// The primary answers with a stream of messages, each a kind byte followed
+// by its body:
+//
+//	'R' a journal record, framed as in the journal files
+//	'H' a heartbeat: [8 epoch][8 last journal sequence number]
+//	'E' a refusal: [2 length][reason], after which the primary hangs up
+//
+// A standby that followed a newer epoch, or whose last record differs from
+// the primary's or lies beyond its journal, is refused: its journal has
+// diverged, typically as a primary fenced after its last append, and it
+// must be rebuilt. A refused standby, or one that fails to apply a record,
+// stops following and will not promote until an operator restarts it.
+//
+// Records arrive in sequence order without gaps; a standby that sees a gap
+// drops the connection and asks again from the first record it is missing.
+// Replication is asynchronous: commands the primary acknowledged but had not
+// streamed before it died are lost. The epoch fence only guarantees that the
+// old primary acknowledges nothing once a standby has been promoted.
+package replication
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	msgRecord    byte = 'R'
+	msgHeartbeat byte = 'H'
+	msgRefused   byte = 'E'
+
+	handshakeSize = 20
+)
+
+var (
+	// ErrDiverged means a standby's journal holds records the primary's
+	// does not
+	ErrDiverged = errors.New("standby journal diverged from the primary")
+	// ErrUnhealthy refuses to promote a standby that stopped following
+	// after a failure
+	ErrUnhealthy = errors.New("standby unhealthy")
+)
+
+// DefaultHeartbeat is how often the primary tells standbys it is alive
+const DefaultHeartbeat = time.Second
+
+// Role names what a node currently does
+type Role string
+
+const (
+	RolePrimary Role = "primary"
+	RoleStandby Role = "standby"
+)
+
+// Status describes a node for operators
+type Status struct {
+	Role  Role   `json:"role"`
+	Epoch uint64 `json:"epoch"`
+	// Seq is the last journal record written locally
+	Seq uint64 `json:"seq"`
+	// PrimarySeq is the primary's last record as of its last heartbeat;
+	// standbys only
+	PrimarySeq  uint64    `json:"primary_seq,omitempty"`
+	LastContact time.Time `json:"last_contact,omitempty"`
+	Standbys    int       `json:"standbys,omitempty"`
+	Fenced      bool      `json:"fenced,omitempty"`
+	// Error is why a standby stopped following; it neither follows nor
+	// promotes again until restarted
+	Error string `json:"error,omitempty"`
+}
+
+// Node is a primary or standby as seen by the admin API
+type Node interface {
+	Status() Status
+	// Promote makes a standby primary; it does nothing on a primary
+	Promote() error
+}
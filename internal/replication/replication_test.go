@@ -0,0 +1,343 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"matching-engine/internal/account"
+	"matching-engine/internal/engine"
+	"matching-engine/internal/engine/liquiditypool/amm"
+	"matching-engine/internal/journal"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newEngine(t *testing.T) *engine.MatchingEngine {
+	pool := amm.New(0.003)
+	if err := pool.AddPool("BTC", 100, 10000); err != nil {
+		t.Fatal(err)
+	}
+	e := engine.NewMatchingEngine(pool)
+	e.SetAccountManager(account.NewManager(), "USD")
+	return e
+}
+
+func openJournal(t *testing.T) *journal.Journal {
+	wal, err := journal.Open(t.TempDir(), journal.Options{Sync: journal.SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wal.Close() })
+	return wal
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newStandby(t *testing.T, addr string, epochFile string) (*Standby, *engine.MatchingEngine, *journal.Journal) {
+	e := newEngine(t)
+	wal := openJournal(t)
+	fence := NewStandbyFence(epochFile)
+	e.SetFence(fence)
+	standby := NewStandby(e, wal, fence, addr)
+	standby.Heartbeat = 50 * time.Millisecond
+	standby.RetryInterval = 10 * time.Millisecond
+	if _, err := standby.Recover(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	return standby, e, wal
+}
+
+func TestStandbyFollowsAndTakesOver(t *testing.T) {
+	epochFile := filepath.Join(t.TempDir(), "epoch")
+
+	primaryEngine := newEngine(t)
+	primaryWAL := openJournal(t)
+	fence, err := AcquireFence(epochFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryEngine.SetFence(fence)
+	primaryEngine.SetJournal(primaryWAL)
+
+	for _, trader := range []string{"alice", "bob"} {
+		primaryEngine.Deposit(trader, "USD", 10000)
+		primaryEngine.Deposit(trader, "BTC", 10)
+	}
+	primaryEngine.ProcessOrder(engine.Order{Trader: "bob", Asset: "BTC", Price: 101, Amount: 2, Type: engine.Limit})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := NewPrimary(primaryWAL, fence)
+	primary.SetHeartbeat(50 * time.Millisecond)
+	go primary.Serve(listener)
+	defer primary.Close()
+
+	standby, standbyEngine, standbyWAL := newStandby(t, listener.Addr().String(), epochFile)
+	done := make(chan error, 1)
+	go func() { done <- standby.Run(context.Background(), 0) }()
+
+	if err := standbyEngine.Deposit("carol", "USD", 100); !errors.Is(err, engine.ErrNotPrimary) {
+		t.Errorf("Expected the standby to refuse a deposit, got %v", err)
+	}
+	if result := standbyEngine.ProcessOrder(engine.Order{Trader: "alice", Asset: "BTC", Price: 99, Amount: 1, Type: engine.Limit, IsBuyOrder: true}); result.Success {
+		t.Errorf("Expected the standby to refuse an order, got %+v", result)
+	}
+
+	// Orders after the standby connected arrive over the live stream; one
+	// fills against the book and then the pool
+	primaryEngine.ProcessOrder(engine.Order{Trader: "alice", Asset: "BTC", Amount: 3, Type: engine.Market, IsBuyOrder: true})
+	primaryEngine.ProcessOrder(engine.Order{Trader: "alice", Asset: "BTC", Price: 95, Amount: 1, Type: engine.Limit, IsBuyOrder: true})
+	primaryEngine.Deposit("carol", "USD", 500)
+
+	waitFor(t, "the standby to catch up", func() bool { return standbyWAL.NextSeq() == primaryWAL.NextSeq() })
+	if status := standby.Status(); status.Role != RoleStandby || status.Seq != primaryWAL.NextSeq()-1 {
+		t.Errorf("Unexpected standby status %+v", status)
+	}
+	if standbyEngine.StateHash() != primaryEngine.StateHash() {
+		t.Fatalf("Standby state differs:\nprimary %+v\nstandby %+v", primaryEngine.State(), standbyEngine.State())
+	}
+
+	if err := standby.Promote(); err != nil {
+		t.Fatalf("Promote failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Run to end cleanly on promotion, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not end after promotion")
+	}
+
+	// The old primary is fenced and acknowledges nothing more
+	if err := primaryEngine.Deposit("alice", "USD", 1); !errors.Is(err, engine.ErrFenced) {
+		t.Errorf("Expected the old primary to be fenced, got %v", err)
+	}
+	if result := primaryEngine.ProcessOrder(engine.Order{Trader: "bob", Asset: "BTC", Price: 120, Amount: 1, Type: engine.Limit}); result.Success {
+		t.Errorf("Expected the old primary to refuse an order, got %+v", result)
+	}
+
+	next := standbyWAL.NextSeq()
+	if err := standbyEngine.Deposit("carol", "USD", 100); err != nil {
+		t.Fatalf("Expected the promoted standby to take a deposit, got %v", err)
+	}
+	if standbyWAL.NextSeq() != next+1 {
+		t.Errorf("Expected the promoted standby to journal the deposit")
+	}
+	if status := standby.Status(); status.Role != RolePrimary || status.Epoch != fence.Epoch()+1 {
+		t.Errorf("Unexpected status after promotion %+v", status)
+	}
+}
+
+func TestStandbyReconnectsAfterGap(t *testing.T) {
+	// Records of a real session to feed from a fake primary
+	source := newEngine(t)
+	sourceWAL := openJournal(t)
+	source.SetJournal(sourceWAL)
+	for _, amount := range []float64{100, 200, 300} {
+		source.Deposit("alice", "USD", amount)
+	}
+	records := make([]journal.Record, 0)
+	if err := journal.Read(sourceWAL.Dir(), 1, func(record journal.Record) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	handshakes := make(chan uint64, 4)
+	go func() {
+		for attempt := 0; ; attempt++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var handshake [handshakeSize]byte
+			if _, err := io.ReadFull(conn, handshake[:]); err != nil {
+				conn.Close()
+				continue
+			}
+			from := binary.BigEndian.Uint64(handshake[0:8])
+			handshakes <- from
+			w := &streamWriter{conn: conn, w: bufio.NewWriter(conn), timeout: time.Second}
+			if attempt == 0 {
+				// Skip record 2
+				w.record(records[0])
+				w.record(records[2])
+			} else {
+				for _, record := range records[from-1:] {
+					w.record(record)
+				}
+			}
+			w.flush()
+		}
+	}()
+
+	standby, standbyEngine, standbyWAL := newStandby(t, listener.Addr().String(), filepath.Join(t.TempDir(), "epoch"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go standby.Run(ctx, 0)
+
+	for i, expected := range []uint64{1, 2} {
+		select {
+		case from := <-handshakes:
+			if from != expected {
+				t.Errorf("Connection %d: expected to ask from record %d, got %d", i+1, expected, from)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Standby did not reconnect after the gap")
+		}
+	}
+	waitFor(t, "the standby to catch up", func() bool { return standbyWAL.NextSeq() == sourceWAL.NextSeq() })
+	if standbyEngine.StateHash() != source.StateHash() {
+		t.Fatalf("Standby state differs:\nsource %+v\nstandby %+v", source.State(), standbyEngine.State())
+	}
+}
+
+func TestDivergedStandbyIsRefused(t *testing.T) {
+	epochFile := filepath.Join(t.TempDir(), "epoch")
+	primaryEngine := newEngine(t)
+	primaryWAL := openJournal(t)
+	fence, err := AcquireFence(epochFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryEngine.SetFence(fence)
+	primaryEngine.SetJournal(primaryWAL)
+	primaryEngine.Deposit("alice", "USD", 100)
+	primaryEngine.Deposit("alice", "USD", 200)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := NewPrimary(primaryWAL, fence)
+	primary.SetHeartbeat(50 * time.Millisecond)
+	go primary.Serve(listener)
+	defer primary.Close()
+
+	// The standby's second record is not the primary's, as when an old
+	// primary appended once more after being fenced
+	standby, _, standbyWAL := newStandby(t, listener.Addr().String(), epochFile)
+	for _, amount := range []float64{100, 250} {
+		payload, _ := json.Marshal(map[string]interface{}{"trader": "alice", "asset": "USD", "amount": amount})
+		if _, err := standbyWAL.Append(journal.RecordDeposit, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- standby.Run(context.Background(), time.Millisecond) }()
+	select {
+	case err := <-done:
+		if err == nil || standby.Promoted() {
+			t.Fatalf("Expected the standby to stop without promoting, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Standby kept following a primary that refused it")
+	}
+	if status := standby.Status(); status.Error == "" || status.Role != RoleStandby {
+		t.Errorf("Expected the standby reported unhealthy, got %+v", status)
+	}
+	if err := standby.Promote(); !errors.Is(err, ErrUnhealthy) {
+		t.Errorf("Expected Promote to refuse, got %v", err)
+	}
+	if err := primaryEngine.Deposit("alice", "USD", 1); err != nil {
+		t.Errorf("Expected the primary to stay primary, got %v", err)
+	}
+}
+
+func TestStandbyStopsOnApplyFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var handshake [handshakeSize]byte
+		io.ReadFull(conn, handshake[:])
+		w := &streamWriter{conn: conn, w: bufio.NewWriter(conn), timeout: time.Second}
+		w.record(journal.Record{Seq: 1, Type: journal.RecordDeposit, Time: time.Now(), Payload: []byte("not json")})
+		w.flush()
+		io.Copy(io.Discard, conn)
+	}()
+
+	standby, _, standbyWAL := newStandby(t, listener.Addr().String(), filepath.Join(t.TempDir(), "epoch"))
+	done := make(chan error, 1)
+	go func() { done <- standby.Run(context.Background(), 0) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Expected Run to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Standby kept following after failing to apply a record")
+	}
+	if standbyWAL.NextSeq() != 2 {
+		t.Errorf("Expected the record journaled, next is %d", standbyWAL.NextSeq())
+	}
+	if status := standby.Status(); status.Error == "" {
+		t.Errorf("Expected the standby reported unhealthy, got %+v", status)
+	}
+	if err := standby.Promote(); !errors.Is(err, ErrUnhealthy) || standby.Promoted() {
+		t.Errorf("Expected Promote to refuse, got %v", err)
+	}
+}
+
+func TestEpochFence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "epoch")
+
+	standby := NewStandbyFence(path)
+	if err := standby.Check(); !errors.Is(err, engine.ErrNotPrimary) {
+		t.Errorf("Expected a standby fence to refuse, got %v", err)
+	}
+
+	first, err := AcquireFence(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Check(); err != nil {
+		t.Errorf("Expected the first primary to pass, got %v", err)
+	}
+	if err := standby.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if standby.Epoch() != first.Epoch()+1 {
+		t.Errorf("Expected epoch %d, got %d", first.Epoch()+1, standby.Epoch())
+	}
+	if err := standby.Check(); err != nil {
+		t.Errorf("Expected the promoted standby to pass, got %v", err)
+	}
+	if err := first.Check(); !errors.Is(err, engine.ErrFenced) {
+		t.Errorf("Expected the first primary to be fenced, got %v", err)
+	}
+	if epoch, err := ReadEpoch(path); err != nil || epoch != standby.Epoch() {
+		t.Errorf("Expected the file to hold epoch %d, got %d (%v)", standby.Epoch(), epoch, err)
+	}
+}
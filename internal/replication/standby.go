@@ -0,0 +1,351 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"matching-engine/internal/engine"
+	"matching-engine/internal/journal"
+	"matching-engine/pkg/utils"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrGap means the primary sent a record other than the next one
+var ErrGap = errors.New("replication sequence gap")
+
+var errPromoted = errors.New("standby promoted")
+
+// Standby copies the primary's journal into its own and applies every
+// record to its engine, ready to take over
+type Standby struct {
+	engine   *engine.MatchingEngine
+	journal  *journal.Journal
+	fence    *EpochFence
+	primary  string
+	replayer *engine.Replayer
+
+	// OnPromote, if set, runs once the standby has become primary
+	OnPromote func()
+	// Heartbeat is the primary's heartbeat interval; a primary silent for
+	// three of them is taken for dead
+	Heartbeat time.Duration
+	// RetryInterval is the pause between connection attempts
+	RetryInterval time.Duration
+
+	// applyMu is held while a record is applied, so Promote never runs in
+	// the middle of one
+	applyMu sync.Mutex
+
+	mu          sync.Mutex
+	conn        net.Conn
+	promoted    bool
+	stopping    bool
+	lastContact time.Time
+	primarySeq  uint64
+	// epoch is the primary's as of its last heartbeat
+	epoch uint64
+	// failed is why the standby stopped following, nil while healthy
+	failed error
+}
+
+// NewStandby returns a Standby following the primary at addr. The engine
+// must have fence set, so it refuses commands until promoted, and be
+// rebuilt from wal with Recover before Run.
+func NewStandby(e *engine.MatchingEngine, wal *journal.Journal, fence *EpochFence, addr string) *Standby {
+	return &Standby{
+		engine:        e,
+		journal:       wal,
+		fence:         fence,
+		primary:       addr,
+		replayer:      e.NewReplayer(),
+		Heartbeat:     DefaultHeartbeat,
+		RetryInterval: 500 * time.Millisecond,
+	}
+}
+
+// Recover rebuilds the engine from the newest snapshot in snapshotDir and
+// the local journal, and returns the last record applied
+func (s *Standby) Recover(snapshotDir string) (uint64, error) {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+	return s.replayer.Recover(snapshotDir, s.journal.Dir())
+}
+
+// Run follows the primary, reconnecting after every failure, until ctx is
+// done or the standby is promoted. With promoteAfter above zero, the standby
+// promotes itself once it has not heard from the primary for that long. A
+// record it cannot apply, or a primary refusing its journal, ends Run with
+// the standby marked unhealthy.
+func (s *Standby) Run(ctx context.Context, promoteAfter time.Duration) error {
+	s.mu.Lock()
+	s.lastContact = time.Now()
+	s.mu.Unlock()
+
+	for {
+		err := s.follow(ctx)
+		if errors.Is(err, errPromoted) || s.Promoted() {
+			return nil
+		}
+		if failed := s.Failed(); failed != nil {
+			return failed
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			utils.LogError(fmt.Errorf("replication from %s: %w", s.primary, err))
+		}
+
+		s.mu.Lock()
+		silent := time.Since(s.lastContact)
+		s.mu.Unlock()
+		if promoteAfter > 0 && silent >= promoteAfter {
+			utils.Logger.WithFields(logrus.Fields{
+				"primary": s.primary,
+				"silent":  silent.Round(time.Millisecond).String(),
+			}).Warn("Lost contact with primary, promoting")
+			return s.Promote()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.RetryInterval):
+		}
+	}
+}
+
+// follow streams from the primary until the connection fails
+func (s *Standby) follow(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: s.Heartbeat * 3}
+	conn, err := dialer.DialContext(ctx, "tcp", s.primary)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		return errPromoted
+	}
+	s.conn = conn
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	handshake, err := s.handshake()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(handshake); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.Heartbeat * 3))
+		kind, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case msgRecord:
+			record, err := journal.ReadRecord(r)
+			if err != nil {
+				return err
+			}
+			if err := s.copy(record); err != nil {
+				return err
+			}
+		case msgHeartbeat:
+			var body [16]byte
+			if _, err := io.ReadFull(r, body[:]); err != nil {
+				return err
+			}
+			epoch := binary.BigEndian.Uint64(body[0:8])
+			if current, err := ReadEpoch(s.fence.path); err == nil && epoch != current {
+				return fmt.Errorf("primary is at epoch %d, the epoch file at %d", epoch, current)
+			}
+			s.mu.Lock()
+			s.epoch = epoch
+			s.primarySeq = binary.BigEndian.Uint64(body[8:16])
+			s.mu.Unlock()
+		case msgRefused:
+			var length [2]byte
+			if _, err := io.ReadFull(r, length[:]); err != nil {
+				return err
+			}
+			reason := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(r, reason); err != nil {
+				return err
+			}
+			return s.fail(fmt.Errorf("primary %s refused this standby: %s", s.primary, reason))
+		default:
+			return fmt.Errorf("unknown replication message %q", kind)
+		}
+		s.mu.Lock()
+		s.lastContact = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+// handshake tells the primary where this journal ends
+func (s *Standby) handshake() ([]byte, error) {
+	handshake := make([]byte, handshakeSize)
+	next := s.journal.NextSeq()
+	binary.BigEndian.PutUint64(handshake[0:8], next)
+	s.mu.Lock()
+	binary.BigEndian.PutUint64(handshake[8:16], s.epoch)
+	s.mu.Unlock()
+	if next > 1 {
+		last, ok, err := journal.Find(s.journal.Dir(), next-1)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("reading record %d: %v", next-1, err)
+		}
+		binary.BigEndian.PutUint32(handshake[16:20], last.Checksum())
+	}
+	return handshake, nil
+}
+
+// fail marks the standby unhealthy and returns err
+func (s *Standby) fail(err error) error {
+	s.mu.Lock()
+	if s.failed == nil {
+		s.failed = err
+	}
+	s.mu.Unlock()
+	utils.Logger.WithFields(logrus.Fields{
+		"primary": s.primary,
+		"error":   err.Error(),
+	}).Error("Standby stopped following; restart it once the cause is fixed")
+	return err
+}
+
+// Failed returns why the standby stopped following, nil while it is healthy
+func (s *Standby) Failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+// copy journals a record from the primary and applies it
+func (s *Standby) copy(record journal.Record) error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.Lock()
+	stopping := s.stopping
+	s.mu.Unlock()
+	if stopping {
+		return errPromoted
+	}
+	if next := s.journal.NextSeq(); record.Seq != next {
+		return fmt.Errorf("%w: expected record %d, got %d", ErrGap, next, record.Seq)
+	}
+	if err := s.journal.AppendRecord(record); err != nil {
+		return err
+	}
+	if err := s.replayer.Apply(record); err != nil {
+		// The engine no longer matches the journal, so it must neither
+		// follow on nor take over
+		return s.fail(fmt.Errorf("applying replicated record %d: %w", record.Seq, err))
+	}
+	return nil
+}
+
+// Promote stops following the primary and makes this node primary. The
+// epoch is bumped first, so the old primary acknowledges nothing more. An
+// unhealthy standby refuses, as does one that cannot apply its last record.
+func (s *Standby) Promote() error {
+	s.mu.Lock()
+	if s.promoted {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.failed != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrUnhealthy, s.failed)
+	}
+	s.stopping = true
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	// The last new order is applied without its pool result before taking
+	// over, so a standby that cannot apply it never becomes primary
+	flushed := s.replayer.Pending()
+	if err := s.replayer.Flush(); err != nil {
+		err = s.fail(fmt.Errorf("applying replicated record %d: %w", s.replayer.Last(), err))
+		return fmt.Errorf("%w: %v", ErrUnhealthy, err)
+	}
+	if err := s.fence.Acquire(); err != nil {
+		err = fmt.Errorf("acquiring epoch: %w", err)
+		if flushed {
+			// A pool result the primary still sends would no longer apply
+			return s.fail(err)
+		}
+		s.mu.Lock()
+		s.stopping = false
+		s.mu.Unlock()
+		return err
+	}
+	s.engine.SetJournal(s.journal)
+
+	s.mu.Lock()
+	s.promoted = true
+	s.mu.Unlock()
+	utils.Logger.WithFields(logrus.Fields{
+		"epoch": s.fence.Epoch(),
+		"seq":   s.journal.NextSeq() - 1,
+	}).Info("Promoted to primary")
+	if s.OnPromote != nil {
+		s.OnPromote()
+	}
+	return nil
+}
+
+// Promoted reports whether the standby has become primary
+func (s *Standby) Promoted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.promoted
+}
+
+// Status implements Node
+func (s *Standby) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Role:        RoleStandby,
+		Epoch:       s.fence.Epoch(),
+		Seq:         s.journal.NextSeq() - 1,
+		PrimarySeq:  s.primarySeq,
+		LastContact: s.lastContact,
+	}
+	if s.failed != nil {
+		status.Error = s.failed.Error()
+	}
+	if s.promoted {
+		status.Role = RolePrimary
+		status.Fenced = s.fence.Check() != nil
+	}
+	return status
+}
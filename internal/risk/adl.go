@@ -0,0 +1,184 @@
+package risk
+
+import (
+	"sort"
+)
+
+// Position is an open position as seen by the risk subsystem
+type Position struct {
+	Trader     string  `json:"trader"`
+	Asset      string  `json:"asset"`
+	Isolated   bool    `json:"isolated"`
+	IsLong     bool    `json:"is_long"`
+	Size       float64 `json:"size"`
+	EntryPrice float64 `json:"entry_price"`
+	Leverage   int64   `json:"leverage"`
+}
+
+// UnrealizedPnL returns the profit or loss of the position at markPrice
+func (p Position) UnrealizedPnL(markPrice float64) float64 {
+	if p.IsLong {
+		return (markPrice - p.EntryPrice) * p.Size
+	}
+	return (p.EntryPrice - markPrice) * p.Size
+}
+
+// PositionProvider gives the ADL queue access to open positions of an asset
+type PositionProvider interface {
+	Positions(asset string) []Position
+}
+
+// ADLCandidate is a position ranked for auto-deleveraging
+type ADLCandidate struct {
+	Position
+	PnL   float64 `json:"pnl"`
+	Score float64 `json:"score"`
+}
+
+// RankADL ranks profitable positions on the given side by P&L ratio times
+// leverage, highest first. Those are the positions deleveraged first.
+func RankADL(positions []Position, markPrice float64, isLong bool) []ADLCandidate {
+	candidates := make([]ADLCandidate, 0)
+	for _, p := range positions {
+		if p.IsLong != isLong || p.Size <= 0 || p.EntryPrice <= 0 {
+			continue
+		}
+		pnl := p.UnrealizedPnL(markPrice)
+		if pnl <= 0 {
+			continue
+		}
+		leverage := p.Leverage
+		if leverage < 1 {
+			leverage = 1
+		}
+		candidates = append(candidates, ADLCandidate{
+			Position: p,
+			PnL:      pnl,
+			Score:    pnl / (p.EntryPrice * p.Size) * float64(leverage),
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Trader < candidates[j].Trader
+	})
+	return candidates
+}
+
+// Liquidation describes a filled liquidation order
+type Liquidation struct {
+	ID              string  `json:"id"`
+	Trader          string  `json:"trader"`
+	Asset           string  `json:"asset"`
+	SettlementAsset string  `json:"settlement_asset"`
+	Isolated        bool    `json:"isolated"`
+	IsLong          bool    `json:"is_long"`
+	Size            float64 `json:"size"`
+	BankruptcyPrice float64 `json:"bankruptcy_price"`
+	FillPrice       float64 `json:"fill_price"`
+}
+
+// Surplus returns how much better than the bankruptcy price the liquidation
+// filled, negative for a shortfall
+func (l Liquidation) Surplus() float64 {
+	diff := (l.FillPrice - l.BankruptcyPrice) * l.Size
+	if !l.IsLong {
+		diff = -diff
+	}
+	return diff
+}
+
+// Deleverage is a reduction of a counterparty position at the bankruptcy price
+type Deleverage struct {
+	Trader   string  `json:"trader"`
+	Asset    string  `json:"asset"`
+	Isolated bool    `json:"isolated"`
+	IsLong   bool    `json:"is_long"`
+	Size     float64 `json:"size"`
+	Price    float64 `json:"price"`
+}
+
+// LiquidationOutcome reports how a liquidation was settled
+type LiquidationOutcome struct {
+	Surplus     float64      `json:"surplus"`
+	Covered     float64      `json:"covered"`
+	Uncovered   float64      `json:"uncovered"`
+	Deleveraged []Deleverage `json:"deleveraged"`
+}
+
+// AutoDeleverager settles liquidations against the insurance fund and falls
+// back to deleveraging opposite-side positions when the fund is exhausted
+type AutoDeleverager struct {
+	fund      *InsuranceFund
+	positions PositionProvider
+}
+
+func NewAutoDeleverager(fund *InsuranceFund, positions PositionProvider) *AutoDeleverager {
+	return &AutoDeleverager{
+		fund:      fund,
+		positions: positions,
+	}
+}
+
+// SetPositionProvider sets the source of positions used for ranking
+func (a *AutoDeleverager) SetPositionProvider(positions PositionProvider) {
+	a.positions = positions
+}
+
+// Queue returns the ADL ranking of positions on the given side
+func (a *AutoDeleverager) Queue(asset string, markPrice float64, isLong bool) []ADLCandidate {
+	if a.positions == nil {
+		return []ADLCandidate{}
+	}
+	return RankADL(a.positions.Positions(asset), markPrice, isLong)
+}
+
+// Settle books the difference between the fill price and the bankruptcy price
+// of a liquidation. A surplus goes to the fund, a shortfall is taken from it,
+// and the part the fund cannot cover is closed against the ADL queue at the
+// bankruptcy price. The returned deleverages must be applied by the caller.
+func (a *AutoDeleverager) Settle(liq Liquidation, markPrice float64) LiquidationOutcome {
+	outcome := LiquidationOutcome{Deleveraged: make([]Deleverage, 0)}
+
+	diff := liq.Surplus()
+	if diff >= 0 {
+		// A surplus the trader cannot pay stays with the trader
+		if err := a.fund.Collect(liq.SettlementAsset, diff, liq.Trader, liq.ID); err == nil {
+			outcome.Surplus = diff
+		}
+		return outcome
+	}
+
+	shortfall := -diff
+	outcome.Covered, outcome.Uncovered = a.fund.Absorb(liq.SettlementAsset, shortfall, liq.Trader, liq.ID)
+	if outcome.Uncovered <= 0 {
+		return outcome
+	}
+
+	// The uncovered share of the position is taken over by the most
+	// profitable counterparties instead of being filled in the market
+	remaining := liq.Size * outcome.Uncovered / shortfall
+	for _, candidate := range a.Queue(liq.Asset, markPrice, !liq.IsLong) {
+		if remaining <= 0 {
+			break
+		}
+		size := candidate.Size
+		if size > remaining {
+			size = remaining
+		}
+		remaining -= size
+
+		outcome.Deleveraged = append(outcome.Deleveraged, Deleverage{
+			Trader:   candidate.Trader,
+			Asset:    liq.Asset,
+			Isolated: candidate.Isolated,
+			IsLong:   candidate.IsLong,
+			Size:     size,
+			Price:    liq.BankruptcyPrice,
+		})
+		a.fund.RecordDeleverage(liq.SettlementAsset, liq.Asset, size, candidate.Trader, liq.ID)
+	}
+	return outcome
+}
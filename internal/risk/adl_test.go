@@ -0,0 +1,137 @@
+package risk
+
+import (
+	"errors"
+	"matching-engine/internal/ledger"
+	"testing"
+)
+
+type staticPositions []Position
+
+func (p staticPositions) Positions(asset string) []Position {
+	positions := make([]Position, 0)
+	for _, position := range p {
+		if position.Asset == asset {
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
+func TestRankADLOrdersByPnLAndLeverage(t *testing.T) {
+	positions := []Position{
+		{Trader: "low-lev", Asset: "BTC", IsLong: false, Size: 1, EntryPrice: 110, Leverage: 1},
+		{Trader: "high-lev", Asset: "BTC", IsLong: false, Size: 1, EntryPrice: 110, Leverage: 10},
+		{Trader: "losing", Asset: "BTC", IsLong: false, Size: 1, EntryPrice: 90, Leverage: 20},
+		{Trader: "long", Asset: "BTC", IsLong: true, Size: 1, EntryPrice: 90, Leverage: 5},
+	}
+
+	queue := RankADL(positions, 100, false)
+
+	if len(queue) != 2 {
+		t.Fatalf("Expected 2 profitable short candidates, got %d", len(queue))
+	}
+	if queue[0].Trader != "high-lev" || queue[1].Trader != "low-lev" {
+		t.Errorf("Expected high-lev before low-lev, got %s, %s", queue[0].Trader, queue[1].Trader)
+	}
+}
+
+func TestSettleLiquidationSurplusAndShortfall(t *testing.T) {
+	fund := NewInsuranceFund()
+	adl := NewAutoDeleverager(fund, nil)
+
+	// Long liquidated above bankruptcy price: surplus goes to the fund
+	outcome := adl.Settle(Liquidation{
+		ID: "liq-1", Trader: "alice", Asset: "BTC", SettlementAsset: "USD",
+		IsLong: true, Size: 2, BankruptcyPrice: 90, FillPrice: 95,
+	}, 95)
+	if outcome.Surplus != 10 {
+		t.Errorf("Expected surplus 10, got %f", outcome.Surplus)
+	}
+
+	// Short liquidated above bankruptcy price: shortfall is absorbed
+	outcome = adl.Settle(Liquidation{
+		ID: "liq-2", Trader: "bob", Asset: "BTC", SettlementAsset: "USD",
+		IsLong: false, Size: 1, BankruptcyPrice: 110, FillPrice: 114,
+	}, 114)
+	if outcome.Covered != 4 || outcome.Uncovered != 0 {
+		t.Errorf("Expected 4 covered and 0 uncovered, got %f and %f", outcome.Covered, outcome.Uncovered)
+	}
+	if balance := fund.Balance("USD"); balance != 6 {
+		t.Errorf("Expected fund balance 6, got %f", balance)
+	}
+	if events := fund.Events(0); len(events) != 2 {
+		t.Errorf("Expected 2 audit events, got %d", len(events))
+	}
+}
+
+func TestSettleLiquidationFallsBackToADL(t *testing.T) {
+	fund := NewInsuranceFund()
+	fund.Deposit("USD", 5, "seed")
+	adl := NewAutoDeleverager(fund, staticPositions{
+		{Trader: "short-a", Asset: "BTC", IsLong: false, Size: 1, EntryPrice: 120, Leverage: 5},
+		{Trader: "short-b", Asset: "BTC", IsLong: false, Size: 5, EntryPrice: 105, Leverage: 2},
+	})
+
+	// 4 units of a long filled 5 below bankruptcy: 20 shortfall, 5 covered
+	outcome := adl.Settle(Liquidation{
+		ID: "liq-3", Trader: "carol", Asset: "BTC", SettlementAsset: "USD",
+		IsLong: true, Size: 4, BankruptcyPrice: 100, FillPrice: 95,
+	}, 95)
+
+	if outcome.Covered != 5 || outcome.Uncovered != 15 {
+		t.Fatalf("Expected 5 covered and 15 uncovered, got %f and %f", outcome.Covered, outcome.Uncovered)
+	}
+	if len(outcome.Deleveraged) != 2 {
+		t.Fatalf("Expected 2 deleveraged positions, got %d", len(outcome.Deleveraged))
+	}
+	if first := outcome.Deleveraged[0]; first.Trader != "short-a" || first.Size != 1 || first.Price != 100 {
+		t.Errorf("Unexpected first deleverage: %+v", first)
+	}
+	if second := outcome.Deleveraged[1]; second.Trader != "short-b" || second.Size != 2 {
+		t.Errorf("Unexpected second deleverage: %+v", second)
+	}
+	if balance := fund.Balance("USD"); balance != 0 {
+		t.Errorf("Expected exhausted fund, got balance %f", balance)
+	}
+}
+
+func TestFundChangesFollowTheBooks(t *testing.T) {
+	fund := NewInsuranceFund()
+	type move struct {
+		from, to string
+		amount   float64
+	}
+	moves := make([]move, 0)
+	refuse := false
+	fund.SetBooks("insurance", func(entryType ledger.EntryType, from string, to string, asset string, amount float64, reference string) error {
+		if refuse {
+			return errors.New("insufficient funds")
+		}
+		moves = append(moves, move{from, to, amount})
+		return nil
+	})
+
+	if err := fund.Deposit("USD", 5, "seed"); err != nil {
+		t.Fatal(err)
+	}
+	if covered, _ := fund.Absorb("USD", 2, "bob", "liq-1"); covered != 2 {
+		t.Errorf("Expected 2 covered, got %f", covered)
+	}
+	expected := []move{{ledger.ExternalAccount, "insurance", 5}, {"insurance", "bob", 2}}
+	if len(moves) != len(expected) || moves[0] != expected[0] || moves[1] != expected[1] {
+		t.Errorf("Expected moves %+v, got %+v", expected, moves)
+	}
+
+	// Changes the books refuse are not made
+	refuse = true
+	if err := fund.Collect("USD", 1, "alice", "liq-2"); err == nil {
+		t.Error("Expected a refused collection to fail")
+	}
+	if covered, uncovered := fund.Absorb("USD", 1, "bob", "liq-3"); covered != 0 || uncovered != 1 {
+		t.Errorf("Expected a refused payment to leave the shortfall uncovered, got %f and %f", covered, uncovered)
+	}
+	if balance := fund.Balance("USD"); balance != 3 {
+		t.Errorf("Expected fund balance 3, got %f", balance)
+	}
+}
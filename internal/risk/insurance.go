@@ -0,0 +1,211 @@
+package risk
+
+import (
+	"fmt"
+	"matching-engine/internal/ledger"
+	"sync"
+	"time"
+)
+
+// AuditEventType identifies a change made by the insurance fund or the ADL process
+type AuditEventType string
+
+const (
+	AuditDeposit        AuditEventType = "deposit"
+	AuditSurplus        AuditEventType = "liquidation_surplus"
+	AuditShortfall      AuditEventType = "liquidation_shortfall"
+	AuditFundExhausted  AuditEventType = "fund_exhausted"
+	AuditAutoDeleverage AuditEventType = "auto_deleverage"
+)
+
+// AuditEvent is an immutable record of a balance change or deleveraging action
+type AuditEvent struct {
+	Seq          uint64         `json:"seq"`
+	Time         time.Time      `json:"time"`
+	Type         AuditEventType `json:"type"`
+	Asset        string         `json:"asset"`
+	Instrument   string         `json:"instrument,omitempty"`
+	Amount       float64        `json:"amount"`
+	BalanceAfter float64        `json:"balance_after"`
+	Trader       string         `json:"trader,omitempty"`
+	Reference    string         `json:"reference,omitempty"`
+}
+
+// Transfer moves amount of asset from one account of the books to another,
+// posting it to the ledger as entryType
+type Transfer func(entryType ledger.EntryType, from string, to string, asset string, amount float64, reference string) error
+
+// InsuranceFund holds one balance per settlement asset. It absorbs losses of
+// liquidations filled worse than the bankruptcy price and collects the surplus
+// of those filled better.
+type InsuranceFund struct {
+	mu       sync.Mutex
+	balances map[string]float64
+	events   []AuditEvent
+	seq      uint64
+	now      func() time.Time
+	// account and transfer mirror the fund in the books, see SetBooks
+	account  string
+	transfer Transfer
+}
+
+func NewInsuranceFund() *InsuranceFund {
+	return &InsuranceFund{
+		balances: make(map[string]float64),
+		events:   make([]AuditEvent, 0),
+		now:      time.Now,
+	}
+}
+
+// SetClock replaces the clock audit events are stamped with
+func (f *InsuranceFund) SetClock(now func() time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// SetBooks makes every change to the fund move the same amount between
+// account and the counterparty in the books, so it is posted to the ledger. A
+// change the books refuse is not made.
+func (f *InsuranceFund) SetBooks(account string, transfer Transfer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.account = account
+	f.transfer = transfer
+}
+
+// move mirrors a change to the fund in the books; it must be called with
+// f.mu held
+func (f *InsuranceFund) move(entryType ledger.EntryType, from string, to string, asset string, amount float64, reference string) error {
+	if f.transfer == nil {
+		return nil
+	}
+	if err := f.transfer(entryType, from, to, asset, amount, reference); err != nil {
+		return fmt.Errorf("moving %.8f %s from %s to %s: %w", amount, asset, from, to, err)
+	}
+	return nil
+}
+
+// Deposit tops up the fund for the given settlement asset from outside
+func (f *InsuranceFund) Deposit(asset string, amount float64, reference string) error {
+	if amount <= 0 {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.move(ledger.EntryDeposit, ledger.ExternalAccount, f.account, asset, amount, reference); err != nil {
+		return err
+	}
+	f.balances[asset] += amount
+	f.record(AuditEvent{Type: AuditDeposit, Asset: asset, Amount: amount, Reference: reference})
+	return nil
+}
+
+// Collect credits a liquidation surplus, paid by trader, to the fund
+func (f *InsuranceFund) Collect(asset string, surplus float64, trader string, reference string) error {
+	if surplus <= 0 {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.move(ledger.EntryLiquidation, trader, f.account, asset, surplus, reference); err != nil {
+		return err
+	}
+	f.balances[asset] += surplus
+	f.record(AuditEvent{Type: AuditSurplus, Asset: asset, Amount: surplus, Trader: trader, Reference: reference})
+	return nil
+}
+
+// Absorb covers as much of a liquidation shortfall as the balance allows,
+// paying it to trader, and returns the covered and uncovered parts. A payment
+// the books refuse leaves the whole shortfall uncovered.
+func (f *InsuranceFund) Absorb(asset string, shortfall float64, trader string, reference string) (float64, float64) {
+	if shortfall <= 0 {
+		return 0, 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	covered := shortfall
+	if balance := f.balances[asset]; balance < covered {
+		covered = balance
+	}
+	if covered > 0 {
+		if err := f.move(ledger.EntryLiquidation, f.account, trader, asset, covered, reference); err != nil {
+			covered = 0
+		}
+	}
+	if covered > 0 {
+		f.balances[asset] -= covered
+		f.record(AuditEvent{Type: AuditShortfall, Asset: asset, Amount: -covered, Trader: trader, Reference: reference})
+	}
+
+	uncovered := shortfall - covered
+	if uncovered > 0 {
+		f.record(AuditEvent{Type: AuditFundExhausted, Asset: asset, Amount: uncovered, Trader: trader, Reference: reference})
+	}
+	return covered, uncovered
+}
+
+// Balance returns the current balance for a settlement asset
+func (f *InsuranceFund) Balance(asset string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.balances[asset]
+}
+
+// Restore replaces all balances, e.g. with those of a snapshot. The audit
+// trail is not restored.
+func (f *InsuranceFund) Restore(balances map[string]float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.balances = make(map[string]float64, len(balances))
+	for asset, balance := range balances {
+		f.balances[asset] = balance
+	}
+}
+
+// Balances returns a copy of all balances
+func (f *InsuranceFund) Balances() map[string]float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	balances := make(map[string]float64, len(f.balances))
+	for asset, balance := range f.balances {
+		balances[asset] = balance
+	}
+	return balances
+}
+
+// Events returns the audit events with a sequence number greater than afterSeq
+func (f *InsuranceFund) Events(afterSeq uint64) []AuditEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := make([]AuditEvent, 0)
+	for _, event := range f.events {
+		if event.Seq > afterSeq {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// RecordDeleverage adds an ADL action against a trader's position to the audit trail
+func (f *InsuranceFund) RecordDeleverage(asset string, instrument string, size float64, trader string, reference string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(AuditEvent{Type: AuditAutoDeleverage, Asset: asset, Instrument: instrument, Amount: size, Trader: trader, Reference: reference})
+}
+
+// record stamps and stores an event; it must be called with f.mu held
+func (f *InsuranceFund) record(event AuditEvent) {
+	f.seq++
+	event.Seq = f.seq
+	event.Time = f.now()
+	event.BalanceAfter = f.balances[event.Asset]
+	f.events = append(f.events, event)
+}
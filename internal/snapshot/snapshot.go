@@ -0,0 +1,186 @@
+// Package snapshot stores engine state in versioned, checksummed files named
+// after the journal sequence number they cover. Each file is
+//
+//	[6 magic "MESNAP"][2 version][8 seq][4 crc32c][8 length][body]
+//
+// where the checksum covers the body and the body is gob encoded. Integers
+// are big endian. Files are written to a temporary name and renamed into
+// place, so a crash never leaves a partial snapshot behind.
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the format written by Save. Load rejects other versions.
+const Version = 1
+
+const (
+	magic      = "MESNAP"
+	headerSize = 6 + 2 + 8 + 4 + 8
+	fileExt    = ".snap"
+)
+
+var (
+	ErrCorrupt = errors.New("snapshot corrupt")
+	ErrVersion = errors.New("unsupported snapshot version")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Info describes a snapshot file
+type Info struct {
+	Path string
+	Seq  uint64
+}
+
+// FileName returns the name of the snapshot covering the journal up to seq
+func FileName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, fileExt)
+}
+
+// Save writes state as the snapshot covering the journal up to seq and
+// returns its path
+func Save(dir string, seq uint64, state interface{}) (string, error) {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(state); err != nil {
+		return "", fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[6:8], Version)
+	binary.BigEndian.PutUint64(header[8:16], seq)
+	binary.BigEndian.PutUint32(header[16:20], crc32.Checksum(body.Bytes(), crcTable))
+	binary.BigEndian.PutUint64(header[20:28], uint64(body.Len()))
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, FileName(seq))
+	tmp, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(header); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if _, err := tmp.Write(body.Bytes()); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, syncDir(dir)
+}
+
+// Load decodes the snapshot at path into state and returns the journal
+// sequence number it covers
+func Load(path string, state interface{}) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, fmt.Errorf("%w: short header", ErrCorrupt)
+	}
+	if string(header[:6]) != magic {
+		return 0, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	if version := binary.BigEndian.Uint16(header[6:8]); version != Version {
+		return 0, fmt.Errorf("%w %d", ErrVersion, version)
+	}
+	seq := binary.BigEndian.Uint64(header[8:16])
+	length := binary.BigEndian.Uint64(header[20:28])
+
+	body, err := io.ReadAll(io.LimitReader(file, int64(length)+1))
+	if err != nil {
+		return 0, err
+	}
+	if uint64(len(body)) != length {
+		return 0, fmt.Errorf("%w: body is %d bytes, expected %d", ErrCorrupt, len(body), length)
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[16:20]) {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(state); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return seq, nil
+}
+
+// List returns the snapshots in dir, oldest first
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]Info, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, fileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Info{Path: filepath.Join(dir, name), Seq: seq})
+	}
+	sort.Slice(snapshots, func(i, k int) bool { return snapshots[i].Seq < snapshots[k].Seq })
+	return snapshots, nil
+}
+
+// Prune deletes all but the newest keep snapshots
+func Prune(dir string, keep int) error {
+	snapshots, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for len(snapshots) > keep {
+		if err := os.Remove(snapshots[0].Path); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	d.Sync()
+	return nil
+}
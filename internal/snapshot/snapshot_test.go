@@ -0,0 +1,74 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+type state struct {
+	Name   string
+	Values map[string]float64
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	for _, seq := range []uint64{7, 3, 12} {
+		if _, err := Save(dir, seq, state{Name: "book", Values: map[string]float64{"BTC": float64(seq)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 || snapshots[0].Seq != 3 || snapshots[2].Seq != 12 {
+		t.Fatalf("Expected snapshots 3, 7 and 12, got %+v", snapshots)
+	}
+
+	var loaded state
+	seq, err := Load(snapshots[2].Path, &loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 12 || loaded.Name != "book" || loaded.Values["BTC"] != 12 {
+		t.Errorf("Unexpected snapshot %d: %+v", seq, loaded)
+	}
+
+	if err := Prune(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := List(dir); len(snapshots) != 1 || snapshots[0].Seq != 12 {
+		t.Errorf("Expected only snapshot 12 to be kept, got %+v", snapshots)
+	}
+}
+
+func TestDamagedSnapshotsAreRejected(t *testing.T) {
+	dir := t.TempDir()
+	path, err := Save(dir, 1, state{Name: "book"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	os.WriteFile(path, corrupt, 0o644)
+	if _, err := Load(path, &state{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for a flipped byte, got %v", err)
+	}
+
+	os.WriteFile(path, data[:len(data)-3], 0o644)
+	if _, err := Load(path, &state{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for a truncated file, got %v", err)
+	}
+
+	future := append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(future[6:8], Version+1)
+	os.WriteFile(path, future, 0o644)
+	if _, err := Load(path, &state{}); !errors.Is(err, ErrVersion) {
+		t.Errorf("Expected ErrVersion, got %v", err)
+	}
+}